@@ -1,10 +1,10 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -14,39 +14,74 @@ import (
 	"github.com/hsrvms/todoapp/utils"
 )
 
-func WithJWTAuth(handlerFunc http.HandlerFunc, store store.Store) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Get the token from the request (Auth header)
-		tokenString := GetTokenFromRequest(r)
-		// Validate the token
-		token, err := validateJWT(tokenString)
-		if err != nil {
-			log.Println("failed to authenticate token")
-			permissionDenied(w)
-			return
-		}
+type contextKey string
 
-		if !token.Valid {
-			log.Println("failed to authenticate token")
-			permissionDenied(w)
-			return
-		}
-		// Get the userId from the token
-		claims := token.Claims.(jwt.MapClaims)
-		userID := claims["userID"].(string)
-
-		_, err = store.GetUserByID(userID)
-		if err != nil {
-			log.Println("failed to get user")
-			permissionDenied(w)
-			return
-		}
+const userIDKey contextKey = "userID"
+
+// JWTMiddleware authenticates requests with the JWT of the Authorization
+// header or the token query parameter, rejecting those without a token
+// signed with the secret for an existing user. The user ID is available to
+// the handlers with GetUserIDFromContext.
+func JWTMiddleware(store store.Store, secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Get the token from the request (Auth header)
+			tokenString := GetTokenFromRequest(r)
+			// Validate the token and get the userId from it
+			id, err := GetUserIDFromToken(secret, tokenString)
+			if err != nil {
+				log.Println(err)
+				permissionDenied(w)
+				return
+			}
+			userID := strconv.FormatInt(id, 10)
+
+			user, err := store.GetUserByID(userID)
+			if err != nil || user == nil {
+				log.Println("failed to get user")
+				permissionDenied(w)
+				return
+			}
 
-		// Call the handler fun and continue to the endpoint
-		handlerFunc(w, r)
+			// Call the handler fun and continue to the endpoint
+			next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), id)))
+		})
 	}
 }
 
+// WithUserID returns a copy of the context carrying the ID of the
+// authenticated user, for authentication middlewares other than
+// JWTMiddleware.
+func WithUserID(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+// GetUserIDFromToken validates the token against the secret and returns the
+// ID of the user it was issued to. Unlike JWTMiddleware, it does not check
+// that the user still exists.
+func GetUserIDFromToken(secret []byte, tokenString string) (int64, error) {
+	token, err := validateJWT(secret, tokenString)
+	if err != nil || !token.Valid {
+		return 0, fmt.Errorf("failed to authenticate token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["userID"].(string)
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse user id")
+	}
+
+	return id, nil
+}
+
+// GetUserIDFromContext returns the ID of the user authenticated by
+// JWTMiddleware, or set by WithUserID.
+func GetUserIDFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(userIDKey).(int64)
+	return id, ok
+}
+
 // CreateJWT creates a JWT token with the given secret and userID.
 func CreateJWT(secret []byte, userID int64) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
@@ -76,14 +111,12 @@ func GetTokenFromRequest(r *http.Request) string {
 	return ""
 }
 
-func validateJWT(ts string) (*jwt.Token, error) {
-	secret := os.Getenv("JWT_SECRET")
-
+func validateJWT(secret []byte, ts string) (*jwt.Token, error) {
 	return jwt.Parse(ts, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
 		}
-		return []byte(secret), nil
+		return secret, nil
 	})
 }
 
@@ -0,0 +1,382 @@
+// Package client is a Go client for the /api/v1 REST API.
+//
+//	c := client.New("https://todo.example.com")
+//	if err := c.Login(ctx, "johnDoe", "secretPassword"); err != nil {
+//		...
+//	}
+//	task, err := c.CreateTask(ctx, &models.Task{Title: "Learn Golang"})
+//
+// Requests failing with a network error or a 5xx status are retried with
+// exponential backoff. Mutating requests carry an Idempotency-Key, so a
+// retry never applies a change twice. After Login or Register, a request
+// rejected with 401 because the session expired logs in again and is
+// retried once.
+//
+// Error responses are returned as *APIError, which matches ErrNotFound,
+// ErrUnauthorized, ErrConflict and ErrInvalid with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/types"
+	"github.com/hsrvms/todoapp/validate"
+)
+
+const (
+	apiPrefix = "/api/v1"
+
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrConflict     = errors.New("conflict")
+	// ErrInvalid matches requests rejected as invalid (400 and 422).
+	ErrInvalid = errors.New("invalid request")
+)
+
+// APIError is an error response of the API.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the error message sent by the server.
+	Message string
+	// Fields lists the failures of the fields of an invalid payload.
+	Fields validate.Errors
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is reports whether the error is of the kind of target, one of the Err
+// variables of this package.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	}
+	return false
+}
+
+// Client calls the API of a server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+
+	mu       sync.Mutex
+	token    string
+	username string
+	password string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client sending the requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithToken sets the session token, e.g. one cached from an earlier Login.
+// The client cannot log in again when it expires.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries sets the number of retries of a failed request and the delay
+// before the first one, which doubles with every further retry.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = retries, backoff }
+}
+
+// New creates a Client for the server at baseURL, e.g.
+// "https://todo.example.com".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Token returns the current session token.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// Register creates an account and logs in to it.
+func (c *Client) Register(ctx context.Context, username, password string) error {
+	return c.authenticate(ctx, "/auth/register", username, password)
+}
+
+// Login logs in. The credentials are kept to log in again when the session
+// expires.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	return c.authenticate(ctx, "/auth/login", username, password)
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c *Client) authenticate(ctx context.Context, path, username, password string) error {
+	var token string
+	creds := credentials{Username: username, Password: password}
+	if err := c.send(ctx, http.MethodPost, path, creds, &token, ""); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.username, c.password = token, username, password
+	return nil
+}
+
+// ListOptions narrows the tasks returned by ListTasks.
+type ListOptions struct {
+	// Archived lists the archived tasks instead of the active ones.
+	Archived bool
+}
+
+func (c *Client) ListTasks(ctx context.Context, opts ListOptions) ([]*models.Task, error) {
+	params := url.Values{}
+	if opts.Archived {
+		params.Set("archived", "true")
+	}
+
+	var tasks []*models.Task
+	if err := c.do(ctx, http.MethodGet, "/tasks?"+params.Encode(), nil, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// SearchTasks returns the tasks matching q, written in the query language
+// of GET /tasks/search, most relevant first.
+func (c *Client) SearchTasks(ctx context.Context, q string, opts ListOptions) ([]*models.Task, error) {
+	params := url.Values{"q": {q}, "limit": {"100"}}
+	if opts.Archived {
+		params.Set("archived", "true")
+	}
+
+	var results []*models.TaskSearchResult
+	if err := c.do(ctx, http.MethodGet, "/tasks/search?"+params.Encode(), nil, &results); err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*models.Task, len(results))
+	for i, r := range results {
+		tasks[i] = r.Task
+	}
+	return tasks, nil
+}
+
+// taskRequest holds the fields of a task that clients set.
+type taskRequest struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Status      models.TaskStatus `json:"status"`
+	DueAt       *time.Time        `json:"due_at"`
+	Priority    string            `json:"priority"`
+}
+
+func newTaskRequest(task *models.Task) *taskRequest {
+	return &taskRequest{
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		DueAt:       task.DueAt,
+		Priority:    task.Priority,
+	}
+}
+
+func (c *Client) CreateTask(ctx context.Context, task *models.Task) (*models.Task, error) {
+	var created models.Task
+	if err := c.do(ctx, http.MethodPost, "/tasks", newTaskRequest(task), &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) GetTask(ctx context.Context, id int64) (*models.Task, error) {
+	var task models.Task
+	if err := c.do(ctx, http.MethodGet, taskPath(id), nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// UpdateTask replaces the task's title, description, status, due date and
+// priority with those of task.
+func (c *Client) UpdateTask(ctx context.Context, id int64, task *models.Task) (*models.Task, error) {
+	var updated models.Task
+	if err := c.do(ctx, http.MethodPut, taskPath(id), newTaskRequest(task), &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteTask deletes the task and returns it as it was.
+func (c *Client) DeleteTask(ctx context.Context, id int64) (*models.Task, error) {
+	var deleted models.Task
+	if err := c.do(ctx, http.MethodDelete, taskPath(id), nil, &deleted); err != nil {
+		return nil, err
+	}
+	return &deleted, nil
+}
+
+func taskPath(id int64) string {
+	return "/tasks/" + strconv.FormatInt(id, 10)
+}
+
+// do sends an authenticated request, logging in again once if the session
+// has expired.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	c.mu.Lock()
+	token, username, password := c.token, c.username, c.password
+	c.mu.Unlock()
+
+	err := c.send(ctx, method, path, body, out, token)
+	if !errors.Is(err, ErrUnauthorized) || username == "" {
+		return err
+	}
+
+	if err := c.Login(ctx, username, password); err != nil {
+		return err
+	}
+	return c.send(ctx, method, path, body, out, c.Token())
+}
+
+// send sends a request, retrying it on network errors and 5xx responses,
+// and decodes the JSON response into out unless out is nil.
+func (c *Client) send(ctx context.Context, method, path string, body, out any, token string) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	var idempotencyKey string
+	if method != http.MethodGet {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.retryDelay(attempt)):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			lastErr = newAPIError(method, path, resp.StatusCode, respBody)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return newAPIError(method, path, resp.StatusCode, respBody)
+		}
+
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(respBody, out)
+	}
+
+	return lastErr
+}
+
+func (c *Client) retryDelay(attempt int) time.Duration {
+	delay := c.backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// newAPIError reads the message of an error response, which is plain text
+// or a types.ErrorResponse.
+func newAPIError(method, path string, status int, body []byte) *APIError {
+	apiErr := &APIError{Method: method, Path: path, StatusCode: status, Message: strings.TrimSpace(string(body))}
+	var e types.ErrorResponse
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		apiErr.Message, apiErr.Fields = e.Error, e.Fields
+	}
+	return apiErr
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
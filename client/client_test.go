@@ -0,0 +1,172 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/client"
+	"github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/server"
+	"github.com/hsrvms/todoapp/store"
+)
+
+// newHandler returns the API served from st, signing tokens with the
+// secret.
+func newHandler(st store.Store, secret string) http.Handler {
+	cfg := config.Default()
+	cfg.JWTSecret = secret
+	return server.NewAPIServer(cfg, st, nil).Handler()
+}
+
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+
+	handler := newHandler(store.NewMockStore(), "testSecret")
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ctx := context.Background()
+
+	c := client.New(ts.URL)
+	if _, err := c.ListTasks(ctx, client.ListOptions{}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("ListTasks before login: got %v, want ErrUnauthorized", err)
+	}
+
+	if err := c.Register(ctx, "janeDoe", "secretPassword"); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := c.CreateTask(ctx, &models.Task{Title: "Learn Golang", Priority: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 0 || created.Title != "Learn Golang" || created.Status != models.StatusTodo {
+		t.Errorf("CreateTask: got %+v", created)
+	}
+
+	if _, err := c.CreateTask(ctx, &models.Task{}); !errors.Is(err, client.ErrInvalid) {
+		t.Errorf("CreateTask without title: got %v, want ErrInvalid", err)
+	}
+
+	got, err := c.GetTask(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Status = models.StatusDone
+	updated, err := c.UpdateTask(ctx, got.ID, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != models.StatusDone || updated.Priority != "A" {
+		t.Errorf("UpdateTask: got %+v", updated)
+	}
+
+	tasks, err := c.ListTasks(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != created.ID {
+		t.Errorf("ListTasks: got %+v", tasks)
+	}
+
+	if _, err := c.DeleteTask(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetTask(ctx, created.ID)
+	var apiErr *client.APIError
+	if !errors.Is(err, client.ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Message != "Task not found" {
+		t.Errorf("GetTask after delete: got %v", err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	// The first response of every request is lost after the server handled
+	// it, so that only the Idempotency-Key keeps the retry from creating a
+	// second task.
+	var failures atomic.Int32
+	ts := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/api/v1/tasks" || failures.Add(1) > 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+		})
+	})
+	ctx := context.Background()
+
+	c := client.New(ts.URL, client.WithRetries(2, time.Millisecond))
+	if err := c.Register(ctx, "janeDoe", "secretPassword"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateTask(ctx, &models.Task{Title: "Learn Golang"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err := c.ListTasks(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("got %d tasks, want 1", len(tasks))
+	}
+
+	noRetries := client.New(ts.URL, client.WithRetries(0, 0), client.WithToken(c.Token()))
+	failures.Store(0)
+	_, err = noRetries.CreateTask(ctx, &models.Task{Title: "Call the bank"})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("CreateTask without retries: got %v, want 502", err)
+	}
+}
+
+func TestClientRelogin(t *testing.T) {
+	st := store.NewMockStore()
+	handler, rotated := newHandler(st, "testSecret"), newHandler(st, "rotatedSecret")
+	var rotate atomic.Bool
+	ts := newTestServer(t, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rotate.Load() {
+				rotated.ServeHTTP(w, r)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+	})
+	ctx := context.Background()
+
+	c := client.New(ts.URL)
+	if err := c.Register(ctx, "janeDoe", "secretPassword"); err != nil {
+		t.Fatal(err)
+	}
+	oldToken := c.Token()
+
+	// Rotating the secret invalidates the session.
+	rotate.Store(true)
+
+	if _, err := c.ListTasks(ctx, client.ListOptions{}); err != nil {
+		t.Fatalf("ListTasks after the session expired: %v", err)
+	}
+	if c.Token() == oldToken {
+		t.Error("token was not refreshed")
+	}
+
+	cached := client.New(ts.URL, client.WithToken(oldToken))
+	if _, err := cached.ListTasks(ctx, client.ListOptions{}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("ListTasks with an expired cached token: got %v, want ErrUnauthorized", err)
+	}
+}
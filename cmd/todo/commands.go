@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/client"
+	"github.com/hsrvms/todoapp/models"
+)
+
+func setupLogin(fs *flag.FlagSet) func(e *env, args []string) error {
+	server := fs.String("server", "", "server URL (default: the cached one, or "+defaultServer+")")
+	username := fs.String("username", "", "username (default: the cached one)")
+
+	return func(e *env, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		if *server != "" {
+			e.cfg.Server = *server
+		}
+		if *username != "" {
+			e.cfg.Username = *username
+		}
+		if e.cfg.Username == "" {
+			name, err := e.prompt("Username: ")
+			if err != nil {
+				return err
+			}
+			e.cfg.Username = name
+		}
+
+		password := os.Getenv("TODO_PASSWORD")
+		if password == "" {
+			var err error
+			if password, err = e.prompt("Password: "); err != nil {
+				return err
+			}
+		}
+
+		e.client = newClient(e.cfg)
+		if err := e.client.Login(context.Background(), e.cfg.Username, password); err != nil {
+			return err
+		}
+
+		e.cfg.Token = e.client.Token()
+		if err := e.cfg.save(); err != nil {
+			return fmt.Errorf("saving config: %w", err)
+		}
+		fmt.Fprintf(e.stdout, "Logged in to %s as %s\n", e.cfg.Server, e.cfg.Username)
+		return nil
+	}
+}
+
+func setupAdd(fs *flag.FlagSet) func(e *env, args []string) error {
+	description := fs.String("d", "", "description")
+	due := fs.String("due", "", "due date")
+	priority := fs.String("p", "", "priority, A (highest) to Z")
+	output := outputFlag(fs)
+
+	return func(e *env, args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+		task := &models.Task{
+			Title:       strings.Join(args, " "),
+			Description: *description,
+			Priority:    strings.ToUpper(*priority),
+		}
+		if *due != "" {
+			t, err := parseDate(*due)
+			if err != nil {
+				return err
+			}
+			task.DueAt = &t
+		}
+
+		created, err := e.client.CreateTask(context.Background(), task)
+		if err != nil {
+			return err
+		}
+		return e.print(*output, []*models.Task{created})
+	}
+}
+
+func setupList(fs *flag.FlagSet) func(e *env, args []string) error {
+	statuses := fs.String("status", "", "comma separated statuses to list")
+	priority := fs.String("p", "", "list only tasks of this priority")
+	archived := fs.Bool("archived", false, "list archived tasks instead of active ones")
+	q := fs.String("q", "", "search query, e.g. \"golang status:open\"")
+	output := outputFlag(fs)
+
+	return func(e *env, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+
+		opts := client.ListOptions{Archived: *archived}
+		var tasks []*models.Task
+		var err error
+		if *q != "" {
+			tasks, err = e.client.SearchTasks(context.Background(), *q, opts)
+		} else {
+			tasks, err = e.client.ListTasks(context.Background(), opts)
+		}
+		if err != nil {
+			return err
+		}
+
+		var wanted []string
+		if *statuses != "" {
+			wanted = strings.Split(*statuses, ",")
+		}
+		tasks = slices.DeleteFunc(tasks, func(t *models.Task) bool {
+			return (wanted != nil && !slices.Contains(wanted, string(t.Status))) ||
+				(*priority != "" && !strings.EqualFold(t.Priority, *priority))
+		})
+		return e.print(*output, tasks)
+	}
+}
+
+func setupDone(fs *flag.FlagSet) func(e *env, args []string) error {
+	output := outputFlag(fs)
+
+	return func(e *env, args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+		var tasks []*models.Task
+		for _, id := range args {
+			task, err := e.updateTask(id, func(t *models.Task) error {
+				t.Status = models.StatusDone
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, task)
+		}
+		return e.print(*output, tasks)
+	}
+}
+
+func setupEdit(fs *flag.FlagSet) func(e *env, args []string) error {
+	title := fs.String("title", "", "new title")
+	description := fs.String("d", "", "new description")
+	status := fs.String("status", "", "new status")
+	due := fs.String("due", "", `new due date, or "none" to clear it`)
+	priority := fs.String("p", "", `new priority, or "none" to clear it`)
+	output := outputFlag(fs)
+
+	return func(e *env, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		task, err := e.updateTask(args[0], func(t *models.Task) error {
+			if set["title"] {
+				t.Title = *title
+			}
+			if set["d"] {
+				t.Description = *description
+			}
+			if set["status"] {
+				t.Status = models.TaskStatus(*status)
+			}
+			if set["p"] {
+				t.Priority = strings.ToUpper(*priority)
+				if t.Priority == "NONE" {
+					t.Priority = ""
+				}
+			}
+			if set["due"] {
+				t.DueAt = nil
+				if *due != "none" {
+					d, err := parseDate(*due)
+					if err != nil {
+						return err
+					}
+					t.DueAt = &d
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return e.print(*output, []*models.Task{task})
+	}
+}
+
+func setupRemove(fs *flag.FlagSet) func(e *env, args []string) error {
+	return func(e *env, args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+		for _, id := range args {
+			taskID, err := parseTaskID(id)
+			if err != nil {
+				return err
+			}
+			if _, err := e.client.DeleteTask(context.Background(), taskID); err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "Deleted task %d\n", taskID)
+		}
+		return nil
+	}
+}
+
+// updateTask fetches the task, applies change to it and saves it. The API
+// replaces tasks as a whole on update.
+func (e *env) updateTask(id string, change func(t *models.Task) error) (*models.Task, error) {
+	taskID, err := parseTaskID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	task, err := e.client.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := change(task); err != nil {
+		return nil, err
+	}
+	return e.client.UpdateTask(ctx, taskID, task)
+}
+
+func parseTaskID(id string) (int64, error) {
+	taskID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid task ID %q", id)
+	}
+	return taskID, nil
+}
+
+// prompt prints the question and reads a line of input.
+func (e *env) prompt(question string) (string, error) {
+	fmt.Fprint(e.stderr, question)
+	line, err := e.stdin.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err != nil {
+			return "", fmt.Errorf("reading input: %w", err)
+		}
+		return "", errors.New("no input given")
+	}
+	return line, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or RFC 3339", s)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+func setupCompletion(fs *flag.FlagSet) func(e *env, args []string) error {
+	return func(e *env, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+
+		switch args[0] {
+		case "bash":
+			fmt.Fprint(e.stdout, bashCompletion())
+		case "zsh":
+			fmt.Fprint(e.stdout, "autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion())
+		case "fish":
+			fmt.Fprint(e.stdout, fishCompletion())
+		default:
+			return errUsage
+		}
+		return nil
+	}
+}
+
+// bashCompletion completes command names, then the flags of the command.
+// Load it with: source <(todo completion bash)
+func bashCompletion() string {
+	var b strings.Builder
+	b.WriteString("_todo() {\n")
+	b.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n")
+	b.WriteString("\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	b.WriteString("\t\treturn\n\tfi\n")
+	b.WriteString("\tcase ${COMP_WORDS[1]} in\n")
+	for _, c := range commands {
+		if c.name == "completion" {
+			b.WriteString("\tcompletion) COMPREPLY=($(compgen -W \"bash zsh fish\" -- \"$cur\")) ;;\n")
+			continue
+		}
+		if flags := c.flags(); flags != nil {
+			fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", c.name, strings.Join(flags, " "))
+		}
+	}
+	b.WriteString("\tesac\n}\n")
+	b.WriteString("complete -F _todo todo\n")
+	return b.String()
+}
+
+// fishCompletion completes command names, then the flags of the command.
+// Load it with: todo completion fish | source
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("complete -c todo -f\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c todo -n __fish_use_subcommand -a %s -d %q\n", c.name, c.summary)
+		for _, f := range c.flags() {
+			fmt.Fprintf(&b, "complete -c todo -n '__fish_seen_subcommand_from %s' -o %s\n", c.name, strings.TrimPrefix(f, "-"))
+		}
+	}
+	b.WriteString("complete -c todo -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n")
+	return b.String()
+}
+
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
@@ -0,0 +1,169 @@
+// Command todo is a command-line client for the todo API.
+//
+// Usage:
+//
+//	todo login [-server URL] [-username NAME]
+//	todo add [-d DESCRIPTION] [-due DATE] [-p PRIORITY] TITLE...
+//	todo ls [-status STATUS,...] [-p PRIORITY] [-archived] [-q QUERY]
+//	todo done ID...
+//	todo edit [-title TITLE] [-d DESCRIPTION] [-status STATUS] [-due DATE] [-p PRIORITY] ID
+//	todo rm ID...
+//	todo completion bash|zsh|fish
+//
+// Commands printing tasks take -o table (the default) or -o json. Dates are
+// YYYY-MM-DD or RFC 3339.
+//
+// login caches the server URL and the session token in
+// $XDG_CONFIG_HOME/todo/config.json (see os.UserConfigDir), or in the file
+// named by $TODO_CONFIG. The password is read from $TODO_PASSWORD or
+// prompted for.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hsrvms/todoapp/client"
+)
+
+// env is what commands run with.
+type env struct {
+	cfg    *config
+	client *client.Client
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command defines its flags on a FlagSet and returns the function running
+// it with their parsed values and the remaining arguments.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(e *env, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"login", "[-server URL] [-username NAME]", "log in and cache the session token", setupLogin},
+		{"add", "[-d DESCRIPTION] [-due DATE] [-p PRIORITY] TITLE...", "create a task", setupAdd},
+		{"ls", "[-status STATUS,...] [-p PRIORITY] [-archived] [-q QUERY]", "list tasks", setupList},
+		{"done", "ID...", "mark tasks as done", setupDone},
+		{"edit", "[-title TITLE] [-d DESCRIPTION] [-status STATUS] [-due DATE] [-p PRIORITY] ID", "change a task", setupEdit},
+		{"rm", "ID...", "delete tasks", setupRemove},
+		{"completion", "bash|zsh|fish", "print a shell completion script", setupCompletion},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		return 2
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "todo: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+
+	fs := cmd.flagSet(stderr)
+	runCmd := cmd.setup(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(stderr, "todo: reading config:", err)
+		return 1
+	}
+
+	e := &env{cfg: cfg, client: newClient(cfg), stdin: bufio.NewReader(stdin), stdout: stdout, stderr: stderr}
+	if err := runCmd(e, fs.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		if errors.Is(err, client.ErrUnauthorized) && cmd.name != "login" {
+			err = errNotLoggedIn
+		}
+		fmt.Fprintf(stderr, "todo %s: %v\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+var (
+	// errUsage is returned by commands given the wrong arguments.
+	errUsage       = errors.New("usage")
+	errNotLoggedIn = errors.New("not logged in, run: todo login")
+)
+
+// newClient returns a client of the configured server using the cached
+// session token.
+func newClient(cfg *config) *client.Client {
+	return client.New(cfg.Server, client.WithToken(cfg.Token))
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: todo COMMAND [ARGS]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "todo COMMAND -h" for the options of a command.`)
+}
+
+// flagSet returns an empty flag set for the command, writing its usage to
+// w.
+func (c *command) flagSet(w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Usage = func() {
+		fmt.Fprintf(w, "Usage: todo %s %s\n\n%s.\n", c.name, c.args, strings.ToUpper(c.summary[:1])+c.summary[1:])
+		if c.flags() != nil {
+			fmt.Fprintln(w)
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// flags returns the names of the command's flags, for completion.
+func (c *command) flags() []string {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	c.setup(fs)
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiconfig "github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/models"
+	apiserver "github.com/hsrvms/todoapp/server"
+	"github.com/hsrvms/todoapp/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCommands(t *testing.T) {
+	t.Setenv("TODO_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("TODO_PASSWORD", "secretPassword")
+
+	cfg := apiconfig.Default()
+	cfg.JWTSecret = "testSecret"
+	st := store.NewMockStore()
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("secretPassword"), bcrypt.MinCost)
+	st.CreateUser(&models.User{Username: "johnDoe", Password: string(hashed)})
+
+	server := httptest.NewServer(apiserver.NewAPIServer(cfg, st, nil).Handler())
+	defer server.Close()
+
+	todo := func(args ...string) string {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code != 0 {
+			t.Fatalf("todo %s: exit %d: %s", strings.Join(args, " "), code, stderr.String())
+		}
+		return stdout.String()
+	}
+	tasks := func(args ...string) []*models.Task {
+		t.Helper()
+		var tasks []*models.Task
+		args = append([]string{args[0], "-o", "json"}, args[1:]...)
+		if err := json.Unmarshal([]byte(todo(args...)), &tasks); err != nil {
+			t.Fatal(err)
+		}
+		return tasks
+	}
+
+	todo("login", "-server", server.URL, "-username", "johnDoe")
+
+	added := tasks("add", "-p", "b", "-due", "2024-04-30", "Learn", "Golang")
+	if added[0].Title != "Learn Golang" || added[0].Priority != "B" || added[0].DueAt == nil {
+		t.Errorf("add: got %+v", added[0])
+	}
+	tasks("add", "Call the bank")
+
+	table := todo("ls")
+	if !strings.Contains(table, "Learn Golang") || !strings.HasPrefix(table, "ID") {
+		t.Errorf("ls: got\n%s", table)
+	}
+
+	done := tasks("done", "1")
+	if done[0].Status != models.StatusDone || done[0].CompletedAt == nil {
+		t.Errorf("done: got %+v", done[0])
+	}
+	if open := tasks("ls", "-status", "todo"); len(open) != 1 || open[0].Title != "Call the bank" {
+		t.Errorf("ls -status todo: got %+v", open)
+	}
+
+	edited := tasks("edit", "-title", "Learn Go", "-due", "none", "1")
+	if edited[0].Title != "Learn Go" || edited[0].DueAt != nil || edited[0].Priority != "B" {
+		t.Errorf("edit: got %+v", edited[0])
+	}
+
+	todo("rm", "2")
+	if left := tasks("ls"); len(left) != 1 {
+		t.Errorf("after rm: got %d tasks", len(left))
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"rm"}, strings.NewReader(""), &bytes.Buffer{}, &stderr); code != 2 || !strings.Contains(stderr.String(), "Usage: todo rm") {
+		t.Errorf("rm without ID: got %d: %s", code, stderr.String())
+	}
+}
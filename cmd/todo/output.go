@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("o", outputTable, "output format, table or json")
+}
+
+// print writes the tasks in the output format.
+func (e *env) print(output string, tasks []*models.Task) error {
+	switch output {
+	case outputJSON:
+		if tasks == nil {
+			tasks = []*models.Task{}
+		}
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tasks)
+	case outputTable:
+		tw := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tPRI\tDUE\tTITLE")
+		for _, t := range tasks {
+			due := "-"
+			if t.DueAt != nil {
+				due = t.DueAt.Local().Format("2006-01-02")
+			}
+			priority := t.Priority
+			if priority == "" {
+				priority = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", strconv.FormatInt(t.ID, 10), t.Status, priority, due, strings.Join(strings.Fields(t.Title), " "))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", output)
+}
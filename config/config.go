@@ -0,0 +1,324 @@
+// Package config loads the settings of the server.
+//
+// Every setting has a key in the TOML config file, e.g. http.read_timeout,
+// an environment variable, e.g. HTTP_READ_TIMEOUT, and a flag named after
+// the key, e.g. -http-read-timeout. They are read from, highest precedence
+// first:
+//
+//  1. the command-line flags,
+//  2. the environment,
+//  3. the .env file, or the file given by -env-file, if it exists,
+//  4. the config file given by -config or CONFIG_FILE, if any,
+//  5. the defaults of Default.
+//
+// A config file looks like:
+//
+//	addr = ":8080"
+//	admin_users = ["johnDoe"]
+//
+//	[http]
+//	read_timeout = "30s"
+//
+//	[rate_limit]
+//	backend = "postgres"
+//	user = "600/1m"
+//
+// Load rejects invalid values and settings the server cannot run without,
+// such as an empty JWT secret, so that mistakes show up at startup.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/idempotency"
+	"github.com/hsrvms/todoapp/ratelimit"
+	"github.com/hsrvms/todoapp/realip"
+	"github.com/joho/godotenv"
+)
+
+var (
+	ErrAddrRequired        = errors.New("addr is required")
+	ErrDatabaseURIRequired = errors.New("database URI is required")
+	ErrJWTSecretRequired   = errors.New("JWT secret is required")
+)
+
+// Config is the settings of the server.
+type Config struct {
+	// Addr is the address the server listens on.
+	Addr        string
+	DatabaseURI string
+	// JWTSecret signs the session tokens. Changing it logs every user out.
+	JWTSecret string
+	// AdminUsers are the usernames allowed to call the admin routes.
+	AdminUsers []string
+	// CORSOrigins are the origins allowed to call the API from browsers,
+	// "*" for any. None are allowed by default.
+	CORSOrigins []string
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For headers
+	// are believed.
+	TrustedProxies []netip.Prefix
+	// IdempotencyKeyTTL is how long idempotency keys are kept.
+	IdempotencyKeyTTL time.Duration
+	HTTP              HTTP
+	RateLimit         RateLimit
+}
+
+// HTTP bounds the time clients may take, so that slow or idle ones cannot
+// hold connections forever, and the time left to shut down.
+type HTTP struct {
+	// ReadHeaderTimeout is the time to read the request headers, and
+	// ReadTimeout that to read the whole request.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	// WriteTimeout is the time to write the response after the request was
+	// read. Event streams and WebSockets are exempt.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection waits for the next
+	// request.
+	IdleTimeout time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to complete
+	// once the server is stopping, after which they are cut off.
+	ShutdownTimeout time.Duration
+}
+
+// RateLimit is the quotas of the route groups.
+type RateLimit struct {
+	// Backend is where the buckets are kept: "memory" counts the requests
+	// of each instance, and "postgres" shares the counts of all instances
+	// through the database.
+	Backend string
+	// User and IP are the quotas of each user and anonymous client address
+	// on most routes. Auth is that of both on the routes logging in and
+	// registering, which credential stuffing and sign-up spam go for.
+	User ratelimit.Quota
+	IP   ratelimit.Quota
+	Auth ratelimit.Quota
+}
+
+// Default returns the default settings. They lack the database URI and
+// the JWT secret, which must be configured.
+func Default() *Config {
+	return &Config{
+		Addr:              ":8080",
+		IdempotencyKeyTTL: idempotency.DefaultTTL,
+		HTTP: HTTP{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		RateLimit: RateLimit{
+			Backend: "memory",
+			User:    ratelimit.Quota{Limit: 600, Period: time.Minute},
+			IP:      ratelimit.Quota{Limit: 120, Period: time.Minute},
+			Auth:    ratelimit.Quota{Limit: 20, Period: time.Minute},
+		},
+	}
+}
+
+// setting is a value of Config that can be configured.
+type setting struct {
+	// key is the name of the setting in the config file. The flag is named
+	// after it.
+	key   string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "ADDR", "address to listen on", setString(func(c *Config) *string { return &c.Addr })},
+	{"database_uri", "DB_URI", "PostgreSQL connection URI", setString(func(c *Config) *string { return &c.DatabaseURI })},
+	{"jwt_secret", "JWT_SECRET", "secret signing the session tokens", setString(func(c *Config) *string { return &c.JWTSecret })},
+	{"admin_users", "ADMIN_USERS", "usernames of the admins, separated by commas", setList(func(c *Config) *[]string { return &c.AdminUsers })},
+	{"cors_origins", "CORS_ORIGINS", "origins allowed to call the API from browsers, separated by commas", setList(func(c *Config) *[]string { return &c.CORSOrigins })},
+	{"trusted_proxies", "TRUSTED_PROXIES", "addresses or CIDR prefixes of the trusted reverse proxies, separated by commas", setProxies},
+	{"idempotency_key_ttl", "IDEMPOTENCY_KEY_TTL", "how long idempotency keys are kept", setDuration(func(c *Config) *time.Duration { return &c.IdempotencyKeyTTL })},
+	{"http.read_header_timeout", "HTTP_READ_HEADER_TIMEOUT", "time to read the request headers", setDuration(func(c *Config) *time.Duration { return &c.HTTP.ReadHeaderTimeout })},
+	{"http.read_timeout", "HTTP_READ_TIMEOUT", "time to read a request", setDuration(func(c *Config) *time.Duration { return &c.HTTP.ReadTimeout })},
+	{"http.write_timeout", "HTTP_WRITE_TIMEOUT", "time to write a response", setDuration(func(c *Config) *time.Duration { return &c.HTTP.WriteTimeout })},
+	{"http.idle_timeout", "HTTP_IDLE_TIMEOUT", "time a keep-alive connection waits for the next request", setDuration(func(c *Config) *time.Duration { return &c.HTTP.IdleTimeout })},
+	{"http.shutdown_timeout", "SHUTDOWN_TIMEOUT", "time in-flight requests may take to complete on shutdown", setDuration(func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout })},
+	{"rate_limit.backend", "RATE_LIMIT_BACKEND", `where the rate limits are kept, "memory" or "postgres"`, setString(func(c *Config) *string { return &c.RateLimit.Backend })},
+	{"rate_limit.user", "RATE_LIMIT_USER", "requests allowed per user, e.g. 600/1m", setQuota(func(c *Config) *ratelimit.Quota { return &c.RateLimit.User })},
+	{"rate_limit.ip", "RATE_LIMIT_IP", "requests allowed per anonymous client address", setQuota(func(c *Config) *ratelimit.Quota { return &c.RateLimit.IP })},
+	{"rate_limit.auth", "RATE_LIMIT_AUTH", "requests allowed to log in and register per user or address", setQuota(func(c *Config) *ratelimit.Quota { return &c.RateLimit.Auth })},
+}
+
+// flagName returns the flag of the setting with the key, e.g.
+// "http-read-timeout" for "http.read_timeout".
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// Load returns the settings configured by the command-line arguments, the
+// environment, the .env file and the config file, validated.
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("todoapp", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "TOML config `file`")
+	envFile := flags.String("env-file", "", "`file` of environment variables (default .env, if it exists)")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		flags.Func(flagName(s.key), s.usage+" ($"+s.env+")", func(value string) error {
+			flagValues[s.key] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	fileValues := map[string]string{}
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		if fileValues, err = parseTOML(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+		for key := range fileValues {
+			if !knownKey(key) {
+				return nil, fmt.Errorf("%s: unknown setting %q", *configFile, key)
+			}
+		}
+	}
+
+	envValues, err := godotenv.Read(orDefault(*envFile, ".env"))
+	if errors.Is(err, fs.ErrNotExist) && *envFile == "" {
+		envValues, err = map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	for _, s := range settings {
+		if value, ok := flagValues[s.key]; ok {
+			err = wrapError("-"+flagName(s.key), s.set(c, value))
+		} else if value, ok := os.LookupEnv(s.env); ok {
+			err = wrapError(s.env, s.set(c, value))
+		} else if value, ok := envValues[s.env]; ok {
+			err = wrapError(orDefault(*envFile, ".env")+": "+s.env, s.set(c, value))
+		} else if value, ok := fileValues[s.key]; ok {
+			err = wrapError(*configFile+": "+s.key, s.set(c, value))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports the settings the server cannot run with.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, ErrAddrRequired)
+	}
+	if c.DatabaseURI == "" {
+		errs = append(errs, ErrDatabaseURIRequired)
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, ErrJWTSecretRequired)
+	}
+	if c.RateLimit.Backend != "memory" && c.RateLimit.Backend != "postgres" {
+		errs = append(errs, fmt.Errorf("invalid rate limit backend %q: want memory or postgres", c.RateLimit.Backend))
+	}
+	return errors.Join(errs...)
+}
+
+func knownKey(key string) bool {
+	for _, s := range settings {
+		if s.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// orDefault returns value, or def if value is empty.
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func wrapError(source string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", source, err)
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = strings.TrimSpace(value)
+		return nil
+	}
+}
+
+// setList sets a list of values separated by commas, leaving out the
+// empty ones.
+func setList(field func(c *Config) *[]string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid duration %q: must be positive", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func setQuota(field func(c *Config) *ratelimit.Quota) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		quota, err := ratelimit.ParseQuota(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		*field(c) = quota
+		return nil
+	}
+}
+
+func setProxies(c *Config, value string) error {
+	proxies, err := realip.ParseProxies(value)
+	if err != nil {
+		return err
+	}
+	c.TrustedProxies = proxies
+	return nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/ratelimit"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	configFile := writeFile(t, "config.toml", `
+# Set by every source, so the flag wins.
+addr = ":9000"
+database_uri = "postgres://file" # overridden by .env
+jwt_secret = 'fileSecret'
+admin_users = ["johnDoe", "janeDoe"]
+
+[http]
+read_timeout = "10s"
+idle_timeout = "1m"
+
+[rate_limit]
+backend = "postgres"
+user = "100/1m"
+`)
+	envFile := writeFile(t, "test.env", "DB_URI=postgres://dotenv\nJWT_SECRET=dotenvSecret\nHTTP_IDLE_TIMEOUT=2m\n")
+	t.Setenv("JWT_SECRET", "envSecret")
+	t.Setenv("ADDR", ":9001")
+
+	cfg, err := Load([]string{"-config", configFile, "-env-file", envFile, "-addr", ":9002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Default()
+	want.Addr = ":9002"
+	want.DatabaseURI = "postgres://dotenv"
+	want.JWTSecret = "envSecret"
+	want.AdminUsers = []string{"johnDoe", "janeDoe"}
+	want.HTTP.ReadTimeout = 10 * time.Second
+	want.HTTP.IdleTimeout = 2 * time.Minute
+	want.RateLimit.Backend = "postgres"
+	want.RateLimit.User = ratelimit.Quota{Limit: 100, Period: time.Minute}
+	if cfg.Addr != want.Addr || cfg.DatabaseURI != want.DatabaseURI || cfg.JWTSecret != want.JWTSecret ||
+		!slices.Equal(cfg.AdminUsers, want.AdminUsers) || cfg.HTTP != want.HTTP || cfg.RateLimit != want.RateLimit {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv("DB_URI", "postgres://env")
+	t.Setenv("JWT_SECRET", "envSecret")
+	envFile := writeFile(t, "empty.env", "")
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{"empty secret", nil, map[string]string{"JWT_SECRET": ""}, "", ErrJWTSecretRequired.Error()},
+		{"invalid duration", []string{"-http-read-timeout", "soon"}, nil, "", "-http-read-timeout"},
+		{"negative duration", nil, map[string]string{"IDEMPOTENCY_KEY_TTL": "-1h"}, "", "IDEMPOTENCY_KEY_TTL"},
+		{"invalid quota", nil, nil, "[rate_limit]\nip = \"lots\"\n", "rate_limit.ip"},
+		{"invalid proxy", nil, map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, proxy"}, "", "TRUSTED_PROXIES"},
+		{"invalid backend", []string{"-rate-limit-backend", "redis"}, nil, "", "rate limit backend"},
+		{"unknown setting", nil, nil, "[http]\nread_timeot = \"5s\"\n", "http.read_timeot"},
+		{"invalid file", nil, nil, "addr = :8080\n", "line 1"},
+		{"extra arguments", []string{"serve"}, nil, "", "unexpected arguments"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			args := append([]string{"-env-file", envFile}, tc.args...)
+			if tc.file != "" {
+				args = append(args, "-config", writeFile(t, "config.toml", tc.file))
+			}
+
+			_, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error about %s", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := Load([]string{"-env-file", filepath.Join(t.TempDir(), "missing.env")}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing -env-file: got %v", err)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML([]byte(`
+name = "a \"quoted\" # value" # comment
+literal = 'C:\path'
+count = 1_000
+enabled = true
+list = ["a", 'b # c', ]
+
+[table.sub]
+key = "value"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"name":          `a "quoted" # value`,
+		"literal":       `C:\path`,
+		"count":         "1000",
+		"enabled":       "true",
+		"list":          "a,b # c",
+		"table.sub.key": "value",
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %q, want %q", key, values[key], value)
+		}
+	}
+	if len(values) != len(want) {
+		t.Errorf("got %d values, want %d", len(values), len(want))
+	}
+
+	for _, data := range []string{"key", "key = ", "[table", "key = [1, 2", "key = 1\nkey = 2", "bad key = 1"} {
+		if _, err := parseTOML([]byte(data)); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML used by config files: tables, and
+// keys set to strings, integers, booleans or single-line arrays of those.
+// It returns the values as strings keyed by their dotted name, e.g.
+// "http.read_timeout", with the items of arrays separated by commas.
+func parseTOML(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+			if !ok || !validKey(strings.TrimSpace(name)) {
+				return nil, fmt.Errorf("line %d: invalid table %s", n, line)
+			}
+			table = strings.TrimSpace(name) + "."
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validKey(key) {
+			return nil, fmt.Errorf("line %d: want key = value", n)
+		}
+		key = table + key
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, key)
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n, key, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	items, ok := strings.CutPrefix(raw, "[")
+	if !ok {
+		return parseTOMLScalar(raw)
+	}
+
+	items, ok = strings.CutSuffix(items, "]")
+	if !ok {
+		return "", fmt.Errorf("unterminated array %s", raw)
+	}
+	var values []string
+	for _, item := range splitTOMLArray(items) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		value, err := parseTOMLScalar(item)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, ","), nil
+}
+
+func parseTOMLScalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		value, ok := strings.CutSuffix(raw[1:], "'")
+		if !ok || strings.Contains(value, "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+
+	if _, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", raw)
+	}
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// splitTOMLArray splits the items of an array at the commas outside of
+// strings.
+func splitTOMLArray(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// stripComment removes the comment ending the line, if any.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// validKey reports whether the key is bare, or dotted bare keys.
+func validKey(key string) bool {
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -38,7 +38,103 @@ func NewPgStorage(connStr string) *PgStorage {
 	return &PgStorage{db: db}
 }
 
-// Init initializes the PgStorage by creating the users and tasks tables.
+// migrations are applied in order on every start after the tables are
+// created. Each statement must be idempotent.
+var migrations = []string{
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS auto_archive_days INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS tasks_user_id_idx ON tasks (user_id)`,
+	`DO $$
+	BEGIN
+		IF (SELECT data_type FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'tasks'
+				AND column_name = 'status') = 'boolean' THEN
+			ALTER TABLE tasks ALTER COLUMN status DROP DEFAULT;
+			ALTER TABLE tasks ALTER COLUMN status TYPE VARCHAR(32)
+				USING CASE WHEN status THEN 'done' ELSE 'todo' END;
+			ALTER TABLE tasks ALTER COLUMN status SET DEFAULT 'todo';
+			ALTER TABLE tasks ALTER COLUMN status SET NOT NULL;
+		END IF;
+	END $$`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'B')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS tasks_search_vector_idx ON tasks USING GIN (search_vector)`,
+	`CREATE SEQUENCE IF NOT EXISTS task_events_id_seq`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_at TIMESTAMPTZ`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS priority VARCHAR(1) NOT NULL DEFAULT ''`,
+	// calendar_token holds the SHA-256 of the user's iCal feed token.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS calendar_token VARCHAR(64) UNIQUE`,
+	`CREATE TABLE IF NOT EXISTS caldav_resources (
+		task_id INTEGER PRIMARY KEY REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(255) NOT NULL,
+		uid VARCHAR(255) NOT NULL,
+		UNIQUE (user_id, name)
+	)`,
+	// Offline sync: every change to a task takes the next number of its
+	// owner's sync_seq, deletions leave a tombstone, and field_times holds
+	// when each synced field was last written.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS sync_seq BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS sync_seq BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS field_times JSONB NOT NULL DEFAULT '{}'`,
+	`CREATE INDEX IF NOT EXISTS tasks_user_id_sync_seq_idx ON tasks (user_id, sync_seq)`,
+	`CREATE TABLE IF NOT EXISTS task_tombstones (
+		task_id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		sync_seq BIGINT NOT NULL,
+		deleted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS task_tombstones_user_id_sync_seq_idx ON task_tombstones (user_id, sync_seq)`,
+	`CREATE OR REPLACE FUNCTION next_sync_seq(uid INTEGER) RETURNS BIGINT AS $$
+		UPDATE users SET sync_seq = sync_seq + 1 WHERE id = uid RETURNING sync_seq
+	$$ LANGUAGE sql`,
+	// field_times is stamped with the current time for the fields an
+	// update changes, unless the update sets field_times itself.
+	`CREATE OR REPLACE FUNCTION tasks_sync() RETURNS trigger AS $$
+	DECLARE
+		stamp JSONB := to_jsonb(now());
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			IF OLD.user_id IS NOT NULL THEN
+				INSERT INTO task_tombstones (task_id, user_id, sync_seq)
+				VALUES (OLD.id, OLD.user_id, next_sync_seq(OLD.user_id));
+			END IF;
+			RETURN OLD;
+		END IF;
+
+		IF NEW.user_id IS NOT NULL THEN
+			NEW.sync_seq := next_sync_seq(NEW.user_id);
+		END IF;
+		IF TG_OP = 'INSERT' THEN
+			NEW.field_times := jsonb_build_object('title', stamp, 'description', stamp, 'status', stamp) || NEW.field_times;
+		ELSIF NEW.field_times = OLD.field_times THEN
+			IF NEW.title IS DISTINCT FROM OLD.title THEN
+				NEW.field_times := jsonb_set(NEW.field_times, '{title}', stamp);
+			END IF;
+			IF NEW.description IS DISTINCT FROM OLD.description THEN
+				NEW.field_times := jsonb_set(NEW.field_times, '{description}', stamp);
+			END IF;
+			IF NEW.status IS DISTINCT FROM OLD.status THEN
+				NEW.field_times := jsonb_set(NEW.field_times, '{status}', stamp);
+			END IF;
+		END IF;
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS tasks_sync ON tasks`,
+	`CREATE TRIGGER tasks_sync BEFORE INSERT OR UPDATE OR DELETE ON tasks
+		FOR EACH ROW EXECUTE FUNCTION tasks_sync()`,
+	// Number the tasks created before sync existed.
+	`UPDATE tasks SET field_times = field_times WHERE sync_seq = 0 AND user_id IS NOT NULL`,
+}
+
+// Init initializes the PgStorage by creating the tables and applying the
+// migrations.
 func (s *PgStorage) Init() (*sql.DB, error) {
 	if err := s.createUsersTable(); err != nil {
 		return nil, err
@@ -48,9 +144,157 @@ func (s *PgStorage) Init() (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := s.createSavedViewsTable(); err != nil {
+		return nil, err
+	}
+
+	if err := s.createWebhooksTables(); err != nil {
+		return nil, err
+	}
+
+	if err := s.createIdempotencyKeysTable(); err != nil {
+		return nil, err
+	}
+
+	if err := s.createRateLimitsTable(); err != nil {
+		return nil, err
+	}
+
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+
 	return s.db, nil
 }
 
+func (s *PgStorage) createSavedViewsTable() error {
+	if s == nil || s.db == nil {
+		return errors.New("nil receiver or nil db connection")
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS saved_views (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			query TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name)
+		);
+	`
+	_, err := s.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create saved_views table: %v", err)
+	}
+
+	return nil
+}
+
+func (s *PgStorage) createWebhooksTables() error {
+	if s == nil || s.db == nil {
+		return errors.New("nil receiver or nil db connection")
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			events TEXT[] NOT NULL DEFAULT '{}',
+			secret VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			event_type VARCHAR(32) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(16) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_status_code INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			delivered_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx
+			ON webhook_deliveries (webhook_id, id DESC);
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx
+			ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+	`
+	_, err := s.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create webhooks tables: %v", err)
+	}
+
+	return nil
+}
+
+func (s *PgStorage) createIdempotencyKeysTable() error {
+	if s == nil || s.db == nil {
+		return errors.New("nil receiver or nil db connection")
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			key VARCHAR(255) NOT NULL,
+			fingerprint VARCHAR(64) NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			content_type VARCHAR(255) NOT NULL DEFAULT '',
+			body BYTEA,
+			created_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (user_id, key)
+		);
+		CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+	`
+	_, err := s.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency_keys table: %v", err)
+	}
+
+	return nil
+}
+
+// createRateLimitsTable creates the table of the buckets shared by the
+// server instances, holding the theoretical arrival time of each key.
+func (s *PgStorage) createRateLimitsTable() error {
+	if s == nil || s.db == nil {
+		return errors.New("nil receiver or nil db connection")
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS rate_limits (
+			key VARCHAR(255) PRIMARY KEY,
+			tat TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS rate_limits_tat_idx ON rate_limits (tat);
+	`
+	_, err := s.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create rate_limits table: %v", err)
+	}
+
+	return nil
+}
+
+func (s *PgStorage) migrate() error {
+	if s == nil || s.db == nil {
+		return errors.New("nil receiver or nil db connection")
+	}
+
+	for _, query := range migrations {
+		if _, err := s.db.Exec(query); err != nil {
+			return fmt.Errorf("failed to apply migration %q: %v", query, err)
+		}
+	}
+
+	return nil
+}
+
 func (s *PgStorage) createUsersTable() error {
 	if s == nil || s.db == nil {
 		return errors.New("nil receiver or nil db connection")
@@ -82,7 +326,7 @@ func (s *PgStorage) createTasksTable() error {
 			id SERIAL PRIMARY KEY,
 			title VARCHAR(255) NOT NULL,
 			description VARCHAR(255) NOT NULL,
-			status BOOLEAN DEFAULT FALSE,
+			status VARCHAR(32) NOT NULL DEFAULT 'todo',
 			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);
 	`
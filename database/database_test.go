@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStorage returns a PgStorage on an empty schema of the database given
+// by TEST_DB_URI, and skips the test without one.
+func testStorage(t *testing.T) *PgStorage {
+	t.Helper()
+	connStr := os.Getenv("TEST_DB_URI")
+	if connStr == "" {
+		t.Skip("TEST_DB_URI is not set")
+	}
+
+	admin, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// lib/pq sends unknown parameters as run-time settings.
+	if u, err := url.Parse(connStr); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		connStr = u.String()
+	} else {
+		connStr += " search_path=" + schema
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &PgStorage{db: db}
+}
+
+func TestMigrateBooleanStatus(t *testing.T) {
+	s := testStorage(t)
+
+	// The tables as they were when the status was a boolean.
+	for _, query := range []string{
+		`CREATE TABLE users (
+			id SERIAL PRIMARY KEY,
+			username VARCHAR(255) NOT NULL UNIQUE,
+			password VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE tasks (
+			id SERIAL PRIMARY KEY,
+			title VARCHAR(255) NOT NULL,
+			description VARCHAR(255) NOT NULL,
+			status BOOLEAN NOT NULL DEFAULT false,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO tasks (title, description, status) VALUES
+			('Learn Golang', '', true),
+			('Call the bank', '', false)`,
+		`INSERT INTO tasks (title, description) VALUES ('Water the plants', '')`,
+	} {
+		if _, err := s.db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Init runs on every start, so it must leave migrated tables as they are.
+	for i := 0; i < 2; i++ {
+		if _, err := s.Init(); err != nil {
+			t.Fatalf("Init %d: %v", i+1, err)
+		}
+	}
+
+	var dataType, columnDefault, nullable string
+	err := s.db.QueryRow(`SELECT data_type, column_default, is_nullable FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'tasks' AND column_name = 'status'`).Scan(&dataType, &columnDefault, &nullable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataType != "character varying" || !strings.HasPrefix(columnDefault, "'todo'") || nullable != "NO" {
+		t.Errorf("status column: got %s default %s nullable %s", dataType, columnDefault, nullable)
+	}
+
+	rows, err := s.db.Query(`SELECT title, status FROM tasks ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var title, status string
+		if err := rows.Scan(&title, &status); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, title+": "+status)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := "Learn Golang: done, Call the bank: todo, Water the plants: todo"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, ", "), want)
+	}
+
+	if _, err := s.db.Exec(`INSERT INTO tasks (title, description) VALUES ('Learn Rust', '')`); err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	if err := s.db.QueryRow(`SELECT status FROM tasks WHERE title = 'Learn Rust'`).Scan(&status); err != nil || status != "todo" {
+		t.Errorf("new task: got status %q, %v", status, err)
+	}
+}
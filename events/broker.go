@@ -0,0 +1,182 @@
+// Package events distributes task change events to real-time clients.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+// subscriptionBuffer is the number of events a subscriber may fall behind
+// before it is dropped.
+const subscriptionBuffer = 64
+
+// Broker fans task events out to subscribers and keeps the most recent ones
+// in a bounded buffer so that clients can resume after reconnecting.
+//
+// Events are buffered in the order they are published, which is the order
+// their transactions committed in. Their IDs are drawn before the commit,
+// so an event may follow one with a higher ID, and clients resume from the
+// position of the last event they received rather than its ID.
+type Broker struct {
+	mu     sync.Mutex
+	buffer []*models.TaskEvent
+	next   int
+	// maxID is the highest ID published, from which events without one
+	// are numbered.
+	maxID int64
+	// lastID is the ID of the last event published, and evictedID that of
+	// the last one to leave the buffer. Both are cleared by a reset.
+	lastID      int64
+	evictedID   int64
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a Broker that remembers the last size events.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		buffer:      make([]*models.TaskEvent, 0, size),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events of one user. C is closed when the
+// subscriber is dropped for falling behind or unsubscribes.
+type Subscription struct {
+	C      <-chan *models.TaskEvent
+	ch     chan *models.TaskEvent
+	userID int64
+}
+
+// Publish assigns the next ID to the event, unless it already has one, and
+// delivers it to the subscribers of its user.
+//
+// A TasksReset event is delivered to every subscriber and empties the
+// buffer, since the events before it can no longer be replayed reliably.
+func (b *Broker) Publish(e *models.TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.Type == models.TasksReset {
+		b.reset(e)
+		return
+	}
+
+	if e.ID == 0 {
+		e.ID = b.maxID + 1
+	}
+	b.maxID = max(b.maxID, e.ID)
+	b.lastID = e.ID
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, e)
+	} else if cap(b.buffer) > 0 {
+		b.evictedID = b.buffer[b.next].ID
+		b.buffer[b.next] = e
+		b.next = (b.next + 1) % cap(b.buffer)
+	} else {
+		b.evictedID = e.ID
+	}
+
+	for sub := range b.subscribers {
+		if sub.userID != e.UserID {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// reset empties the buffer and sends the reset event to every subscriber.
+// The caller must hold b.mu.
+func (b *Broker) reset(e *models.TaskEvent) {
+	b.buffer = b.buffer[:0]
+	b.next = 0
+	b.lastID = 0
+	b.evictedID = 0
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- e:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the events of a user. It also
+// returns the buffered events of that user published after the event with
+// lastEventID, including those with lower IDs that committed later. The
+// replay is incomplete when lastEventID is unknown, having left the buffer
+// or been published before a reset, in which case the buffered events with
+// a higher ID are returned; a lastEventID of zero asks for no replay.
+func (b *Broker) Subscribe(userID, lastEventID int64) (sub *Subscription, replay []*models.TaskEvent, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *models.TaskEvent, subscriptionBuffer)
+	sub = &Subscription{C: ch, ch: ch, userID: userID}
+	b.subscribers[sub] = struct{}{}
+
+	if lastEventID <= 0 {
+		return sub, nil, true
+	}
+
+	events := b.buffered()
+	start := -1
+	switch lastEventID {
+	case b.lastID:
+		start = len(events)
+	case b.evictedID:
+		start = 0
+	default:
+		for i, e := range events {
+			if e.ID == lastEventID {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	complete = start >= 0
+	for i, e := range events {
+		if e.UserID != userID {
+			continue
+		}
+		if complete && i >= start || !complete && e.ID > lastEventID {
+			replay = append(replay, e)
+		}
+	}
+
+	return sub, replay, complete
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.drop(sub)
+}
+
+func (b *Broker) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// buffered returns the buffered events from oldest to newest. The caller
+// must hold b.mu.
+func (b *Broker) buffered() []*models.TaskEvent {
+	events := make([]*models.TaskEvent, 0, len(b.buffer))
+	events = append(events, b.buffer[b.next:]...)
+	return append(events, b.buffer[:b.next]...)
+}
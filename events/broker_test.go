@@ -0,0 +1,145 @@
+package events
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+func TestBrokerSubscribe(t *testing.T) {
+	b := NewBroker(3)
+	for i := 0; i < 5; i++ {
+		b.Publish(&models.TaskEvent{Type: models.TaskCreated, UserID: int64(i%2 + 1)})
+	}
+	// The buffer holds events 3, 4 and 5; users alternate 1, 2, 1, 2, 1.
+
+	testCases := []struct {
+		name        string
+		userID      int64
+		lastEventID int64
+		expReplay   []int64
+		expComplete bool
+	}{
+		{name: "no replay", userID: 1, lastEventID: 0, expComplete: true},
+		{name: "up to date", userID: 1, lastEventID: 5, expComplete: true},
+		{name: "buffered", userID: 1, lastEventID: 2, expReplay: []int64{3, 5}, expComplete: true},
+		{name: "other user", userID: 2, lastEventID: 2, expReplay: []int64{4}, expComplete: true},
+		{name: "evicted", userID: 1, lastEventID: 1, expReplay: []int64{3, 5}, expComplete: false},
+		{name: "unknown", userID: 1, lastEventID: 9, expComplete: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sub, replay, complete := b.Subscribe(tc.userID, tc.lastEventID)
+			defer b.Unsubscribe(sub)
+
+			if complete != tc.expComplete {
+				t.Errorf("got complete %v want %v", complete, tc.expComplete)
+			}
+			if len(replay) != len(tc.expReplay) {
+				t.Fatalf("got %d replayed events want %d", len(replay), len(tc.expReplay))
+			}
+			for i, e := range replay {
+				if e.ID != tc.expReplay[i] {
+					t.Errorf("replay[%d]: got event %d want %d", i, e.ID, tc.expReplay[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	b := NewBroker(0)
+	sub, _, _ := b.Subscribe(1, 0)
+
+	for i := 0; i < subscriptionBuffer+1; i++ {
+		b.Publish(&models.TaskEvent{Type: models.TaskUpdated, UserID: 1})
+	}
+
+	received := 0
+	for range sub.C {
+		received++
+	}
+	if received != subscriptionBuffer {
+		t.Errorf("got %d events before the channel closed want %d", received, subscriptionBuffer)
+	}
+
+	// Unsubscribing a dropped subscriber must not close the channel twice.
+	b.Unsubscribe(sub)
+}
+
+func TestBrokerReset(t *testing.T) {
+	b := NewBroker(10)
+	b.Publish(&models.TaskEvent{Type: models.TaskCreated, UserID: 1})
+	sub, _, _ := b.Subscribe(2, 0)
+	defer b.Unsubscribe(sub)
+
+	b.Publish(&models.TaskEvent{Type: models.TasksReset})
+	if e := <-sub.C; e.Type != models.TasksReset {
+		t.Errorf("got event %q want %q", e.Type, models.TasksReset)
+	}
+
+	other, replay, complete := b.Subscribe(1, 1)
+	b.Unsubscribe(other)
+	if complete || len(replay) != 0 {
+		t.Errorf("resuming before a reset: got %d events, complete %v", len(replay), complete)
+	}
+
+	b.Publish(&models.TaskEvent{ID: 7, Type: models.TaskUpdated, UserID: 1})
+	other, replay, complete = b.Subscribe(1, 7)
+	b.Unsubscribe(other)
+	if !complete || len(replay) != 0 {
+		t.Errorf("resuming after a reset: got %d events, complete %v", len(replay), complete)
+	}
+}
+
+func TestBrokerReplaysInCommitOrder(t *testing.T) {
+	// Event IDs are drawn before the commit, so event 2 is published after
+	// event 3 when its transaction commits last.
+	b := NewBroker(3)
+	for _, id := range []int64{1, 3, 2, 4} {
+		b.Publish(&models.TaskEvent{ID: id, Type: models.TaskUpdated, UserID: 1})
+	}
+	// The buffer holds events 3, 2 and 4; event 1 left it.
+
+	testCases := []struct {
+		name        string
+		lastEventID int64
+		expReplay   []int64
+		expComplete bool
+	}{
+		{name: "before a late commit", lastEventID: 3, expReplay: []int64{2, 4}, expComplete: true},
+		{name: "after a late commit", lastEventID: 2, expReplay: []int64{4}, expComplete: true},
+		{name: "last evicted", lastEventID: 1, expReplay: []int64{3, 2, 4}, expComplete: true},
+		{name: "up to date", lastEventID: 4, expComplete: true},
+		{name: "unknown", lastEventID: 9, expComplete: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sub, replay, complete := b.Subscribe(1, tc.lastEventID)
+			defer b.Unsubscribe(sub)
+
+			if complete != tc.expComplete {
+				t.Errorf("got complete %v want %v", complete, tc.expComplete)
+			}
+			var ids []int64
+			for _, e := range replay {
+				ids = append(ids, e.ID)
+			}
+			if !slices.Equal(ids, tc.expReplay) {
+				t.Errorf("got replay %v want %v", ids, tc.expReplay)
+			}
+		})
+	}
+
+	// Events without an ID are numbered after the highest one, not the last.
+	e := &models.TaskEvent{Type: models.TaskCreated, UserID: 1}
+	b.Publish(&models.TaskEvent{ID: 6, Type: models.TaskUpdated, UserID: 1})
+	b.Publish(&models.TaskEvent{ID: 5, Type: models.TaskUpdated, UserID: 1})
+	b.Publish(e)
+	if e.ID != 7 {
+		t.Errorf("got ID %d want 7", e.ID)
+	}
+}
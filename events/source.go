@@ -0,0 +1,108 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/lib/pq"
+)
+
+// Source delivers the task events committed by every instance of the
+// server. Listen blocks until the context is cancelled.
+type Source interface {
+	Listen(ctx context.Context, publish func(*models.TaskEvent)) error
+}
+
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	// listenerPingInterval is how often an idle connection is checked,
+	// so that a dead connection is noticed and reestablished.
+	listenerPingInterval = 90 * time.Second
+)
+
+// PgListener is a Source receiving the events sent by
+// store.Repository.NotifyTaskEvent through PostgreSQL LISTEN/NOTIFY.
+type PgListener struct {
+	connStr string
+	store   store.Store
+}
+
+// NewPgListener creates a PgListener on its own connection to the database.
+// The store is used to load the tasks of partial events.
+func NewPgListener(connStr string, store store.Store) *PgListener {
+	return &PgListener{connStr: connStr, store: store}
+}
+
+// Listen listens on store.TaskEventsChannel and publishes the events it
+// receives. Lost connections are reestablished in the background; since
+// notifications sent in the meantime are lost, a TasksReset event is
+// published after every reconnection.
+func (l *PgListener) Listen(ctx context.Context, publish func(*models.TaskEvent)) error {
+	listener := pq.NewListener(l.connStr, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Println("event listener disconnected:", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Println("event listener failed to reconnect:", err)
+		case pq.ListenerEventReconnected:
+			log.Println("event listener reconnected")
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(store.TaskEventsChannel); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case n := <-listener.Notify:
+			if n == nil {
+				// Sent after the connection was reestablished.
+				publish(&models.TaskEvent{Type: models.TasksReset})
+				continue
+			}
+
+			e := &models.TaskEvent{}
+			if err := json.Unmarshal([]byte(n.Extra), e); err != nil {
+				log.Println("invalid task event:", err)
+				continue
+			}
+			l.complete(e)
+			publish(e)
+
+		case <-ticker.C:
+			go listener.Ping()
+		}
+	}
+}
+
+// complete replaces the task of a partial event with the stored one. Tasks
+// that have been deleted since are left partial.
+func (l *PgListener) complete(e *models.TaskEvent) {
+	if !e.Partial || e.Task == nil || e.Type == models.TaskDeleted {
+		return
+	}
+
+	task, err := l.store.GetTaskByID(strconv.FormatInt(e.Task.ID, 10), e.UserID)
+	if err != nil {
+		log.Println("failed to load task of partial event:", err)
+		return
+	}
+	if task != nil {
+		e.Task = task
+		e.Partial = false
+	}
+}
@@ -3,9 +3,12 @@ module github.com/hsrvms/todoapp
 go 1.22.1
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.22.0
 )
 
 require github.com/golang-jwt/jwt/v5 v5.2.1
+
+require golang.org/x/text v0.14.0
@@ -0,0 +1,150 @@
+// Package ical writes tasks as iCalendar (RFC 5545) to-dos and reads them
+// back.
+//
+// Each task becomes a VTODO whose UID is stable across exports, so that
+// calendar clients update the entries they already have. The task status
+// maps to the VTODO STATUS, its priority A to I to PRIORITY 1 to 9 (J to Z
+// to 9) and its due date to DUE.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+const (
+	dateTimeLayout = "20060102T150405Z"
+	// maxLineLength is the length in octets after which lines are folded.
+	maxLineLength = 75
+)
+
+// Writer writes a calendar of to-dos. The calendar is started with the
+// first call to WriteTask and ended by Close; errors are sticky and
+// returned by every later call.
+type Writer struct {
+	w       *bufio.Writer
+	name    string
+	stamp   time.Time
+	started bool
+	err     error
+}
+
+// NewWriter returns a Writer for a calendar called name. stamp is used as
+// the DTSTAMP of every to-do.
+func NewWriter(w io.Writer, name string, stamp time.Time) *Writer {
+	return &Writer{w: bufio.NewWriter(w), name: name, stamp: stamp.UTC()}
+}
+
+// WriteTask writes the task as a VTODO with the UID of its ID.
+func (w *Writer) WriteTask(t *models.Task) error {
+	return w.WriteTodo(t, UID(t.ID))
+}
+
+// WriteTodo writes the task as a VTODO with the given UID.
+func (w *Writer) WriteTodo(t *models.Task, uid string) error {
+	w.start()
+	w.line("BEGIN:VTODO")
+	w.line("UID:" + escape(uid))
+	w.line("DTSTAMP:" + w.stamp.Format(dateTimeLayout))
+	if !t.CreatedAt.IsZero() {
+		w.line("CREATED:" + t.CreatedAt.UTC().Format(dateTimeLayout))
+	}
+	w.line("SUMMARY:" + escape(t.Title))
+	if t.Description != "" {
+		w.line("DESCRIPTION:" + escape(t.Description))
+	}
+	if t.DueAt != nil {
+		w.line("DUE:" + t.DueAt.UTC().Format(dateTimeLayout))
+	}
+	if p := Priority(t.Priority); p > 0 {
+		w.line(fmt.Sprintf("PRIORITY:%d", p))
+	}
+	w.line("STATUS:" + Status(t.Status))
+	if t.Status == models.StatusDone && t.CompletedAt != nil {
+		w.line("COMPLETED:" + t.CompletedAt.UTC().Format(dateTimeLayout))
+		w.line("PERCENT-COMPLETE:100")
+	}
+	w.line("END:VTODO")
+	return w.err
+}
+
+// Close ends the calendar and flushes it. It writes an empty calendar if
+// no task was written.
+func (w *Writer) Close() error {
+	w.start()
+	w.line("END:VCALENDAR")
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+func (w *Writer) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:-//todoapp//tasks//EN")
+	w.line("X-WR-CALNAME:" + escape(w.name))
+}
+
+// line writes a content line, folded to maxLineLength octets.
+func (w *Writer) line(s string) {
+	if w.err != nil {
+		return
+	}
+	limit := maxLineLength
+	for len(s) > limit {
+		n := limit
+		for !utf8.RuneStart(s[n]) {
+			n--
+		}
+		w.w.WriteString(s[:n])
+		w.w.WriteString("\r\n ")
+		s = s[n:]
+		// The leading space of a continuation line counts toward its
+		// length.
+		limit = maxLineLength - 1
+	}
+	_, w.err = w.w.WriteString(s + "\r\n")
+}
+
+// UID returns the UID of the VTODO of a task.
+func UID(taskID int64) string {
+	return fmt.Sprintf("task-%d@todoapp", taskID)
+}
+
+// Status returns the VTODO STATUS of a task status.
+func Status(status models.TaskStatus) string {
+	switch status {
+	case models.StatusDone:
+		return "COMPLETED"
+	case models.StatusWontDo:
+		return "CANCELLED"
+	case models.StatusInProgress:
+		return "IN-PROCESS"
+	}
+	return "NEEDS-ACTION"
+}
+
+// Priority returns the VTODO PRIORITY of a task priority, or 0 for none.
+func Priority(priority string) int {
+	if len(priority) != 1 || priority[0] < 'A' || priority[0] > 'Z' {
+		return 0
+	}
+	return min(int(priority[0]-'A')+1, 9)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,80 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+func TestWriter(t *testing.T) {
+	stamp := time.Date(2024, 4, 14, 9, 0, 0, 0, time.UTC)
+	due := time.Date(2024, 4, 30, 17, 0, 0, 0, time.UTC)
+
+	var b strings.Builder
+	w := NewWriter(&b, "Tasks", stamp)
+	w.WriteTask(&models.Task{ID: 1, Title: "Call the bank, then pay; quickly", Status: models.StatusTodo, Priority: "B", DueAt: &due})
+	w.WriteTask(&models.Task{ID: 2, Title: "Learn Golang", Description: strings.Repeat("é", 50), Status: models.StatusDone, CompletedAt: &stamp})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:task-1@todoapp\r\n",
+		"SUMMARY:Call the bank\\, then pay\\; quickly\r\n",
+		"DUE:20240430T170000Z\r\n",
+		"PRIORITY:2\r\n",
+		"STATUS:NEEDS-ACTION\r\n",
+		"STATUS:COMPLETED\r\n",
+		"COMPLETED:20240414T090000Z\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	for _, line := range strings.Split(out, "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+	if unfolded := strings.ReplaceAll(out, "\r\n ", ""); !strings.Contains(unfolded, "DESCRIPTION:"+strings.Repeat("é", 50)+"\r\n") {
+		t.Errorf("folded description does not unfold:\n%s", out)
+	}
+}
+
+func TestParseTodo(t *testing.T) {
+	due := time.Date(2024, 4, 30, 17, 0, 0, 0, time.UTC)
+
+	var b strings.Builder
+	w := NewWriter(&b, "Tasks", due)
+	w.WriteTodo(&models.Task{Title: "Call the bank, then pay", Description: strings.Repeat("é", 50), Status: models.StatusInProgress, Priority: "C", DueAt: &due}, "4b1c@example.com")
+	w.Close()
+
+	todo, err := ParseTodo(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.UID != "4b1c@example.com" || todo.Task.Title != "Call the bank, then pay" || todo.Task.Description != strings.Repeat("é", 50) {
+		t.Errorf("got %+v", todo)
+	}
+	if todo.Task.Status != models.StatusInProgress || todo.Task.Priority != "C" || !todo.Task.DueAt.Equal(due) {
+		t.Errorf("got %+v", todo.Task)
+	}
+
+	todo, err = ParseTodo(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VTODO\nUID:1\nSUMMARY:Learn Golang\nDUE;TZID=Europe/Istanbul:20240430T200000\nSTATUS:COMPLETED\nEND:VTODO\nEND:VCALENDAR\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !todo.Task.DueAt.Equal(due) || todo.Task.Status != models.StatusDone {
+		t.Errorf("got due %v status %q", todo.Task.DueAt, todo.Task.Status)
+	}
+
+	if _, err := ParseTodo(strings.NewReader("BEGIN:VCALENDAR\nEND:VCALENDAR\n")); err != ErrNoTodo {
+		t.Errorf("got %v want %v", err, ErrNoTodo)
+	}
+}
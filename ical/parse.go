@@ -0,0 +1,150 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+var ErrNoTodo = errors.New("calendar has no VTODO")
+
+// Todo is a VTODO read by ParseTodo.
+type Todo struct {
+	UID string
+	// Task holds the properties of the to-do that map to task fields. Its
+	// Status is empty when the to-do has no STATUS.
+	Task models.Task
+}
+
+// ParseTodo reads the first VTODO of a calendar. Properties other than
+// UID, SUMMARY, DESCRIPTION, DUE, PRIORITY, STATUS and COMPLETED are
+// ignored. Times with a TZID are read in that zone when it is known, and
+// floating times as UTC.
+func ParseTodo(r io.Reader) (*Todo, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var todo *Todo
+	for _, line := range lines {
+		name, params, value := splitLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VTODO") && todo == nil:
+			todo = &Todo{}
+		case todo == nil:
+		case name == "END" && strings.EqualFold(value, "VTODO"):
+			return todo, nil
+		case name == "UID":
+			todo.UID = unescape(value)
+		case name == "SUMMARY":
+			todo.Task.Title = unescape(value)
+		case name == "DESCRIPTION":
+			todo.Task.Description = unescape(value)
+		case name == "DUE":
+			due, err := parseTime(value, params)
+			if err != nil {
+				return nil, err
+			}
+			todo.Task.DueAt = &due
+		case name == "COMPLETED":
+			completed, err := parseTime(value, params)
+			if err != nil {
+				return nil, err
+			}
+			todo.Task.CompletedAt = &completed
+		case name == "PRIORITY":
+			p, err := strconv.Atoi(value)
+			if err != nil || p < 0 || p > 9 {
+				return nil, errors.New("invalid PRIORITY " + value)
+			}
+			if p > 0 {
+				todo.Task.Priority = string(rune('A' + p - 1))
+			}
+		case name == "STATUS":
+			switch strings.ToUpper(value) {
+			case "COMPLETED":
+				todo.Task.Status = models.StatusDone
+			case "CANCELLED":
+				todo.Task.Status = models.StatusWontDo
+			case "IN-PROCESS":
+				todo.Task.Status = models.StatusInProgress
+			default:
+				todo.Task.Status = models.StatusTodo
+			}
+		}
+	}
+
+	if todo != nil {
+		return nil, errors.New("unterminated VTODO")
+	}
+	return nil, ErrNoTodo
+}
+
+// unfold reads the content lines, joining folded ones.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitLine splits "NAME;PARAM=x:value" into its upper-cased name, its
+// parameters and its value.
+func splitLine(line string) (string, map[string]string, string) {
+	head, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(head, ";")
+	params := map[string]string{}
+	for _, p := range parts[1:] {
+		key, v, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(key)] = strings.Trim(v, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+func parseTime(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, errors.New("invalid date " + value)
+		}
+		return t, nil
+	}
+
+	if t, err := time.Parse(dateTimeLayout, value); err == nil {
+		return t, nil
+	}
+
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, errors.New("invalid date-time " + value)
+	}
+	return t.UTC(), nil
+}
+
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
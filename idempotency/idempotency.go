@@ -0,0 +1,222 @@
+// Package idempotency lets clients retry mutating requests safely.
+//
+// A POST, PUT, PATCH or DELETE request carrying an Idempotency-Key header
+// is run once per user and key. The response of the first request is
+// stored along with a fingerprint of the request (method, path, query and
+// body), and retries with the same key get the stored response again with
+// an Idempotent-Replayed: true header. Reusing a key for a different
+// request is rejected with 422, and retrying while the first request is
+// still running with 409.
+//
+// Server errors (5xx), responses larger than 1MB and requests whose handler
+// panicked are not stored, so the request may be retried. Request bodies
+// over MaxBodySize are rejected with 413. Stored keys expire after the TTL
+// given to New.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// DefaultTTL is how long keys are kept unless configured otherwise.
+	DefaultTTL = 24 * time.Hour
+
+	// MaxBodySize is the largest request body read to fingerprint a
+	// request. It must allow the largest body accepted by the routes, the
+	// 10MB of POST /import.
+	MaxBodySize = 10 << 20
+
+	maxKeyLength = 255
+	// maxStoredBody is the largest response that is stored for replay.
+	maxStoredBody = 1 << 20
+	// staleAfter is the time after which a key whose first request never
+	// completed, e.g. because the server crashed, may be claimed again.
+	staleAfter = time.Minute
+)
+
+// Middleware stores and replays the responses of requests made with an
+// Idempotency-Key header.
+type Middleware struct {
+	store  store.Store
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// New creates a Middleware keeping keys in store for ttl. Requests are
+// attributed to users by their tokens, signed with the JWT secret.
+func New(store store.Store, secret []byte, ttl time.Duration) *Middleware {
+	return &Middleware{
+		store:  store,
+		secret: secret,
+		ttl:    ttl,
+		now:    func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Handler wraps next. Requests without the header, with a safe method or
+// without a valid token are passed to next unchanged.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || !mutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Unauthenticated requests are rejected by the handler itself.
+		userID, err := auth.GetUserIDFromToken(m.secret, auth.GetTokenFromRequest(r))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := m.now()
+		k := &models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Fingerprint: fingerprint(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(m.ttl),
+		}
+
+		existing, err := m.store.ClaimIdempotencyKey(k, now.Add(-staleAfter))
+		if err != nil {
+			log.Println("idempotency: claiming key:", err)
+			http.Error(w, "Error checking idempotency key", http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != k.Fingerprint:
+				http.Error(w, "Idempotency-Key was used with a different request", http.StatusUnprocessableEntity)
+			case existing.StatusCode == 0:
+				http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+			default:
+				replay(w, existing)
+			}
+			return
+		}
+
+		// The key is released unless the response is stored, including when
+		// next panics, so that the client can retry right away.
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			if err := m.store.DeleteIdempotencyKey(userID, key); err != nil {
+				log.Println("idempotency: deleting key:", err)
+			}
+		}()
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= 500 || rec.overflow {
+			return
+		}
+
+		stored = true
+		k.StatusCode = rec.status
+		k.ContentType = rec.Header().Get("Content-Type")
+		k.Body = rec.body.Bytes()
+		if err := m.store.CompleteIdempotencyKey(k); err != nil {
+			log.Println("idempotency: storing response:", err)
+		}
+	})
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+"\n"+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, k *models.IdempotencyKey) {
+	if k.ContentType != "" {
+		w.Header().Set("Content-Type", k.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(k.StatusCode)
+	w.Write(k.Body)
+}
+
+// recorder passes the response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > maxStoredBody {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
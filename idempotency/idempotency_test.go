@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestMiddleware(t *testing.T) {
+	token, err := auth.CreateJWT([]byte("testSecret"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	status := http.StatusCreated
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"id":1}`))
+	})
+
+	st := store.NewMockStore()
+	clock := time.Now().UTC()
+	m := New(st, []byte("testSecret"), time.Hour)
+	m.now = func() time.Time { return clock }
+	handler := m.Handler(next)
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		if key != "" {
+			req.Header.Set(Header, key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := do("a", `{"title":"Learn Golang"}`)
+	retry := do("a", `{"title":"Learn Golang"}`)
+	if calls != 1 {
+		t.Fatalf("got %d calls want 1", calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("retry: got %d %q", retry.Code, retry.Body)
+	}
+	if retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("retry: got content type %q", retry.Header().Get("Content-Type"))
+	}
+
+	if rr := do("a", `{"title":"Learn Go"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reuse with a different body: got %d want 422", rr.Code)
+	}
+
+	do("", `{"title":"Learn Golang"}`)
+	if calls != 2 {
+		t.Errorf("without key: got %d calls want 2", calls)
+	}
+
+	// Server errors are not stored, so the request can be retried.
+	status = http.StatusInternalServerError
+	do("b", `{}`)
+	status = http.StatusCreated
+	if rr := do("b", `{}`); rr.Code != http.StatusCreated || calls != 4 {
+		t.Errorf("retry after server error: got %d after %d calls", rr.Code, calls)
+	}
+
+	// Expired keys can be used again.
+	clock = clock.Add(2 * time.Hour)
+	if deleted, _ := st.DeleteExpiredIdempotencyKeys(clock); deleted != 2 {
+		t.Errorf("got %d expired keys want 2", deleted)
+	}
+	do("a", `{"title":"Learn Go"}`)
+	if calls != 5 {
+		t.Errorf("after expiry: got %d calls want 5", calls)
+	}
+}
+
+func TestMiddlewareLimits(t *testing.T) {
+	token, err := auth.CreateJWT([]byte("testSecret"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	panics := true
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if panics {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	handler := New(store.NewMockStore(), []byte("testSecret"), time.Hour).Handler(next)
+
+	do := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/import", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		req.Header.Set(Header, "a")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(strings.Repeat("a", MaxBodySize+1)); rr.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Errorf("body too large: got %d after %d calls", rr.Code, calls)
+	}
+
+	// A panicking handler releases the key, so the retry is not rejected
+	// as in progress.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic not propagated")
+			}
+		}()
+		do(`{}`)
+	}()
+	panics = false
+	if rr := do(`{}`); rr.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("retry after panic: got %d after %d calls", rr.Code, calls)
+	}
+}
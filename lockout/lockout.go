@@ -0,0 +1,157 @@
+// Package lockout slows down password guessing by locking out the keys,
+// such as usernames or client addresses, with too many failed attempts.
+//
+// Once a key has failed Policy.Threshold times, it is locked for
+// Policy.BaseDelay, and every further failure doubles the lock up to
+// Policy.MaxDelay. Failures are forgotten after Policy.Window without any.
+//
+// Attempts are counted with Reserve before they are made and released when
+// they succeed, so that concurrent attempts cannot all pass the check of
+// the lock before any of them fails. Attempts are tracked in memory, so
+// every server instance counts its own.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy is when and how long keys are locked.
+type Policy struct {
+	// Threshold is the number of failures locking a key.
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is the time after the last failure of a key after which its
+	// failures are forgotten.
+	Window time.Duration
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracker counts the failed attempts of keys.
+type Tracker struct {
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// nextPrune is when the forgotten entries are next removed.
+	nextPrune time.Time
+}
+
+// New creates a Tracker locking keys according to the policy.
+func New(policy Policy) *Tracker {
+	return &Tracker{
+		policy:  policy,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+// Locked returns how long the key remains locked, or 0 if it is not.
+func (t *Tracker) Locked(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	return max(e.lockedUntil.Sub(t.now()), 0)
+}
+
+// Reserve counts an attempt of the key as failed before it is made, unless
+// the key is locked, in which case it returns how long it remains locked
+// and counts nothing. Release undoes it if the attempt succeeds.
+func (t *Tracker) Reserve(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok {
+		if wait := e.lockedUntil.Sub(t.now()); wait > 0 {
+			return wait
+		}
+	}
+	t.fail(key)
+	return 0
+}
+
+// Release undoes the failure counted by Reserve for an attempt that did not
+// fail, unlocking the key if it falls back below the threshold.
+func (t *Tracker) Release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	e.failures--
+	if e.failures <= 0 {
+		delete(t.entries, key)
+	} else if e.failures < t.policy.Threshold {
+		e.lockedUntil = time.Time{}
+	}
+}
+
+// Fail records a failed attempt of the key, locking it if it reached the
+// threshold.
+func (t *Tracker) Fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.fail(key)
+}
+
+func (t *Tracker) fail(key string) {
+	now := t.now()
+	t.prune(now)
+
+	e, ok := t.entries[key]
+	if !ok || t.forgotten(e, now) {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if extra := e.failures - t.policy.Threshold; extra >= 0 {
+		delay := t.policy.BaseDelay
+		for ; extra > 0 && delay < t.policy.MaxDelay; extra-- {
+			delay *= 2
+		}
+		e.lockedUntil = now.Add(min(delay, t.policy.MaxDelay))
+	}
+}
+
+// Reset forgets the failures of the key, unlocking it.
+func (t *Tracker) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+func (t *Tracker) forgotten(e *entry, now time.Time) bool {
+	return now.After(e.lockedUntil) && now.Sub(e.lastFailure) > t.policy.Window
+}
+
+// prune removes the forgotten entries, at most once per window, so that
+// keys failing once do not accumulate.
+func (t *Tracker) prune(now time.Time) {
+	if now.Before(t.nextPrune) {
+		return
+	}
+	t.nextPrune = now.Add(t.policy.Window)
+
+	for key, e := range t.entries {
+		if t.forgotten(e, now) {
+			delete(t.entries, key)
+		}
+	}
+}
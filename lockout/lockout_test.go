@@ -0,0 +1,89 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	clock := time.Now()
+	tr := New(Policy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, Window: time.Hour})
+	tr.now = func() time.Time { return clock }
+
+	for i := 0; i < 2; i++ {
+		tr.Fail("johnDoe")
+	}
+	if d := tr.Locked("johnDoe"); d != 0 {
+		t.Fatalf("locked for %v below the threshold", d)
+	}
+
+	// Every failure from the threshold on doubles the lock, up to MaxDelay.
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		tr.Fail("johnDoe")
+		if d := tr.Locked("johnDoe"); d != want {
+			t.Errorf("locked for %v, want %v", d, want)
+		}
+	}
+	if d := tr.Locked("janeDoe"); d != 0 {
+		t.Errorf("other key locked for %v", d)
+	}
+
+	clock = clock.Add(4 * time.Minute)
+	if d := tr.Locked("johnDoe"); d != 0 {
+		t.Errorf("locked for %v after the lock expired", d)
+	}
+	tr.Fail("johnDoe")
+	if d := tr.Locked("johnDoe"); d != 4*time.Minute {
+		t.Errorf("failure after the lock expired: locked for %v, want %v", d, 4*time.Minute)
+	}
+
+	tr.Reset("johnDoe")
+	tr.Fail("johnDoe")
+	if d := tr.Locked("johnDoe"); d != 0 {
+		t.Errorf("locked for %v after a reset", d)
+	}
+
+	// Failures are forgotten after the window.
+	tr.Fail("janeDoe")
+	tr.Fail("janeDoe")
+	clock = clock.Add(2 * time.Hour)
+	tr.Fail("janeDoe")
+	if d := tr.Locked("janeDoe"); d != 0 {
+		t.Errorf("locked for %v counting forgotten failures", d)
+	}
+	if _, ok := tr.entries["johnDoe"]; ok {
+		t.Error("forgotten key was not pruned")
+	}
+}
+
+func TestTrackerReserve(t *testing.T) {
+	clock := time.Now()
+	tr := New(Policy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, Window: time.Hour})
+	tr.now = func() time.Time { return clock }
+
+	// A reserved attempt counts as failed until it is released.
+	if d := tr.Reserve("johnDoe"); d != 0 {
+		t.Fatalf("first attempt locked for %v", d)
+	}
+	if d := tr.Reserve("johnDoe"); d != 0 {
+		t.Fatalf("second attempt locked for %v", d)
+	}
+	if d := tr.Reserve("johnDoe"); d != time.Minute {
+		t.Errorf("attempt over the threshold: locked for %v, want %v", d, time.Minute)
+	}
+
+	tr.Release("johnDoe")
+	if d := tr.Locked("johnDoe"); d != 0 {
+		t.Errorf("locked for %v after a release below the threshold", d)
+	}
+	if d := tr.Reserve("johnDoe"); d != 0 {
+		t.Errorf("attempt after a release: locked for %v", d)
+	}
+
+	tr.Reset("johnDoe")
+	tr.Reserve("johnDoe")
+	tr.Release("johnDoe")
+	if _, ok := tr.entries["johnDoe"]; ok {
+		t.Error("released key was not removed")
+	}
+}
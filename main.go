@@ -1,30 +1,48 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/hsrvms/todoapp/config"
 	"github.com/hsrvms/todoapp/database"
+	"github.com/hsrvms/todoapp/events"
 	"github.com/hsrvms/todoapp/server"
 	"github.com/hsrvms/todoapp/store"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	err := godotenv.Load()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal("invalid configuration:\n", err)
 	}
 
-	dbURI := os.Getenv("DB_URI")
-	pgStorage := database.NewPgStorage(dbURI)
+	pgStorage := database.NewPgStorage(cfg.DatabaseURI)
 	db, err := pgStorage.Init()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	repository := store.NewRepository(db)
-	api := server.NewAPIServer(":8080", repository)
-	api.Start()
+	listener := events.NewPgListener(cfg.DatabaseURI, repository)
+	api := server.NewAPIServer(cfg, repository, listener)
 
+	// SIGINT and SIGTERM drain the connections before the database is closed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = api.Start(ctx)
+	if closeErr := db.Close(); closeErr != nil {
+		log.Println("closing database:", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMaxAge is how long browsers may cache the answer to a preflight
+// request.
+const corsMaxAge = 10 * time.Minute
+
+var (
+	corsMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	// corsHeaders are the request headers that browsers may send.
+	corsHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", "Last-Event-ID", RequestIDHeader}
+	// corsExposedHeaders are the response headers that scripts may read.
+	corsExposedHeaders = []string{
+		"ETag", "Retry-After", "Idempotent-Replayed", RequestIDHeader,
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+	}
+)
+
+// CORS lets scripts on the origins, e.g. "https://todo.example.com", call
+// the API from browsers. "*" allows every origin. Requests from other
+// origins are served without CORS headers, so browsers hide the response
+// from their scripts.
+//
+// Preflight requests from allowed origins are answered without reaching
+// next.
+func CORS(origins []string) func(http.Handler) http.Handler {
+	anyOrigin := slices.Contains(origins, "*")
+	allowed := func(origin string) bool {
+		return origin != "" && (anyOrigin || slices.Contains(origins, origin))
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			if !allowed(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Set("Access-Control-Allow-Origin", origin)
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				header.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(corsHeaders, ", "))
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Package middleware holds the concerns shared by every route of the
+// server: request IDs, recovery from panics, logging, CORS and
+// compression. Each middleware wraps an http.Handler, so they compose with
+// router.Chain.
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/hsrvms/todoapp/realip"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length of the longest request ID accepted from
+// clients.
+const maxRequestIDLength = 128
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// RequestID gives every request an ID, sent back in the X-Request-ID
+// header and available to handlers with GetRequestID. The ID of a request
+// that already has the header, e.g. from a proxy, is kept if it is valid.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// GetRequestID returns the ID given to the request by RequestID.
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Recover answers the requests whose handler panicked with 500 rather
+// than dropping the connection, and logs the panic.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseWriter(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("%s panic serving %s %s: %v\n%s", GetRequestID(r.Context()), r.Method, r.URL.Path, err, debug.Stack())
+			if !rw.wroteHeader {
+				http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Logger logs every request with its ID, client address, response status
+// and duration.
+func Logger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := newResponseWriter(w)
+		defer func() {
+			log.Printf("%s %s %s %s %d %v", GetRequestID(r.Context()), realip.Host(r), r.Method, r.URL.Path, rw.status, time.Since(start))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter records the status of a response. It lets handlers flush
+// and hijack the connection, and reach the underlying writer through
+// http.ResponseController.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = status >= 200
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	w.wroteHeader = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// The handler takes over the connection, so the status is that of the
+	// upgrade.
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDAndRecover(t *testing.T) {
+	var seen string
+	handler := RequestID(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestID(r.Context())
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+	})))
+
+	do := func(target, requestID string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("/", "")
+	if id := rr.Header().Get(RequestIDHeader); len(id) != 32 || id != seen {
+		t.Errorf("generated request ID %q, handler saw %q", id, seen)
+	}
+	if rr := do("/", "abc-123"); rr.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Errorf("incoming request ID not kept: %q", rr.Header().Get(RequestIDHeader))
+	}
+	if rr := do("/", "bad id\n"); rr.Header().Get(RequestIDHeader) == "bad id\n" {
+		t.Error("invalid incoming request ID kept")
+	}
+
+	if rr := do("/panic", ""); rr.Code != http.StatusInternalServerError {
+		t.Errorf("panic: got %d, want 500", rr.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	calls := 0
+	handler := CORS([]string{"https://todo.example.com"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	do := func(method, origin string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/v1/tasks", nil)
+		req.Header.Set("Origin", origin)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("OPTIONS", "https://todo.example.com", map[string]string{"Access-Control-Request-Method": "PUT"})
+	if rr.Code != http.StatusNoContent || calls != 0 || !strings.Contains(rr.Header().Get("Access-Control-Allow-Methods"), "PUT") {
+		t.Errorf("preflight: got %d, %d calls, header %v", rr.Code, calls, rr.Header())
+	}
+
+	rr = do("GET", "https://todo.example.com", nil)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://todo.example.com" || calls != 1 {
+		t.Errorf("allowed origin: header %v, %d calls", rr.Header(), calls)
+	}
+
+	rr = do("GET", "https://evil.example.com", nil)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" || calls != 2 {
+		t.Errorf("other origin: header %v, %d calls", rr.Header(), calls)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"title": "Learn Golang"}`, 100)
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"abc"`)
+		}
+		io.WriteString(w, body)
+	}))
+
+	do := func(target, acceptEncoding string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("/tasks", "br, gzip")
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("ETag") != `W/"abc"` {
+		t.Fatalf("got header %v", rr.Header())
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gz); !bytes.Equal(got, []byte(body)) {
+		t.Errorf("decompressed body differs")
+	}
+
+	for _, tc := range []struct{ target, acceptEncoding string }{
+		{"/tasks", ""},
+		{"/tasks", "gzip;q=0"},
+		{"/events", "gzip"},
+	} {
+		rr := do(tc.target, tc.acceptEncoding)
+		if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != body {
+			t.Errorf("%s with Accept-Encoding %q: compressed", tc.target, tc.acceptEncoding)
+		}
+	}
+}
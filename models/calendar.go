@@ -0,0 +1,12 @@
+package models
+
+// CalDAVResource names the calendar resource of a task created or renamed
+// by a CalDAV client. Tasks without one are served as "{id}.ics" with a
+// UID derived from their ID.
+type CalDAVResource struct {
+	TaskID int64
+	UserID int64
+	// Name is the last segment of the resource URL, e.g. "4b1c….ics".
+	Name string
+	UID  string
+}
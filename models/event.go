@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// TaskEventType names a change to a task.
+type TaskEventType string
+
+const (
+	TaskCreated TaskEventType = "task.created"
+	TaskUpdated TaskEventType = "task.updated"
+	TaskDeleted TaskEventType = "task.deleted"
+
+	// TasksReset tells clients that events may have been missed and their
+	// tasks should be reloaded. It carries no task.
+	TasksReset TaskEventType = "reset"
+)
+
+// TaskEvent records a change to a task, carrying the task as it was after
+// the change (or before it, for deletions).
+type TaskEvent struct {
+	ID        int64         `json:"id"`
+	Type      TaskEventType `json:"type"`
+	UserID    int64         `json:"user_id"`
+	Task      *Task         `json:"task"`
+	CreatedAt time.Time     `json:"created_at"`
+	// Partial is set when Task only holds the ID and owner of the task
+	// because the full event was too large to be sent between instances.
+	Partial bool `json:"partial,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyKey records the response to a request sent with an
+// Idempotency-Key header, so that retries of the request can be answered
+// without running it again.
+type IdempotencyKey struct {
+	UserID int64
+	Key    string
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	// StatusCode is zero while the first request is in progress.
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
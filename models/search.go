@@ -0,0 +1,9 @@
+package models
+
+// TaskSearchResult is a task matched by a search, with its relevance and an
+// excerpt in which the matched terms are wrapped in <mark></mark>.
+type TaskSearchResult struct {
+	Task    *Task   `json:"task"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TaskStatus is the position of a task in a Workflow.
+//
+// An empty status means "not done": it resolves to the workflow's initial
+// status for new tasks and leaves an open task where it is.
+type TaskStatus string
+
+const (
+	StatusTodo       TaskStatus = "todo"
+	StatusInProgress TaskStatus = "in_progress"
+	StatusBlocked    TaskStatus = "blocked"
+	StatusDone       TaskStatus = "done"
+	StatusWontDo     TaskStatus = "wont_do"
+)
+
+// UnmarshalJSON accepts the status name as well as the boolean used by
+// older clients, where true means done and false means not done.
+func (s *TaskStatus) UnmarshalJSON(b []byte) error {
+	var done bool
+	if err := json.Unmarshal(b, &done); err == nil {
+		if done {
+			*s = StatusDone
+		} else {
+			*s = ""
+		}
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return fmt.Errorf("status must be a string or a boolean: %v", err)
+	}
+	*s = TaskStatus(name)
+
+	return nil
+}
+
+// Workflow describes the statuses a task can be in and the transitions
+// allowed between them.
+type Workflow struct {
+	Initial     TaskStatus                  `json:"initial"`
+	Done        []TaskStatus                `json:"done"`
+	Transitions map[TaskStatus][]TaskStatus `json:"transitions"`
+}
+
+// DefaultWorkflow is the workflow used for all tasks.
+var DefaultWorkflow = Workflow{
+	Initial: StatusTodo,
+	Done:    []TaskStatus{StatusDone, StatusWontDo},
+	Transitions: map[TaskStatus][]TaskStatus{
+		StatusTodo:       {StatusInProgress, StatusBlocked, StatusDone, StatusWontDo},
+		StatusInProgress: {StatusTodo, StatusBlocked, StatusDone, StatusWontDo},
+		StatusBlocked:    {StatusTodo, StatusInProgress, StatusWontDo},
+		StatusDone:       {StatusTodo, StatusInProgress},
+		StatusWontDo:     {StatusTodo},
+	},
+}
+
+// Has reports whether the status belongs to the workflow.
+func (w Workflow) Has(status TaskStatus) bool {
+	_, ok := w.Transitions[status]
+	return ok
+}
+
+// IsDone reports whether the status completes a task.
+func (w Workflow) IsDone(status TaskStatus) bool {
+	for _, done := range w.Done {
+		if status == done {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTransition reports whether a task may move from one status to another.
+// Staying in the same status is always allowed.
+func (w Workflow) CanTransition(from, to TaskStatus) bool {
+	if from == to {
+		return w.Has(to)
+	}
+	for _, next := range w.Transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTaskStatusUnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		json    string
+		want    TaskStatus
+		wantErr bool
+	}{
+		{`"in_progress"`, StatusInProgress, false},
+		{`"done"`, StatusDone, false},
+		// Unknown names are decoded, and rejected against the workflow.
+		{`"finished"`, "finished", false},
+		{`""`, "", false},
+		// Older clients send a boolean.
+		{`true`, StatusDone, false},
+		{`false`, "", false},
+		{`1`, "", true},
+		{`["done"]`, "", true},
+	} {
+		var task struct {
+			Status TaskStatus `json:"status"`
+		}
+		err := json.Unmarshal([]byte(`{"status": `+tc.json+`}`), &task)
+		if (err != nil) != tc.wantErr || task.Status != tc.want {
+			t.Errorf("%s: got %q, %v", tc.json, task.Status, err)
+		}
+	}
+}
+
+func TestDefaultWorkflow(t *testing.T) {
+	w := DefaultWorkflow
+	if !w.Has(w.Initial) {
+		t.Errorf("initial status %q is not in the workflow", w.Initial)
+	}
+	for _, status := range w.Done {
+		if !w.Has(status) {
+			t.Errorf("done status %q is not in the workflow", status)
+		}
+	}
+
+	for _, tc := range []struct {
+		from, to TaskStatus
+		want     bool
+	}{
+		{StatusTodo, StatusTodo, true},
+		{StatusTodo, StatusInProgress, true},
+		{StatusTodo, StatusBlocked, true},
+		{StatusTodo, StatusDone, true},
+		{StatusTodo, StatusWontDo, true},
+		{StatusInProgress, StatusTodo, true},
+		{StatusInProgress, StatusDone, true},
+		{StatusBlocked, StatusInProgress, true},
+		{StatusBlocked, StatusWontDo, true},
+		{StatusDone, StatusTodo, true},
+		{StatusDone, StatusInProgress, true},
+		{StatusWontDo, StatusTodo, true},
+		// A blocked task must be unblocked before it is done.
+		{StatusBlocked, StatusDone, false},
+		{StatusDone, StatusBlocked, false},
+		{StatusDone, StatusWontDo, false},
+		{StatusWontDo, StatusDone, false},
+		{StatusWontDo, StatusInProgress, false},
+		{StatusTodo, "finished", false},
+		{"finished", "finished", false},
+		{"finished", StatusTodo, false},
+	} {
+		if got := w.CanTransition(tc.from, tc.to); got != tc.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		status TaskStatus
+		want   bool
+	}{
+		{StatusTodo, false},
+		{StatusInProgress, false},
+		{StatusBlocked, false},
+		{StatusDone, true},
+		{StatusWontDo, true},
+		{"", false},
+	} {
+		if got := w.IsDone(tc.status); got != tc.want {
+			t.Errorf("IsDone(%q) = %v, want %v", tc.status, got, tc.want)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 package models
 
+import "time"
+
 type Task struct {
-	ID          int64  `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Status      bool   `json:"status"`
-	CreatedAt   string `json:"created_at"`
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      TaskStatus `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ArchivedAt  *time.Time `json:"archived_at"`
+	DueAt       *time.Time `json:"due_at"`
+	// Priority is "A" (highest) to "Z", or empty for none, as in todo.txt.
+	Priority string `json:"priority"`
 }
@@ -1,8 +1,19 @@
 package models
 
+import "time"
+
+// User is a stored account. Password holds the bcrypt hash and is never
+// encoded.
 type User struct {
-	ID        int64  `json:"id"`
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	CreatedAt string `json:"created_at"`
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserSettings holds the per-user preferences.
+type UserSettings struct {
+	// AutoArchiveDays archives completed tasks this many days after they
+	// were completed. Zero disables automatic archival.
+	AutoArchiveDays int `json:"auto_archive_days"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// SavedView is a named task query saved by a user.
+type SavedView struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Webhook subscribes a URL to the task events of a user.
+type Webhook struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	URL    string `json:"url"`
+	// Events lists the event types delivered to the URL. Empty means all.
+	Events []TaskEventType `json:"events"`
+	// Secret signs the deliveries. It is only shown when the webhook is
+	// created or rotated.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether events of the type are delivered to the webhook.
+func (h *Webhook) Wants(eventType TaskEventType) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, eventType)
+}
+
+// WebhookDeliveryStatus is the state of a delivery in the queue.
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+	// DeliveryDead marks a delivery that failed too many times and is no
+	// longer retried unless asked to.
+	DeliveryDead WebhookDeliveryStatus = "dead"
+)
+
+// WebhookDelivery is one event queued for a webhook, along with the outcome
+// of its latest attempt.
+type WebhookDelivery struct {
+	ID             int64                 `json:"id"`
+	WebhookID      int64                 `json:"webhook_id"`
+	EventType      TaskEventType         `json:"event_type"`
+	Payload        json.RawMessage       `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  time.Time             `json:"next_attempt_at"`
+	LastStatusCode int                   `json:"last_status_code,omitempty"`
+	LastError      string                `json:"last_error,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+
+	// URL and Secret are copied from the webhook when the delivery is
+	// claimed for sending.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
@@ -0,0 +1,63 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type status string
+
+type item struct {
+	ID      int64      `json:"id"`
+	Name    string     `json:"name,omitempty"`
+	Status  status     `json:"status"`
+	DueAt   *time.Time `json:"due_at"`
+	Tags    []string   `json:"tags"`
+	Parent  *item      `json:"parent"`
+	Ignored string     `json:"-"`
+	private string
+}
+
+func TestSchema(t *testing.T) {
+	doc := New("Test", "1.0.0", "/api")
+	doc.DefineEnum(status(""), "open", "closed")
+
+	ref := doc.Schema([]*item{})
+	got, err := json.Marshal(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"array","items":{"$ref":"#/components/schemas/Item"}}`; string(got) != want {
+		t.Errorf("Schema = %s, want %s", got, want)
+	}
+
+	got, err = json.Marshal(doc.Components.Schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Item":{"type":"object","properties":{` +
+		`"due_at":{"type":["string","null"],"format":"date-time"},` +
+		`"id":{"type":"integer","format":"int64"},` +
+		`"name":{"type":"string"},` +
+		`"parent":{"$ref":"#/components/schemas/Item"},` +
+		`"status":{"$ref":"#/components/schemas/Status"},` +
+		`"tags":{"type":"array","items":{"type":"string"}}}},` +
+		`"Status":{"type":"string","enum":["open","closed"]}}`
+	if string(got) != want {
+		t.Errorf("components =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAddOperationPathParameters(t *testing.T) {
+	doc := New("Test", "1.0.0", "/api")
+	op := &Operation{Responses: map[string]*Response{"200": {Description: "OK"}}}
+	doc.AddOperation("POST", "/webhooks/{id}/deliveries/{deliveryID}/retry", op)
+
+	if (*doc.Paths["/webhooks/{id}/deliveries/{deliveryID}/retry"])["post"] != op {
+		t.Fatal("operation not added")
+	}
+	if len(op.Parameters) != 2 || op.Parameters[0].Name != "id" || op.Parameters[1].Name != "deliveryID" {
+		t.Errorf("parameters = %+v", op.Parameters)
+	}
+}
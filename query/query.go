@@ -0,0 +1,260 @@
+// Package query parses the task query language shared by saved views and
+// task search.
+//
+// A query is a list of space separated terms. A term of the form
+// field:value, field<value, field>value, field<=value or field>=value is a
+// condition on a field; every other term is free text. Double quotes keep
+// spaces inside a single term. All conditions must hold.
+//
+//	status:open created<7d "release notes"
+//
+// Fields:
+//
+//	status     a comma separated list of statuses, or "open" and "closed"
+//	           for the statuses that are not done and done
+//	archived   true or false
+//	created    creation time
+//	completed  completion time
+//
+// Times are compared with a date (2024-04-12) or with an age such as 12h,
+// 7d or 2w: created<7d matches tasks created less than 7 days ago.
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+// Query is a parsed query.
+type Query struct {
+	// Filter holds the conditions. Its UserID is left for the caller.
+	Filter store.TaskFilter
+	// Text holds the free text terms.
+	Text []string
+}
+
+// TextQuery returns the free text terms joined by spaces.
+func (q *Query) TextQuery() string {
+	return strings.Join(q.Text, " ")
+}
+
+// Error reports an invalid term.
+type Error struct {
+	Term string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid query term %q: %s", e.Term, e.Msg)
+}
+
+// Parse parses the query. Status names are checked against the workflow and
+// ages are relative to now.
+func Parse(input string, workflow models.Workflow, now time.Time) (*Query, error) {
+	terms, err := split(input)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{}
+	for _, term := range terms {
+		if term.quoted {
+			q.Text = append(q.Text, term.text)
+			continue
+		}
+
+		field, op, value, ok := cut(term.text)
+		if !ok {
+			q.Text = append(q.Text, term.text)
+			continue
+		}
+
+		var err error
+		switch field {
+		case "status":
+			err = parseStatus(&q.Filter, op, value, workflow)
+		case "archived":
+			err = parseArchived(&q.Filter, op, value)
+		case "created":
+			err = parseTime(&q.Filter.CreatedAfter, &q.Filter.CreatedBefore, op, value, now)
+		case "completed":
+			err = parseTime(&q.Filter.CompletedAfter, &q.Filter.CompletedBefore, op, value, now)
+		default:
+			err = fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return nil, &Error{Term: term.text, Msg: err.Error()}
+		}
+	}
+
+	return q, nil
+}
+
+type term struct {
+	text   string
+	quoted bool
+}
+
+// split breaks the input into terms at spaces outside double quotes. A term
+// that is entirely quoted is free text even when it contains an operator.
+func split(input string) ([]term, error) {
+	var terms []term
+	var b strings.Builder
+	inQuotes, quoted := false, false
+
+	flush := func() {
+		if b.Len() > 0 || quoted {
+			terms = append(terms, term{text: b.String(), quoted: quoted})
+		}
+		b.Reset()
+		quoted = false
+	}
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			if b.Len() == 0 {
+				quoted = true
+			}
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, &Error{Term: input, Msg: "unterminated quote"}
+	}
+	flush()
+
+	return terms, nil
+}
+
+// cut splits a condition into its field, operator and value. It reports
+// false for terms that are not conditions.
+func cut(text string) (field, op, value string, ok bool) {
+	i := strings.IndexAny(text, ":<>")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	for _, r := range text[:i] {
+		if !unicode.IsLetter(r) && r != '_' {
+			return "", "", "", false
+		}
+	}
+
+	op = text[i : i+1]
+	if op != ":" && strings.HasPrefix(text[i+1:], "=") {
+		op += "="
+	}
+	return strings.ToLower(text[:i]), op, text[i+len(op):], true
+}
+
+func parseStatus(f *store.TaskFilter, op, value string, workflow models.Workflow) error {
+	if op != ":" {
+		return fmt.Errorf("status only supports %q", ":")
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		switch name {
+		case "open", "closed":
+			for status := range workflow.Transitions {
+				if workflow.IsDone(status) == (name == "closed") {
+					f.Statuses = append(f.Statuses, status)
+				}
+			}
+		default:
+			status := models.TaskStatus(name)
+			if !workflow.Has(status) {
+				return fmt.Errorf("unknown status %q", name)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	slices.Sort(f.Statuses)
+
+	return nil
+}
+
+func parseArchived(f *store.TaskFilter, op, value string) error {
+	if op != ":" {
+		return fmt.Errorf("archived only supports %q", ":")
+	}
+
+	archived, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("archived must be true or false")
+	}
+	f.Archived = archived
+
+	return nil
+}
+
+// parseTime narrows the [after, before) range of a time field.
+func parseTime(after, before **time.Time, op, value string, now time.Time) error {
+	if age, ok := parseAge(value); ok {
+		// An age counts back from now, so "less than" moves the lower
+		// bound and "more than" moves the upper bound.
+		t := now.Add(-age)
+		switch op {
+		case "<", "<=":
+			*after = &t
+		case ">", ">=":
+			*before = &t
+		default:
+			return fmt.Errorf("ages are compared with < or >")
+		}
+		return nil
+	}
+
+	day, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return fmt.Errorf("expected a date (2006-01-02) or an age (12h, 7d, 2w)")
+	}
+	next := day.AddDate(0, 0, 1)
+	switch op {
+	case ":":
+		*after, *before = &day, &next
+	case "<":
+		*before = &day
+	case "<=":
+		*before = &next
+	case ">":
+		*after = &next
+	case ">=":
+		*after = &day
+	}
+
+	return nil
+}
+
+// parseAge parses an age made of a number and one of the units h, d or w.
+func parseAge(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	switch value[len(value)-1] {
+	case 'h':
+		return time.Duration(n) * time.Hour, true
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, true
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,108 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 4, 12, 18, 0, 0, 0, time.UTC)
+	weekAgo := now.AddDate(0, 0, -7)
+	day := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	nextDay := day.AddDate(0, 0, 1)
+
+	testCases := []struct {
+		name   string
+		input  string
+		exp    *Query
+		expErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			exp:   &Query{},
+		},
+		{
+			name:  "text",
+			input: `learn "go lang"`,
+			exp:   &Query{Text: []string{"learn", "go lang"}},
+		},
+		{
+			name:  "open",
+			input: "status:open",
+			exp: &Query{Filter: store.TaskFilter{Statuses: []models.TaskStatus{
+				models.StatusBlocked, models.StatusInProgress, models.StatusTodo,
+			}}},
+		},
+		{
+			name:  "status list",
+			input: "status:done,wont_do",
+			exp: &Query{Filter: store.TaskFilter{Statuses: []models.TaskStatus{
+				models.StatusDone, models.StatusWontDo,
+			}}},
+		},
+		{
+			name:  "age and text",
+			input: "created<7d archived:true notes",
+			exp: &Query{
+				Filter: store.TaskFilter{CreatedAfter: &weekAgo, Archived: true},
+				Text:   []string{"notes"},
+			},
+		},
+		{
+			name:  "date",
+			input: "completed:2024-04-01",
+			exp:   &Query{Filter: store.TaskFilter{CompletedAfter: &day, CompletedBefore: &nextDay}},
+		},
+		{
+			name:  "date bounds",
+			input: "created>=2024-04-01 created<=2024-04-01",
+			exp:   &Query{Filter: store.TaskFilter{CreatedAfter: &day, CreatedBefore: &nextDay}},
+		},
+		{
+			name:   "unknown field",
+			input:  "tag:work",
+			expErr: true,
+		},
+		{
+			name:   "unknown status",
+			input:  "status:waiting",
+			expErr: true,
+		},
+		{
+			name:   "invalid time",
+			input:  "created<soon",
+			expErr: true,
+		},
+		{
+			name:   "unterminated quote",
+			input:  `"learn go`,
+			expErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := Parse(tc.input, models.DefaultWorkflow, now)
+			if tc.expErr {
+				var queryErr *Error
+				if !errors.As(err, &queryErr) {
+					t.Fatalf("got error %v want *Error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(q, tc.exp) {
+				t.Errorf("got %+v want %+v", q, tc.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often MemoryBackend removes the full buckets.
+const pruneInterval = time.Minute
+
+// MemoryBackend keeps the buckets in memory, so that every server instance
+// counts its own requests.
+type MemoryBackend struct {
+	mu   sync.Mutex
+	tats map[string]time.Time
+	// nextPrune is when the full buckets are next removed.
+	nextPrune time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{tats: map[string]time.Time{}}
+}
+
+func (b *MemoryBackend) TakeRateLimit(key string, interval, burst time.Duration, now time.Time) (time.Time, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(now)
+
+	tat := b.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	if next.After(now.Add(burst)) {
+		return tat, false, nil
+	}
+	b.tats[key] = next
+	return next, true, nil
+}
+
+// prune removes the buckets that refilled, which are the same as missing
+// ones.
+func (b *MemoryBackend) prune(now time.Time) {
+	if now.Before(b.nextPrune) {
+		return
+	}
+	b.nextPrune = now.Add(pruneInterval)
+
+	for key, tat := range b.tats {
+		if !tat.After(now) {
+			delete(b.tats, key)
+		}
+	}
+}
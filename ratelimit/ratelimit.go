@@ -0,0 +1,147 @@
+// Package ratelimit throttles the requests of each user and client
+// address.
+//
+// Every key, the ID of an authenticated user or the address of an
+// anonymous client, has a bucket holding up to Quota.Limit requests, which
+// refills evenly over Quota.Period. The buckets are kept as the theoretical
+// arrival time of the next request (the generic cell rate algorithm), so a
+// Backend only has to store one time per key.
+//
+// Responses carry the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers of the IETF draft, and throttled requests are
+// answered with 429 and a Retry-After header.
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/realip"
+)
+
+// Quota is the number of requests allowed per period.
+type Quota struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseQuota parses a quota written as "<limit>/<period>", e.g. "100/1m".
+func ParseQuota(s string) (Quota, error) {
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Quota{}, fmt.Errorf("invalid quota %q: want <limit>/<period>", s)
+	}
+
+	q := Quota{}
+	var err error
+	if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+		return Quota{}, fmt.Errorf("invalid quota %q: limit must be a positive integer", s)
+	}
+	if q.Period, err = time.ParseDuration(period); err != nil || q.Period <= 0 {
+		return Quota{}, fmt.Errorf("invalid quota %q: period must be a positive duration", s)
+	}
+	return q, nil
+}
+
+func (q Quota) String() string {
+	return fmt.Sprintf("%d/%v", q.Limit, q.Period)
+}
+
+// interval is the time it takes to refill one request.
+func (q Quota) interval() time.Duration {
+	return q.Period / time.Duration(q.Limit)
+}
+
+// burst is the time it takes to refill an empty bucket.
+func (q Quota) burst() time.Duration {
+	return q.interval() * time.Duration(q.Limit)
+}
+
+// Backend stores the theoretical arrival times of the keys. store.Store
+// implements it to share the buckets between server instances.
+type Backend interface {
+	// TakeRateLimit admits a request of the key at now if its theoretical
+	// arrival time, advanced by interval, is at most burst past now. It
+	// returns the time after the request, and whether it was admitted.
+	TakeRateLimit(key string, interval, burst time.Duration, now time.Time) (tat time.Time, allowed bool, err error)
+}
+
+// Config is the quotas of a group of routes.
+type Config struct {
+	// Group names the routes, so that the requests to other groups do not
+	// count against their quotas.
+	Group string
+	// User is the quota of each authenticated user, and IP that of each
+	// client address making requests without a valid token.
+	User Quota
+	IP   Quota
+}
+
+// Limiter throttles the requests to a group of routes.
+type Limiter struct {
+	backend Backend
+	secret  []byte
+	config  Config
+	now     func() time.Time
+}
+
+// New creates a Limiter keeping its buckets in the backend. Users are told
+// apart by their tokens, signed with the JWT secret.
+func New(backend Backend, secret []byte, config Config) *Limiter {
+	return &Limiter{
+		backend: backend,
+		secret:  secret,
+		config:  config,
+		now:     func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Handler wraps next. Client addresses are taken from RemoteAddr, which
+// must be resolved by realip.Middleware behind reverse proxies.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, quota := l.key(r)
+		now := l.now()
+
+		tat, allowed, err := l.backend.TakeRateLimit(key, quota.interval(), quota.burst(), now)
+		if err != nil {
+			// Throttling is not worth failing requests for.
+			log.Println("rate limit:", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining := max(int(now.Add(quota.burst()).Sub(tat)/quota.interval()), 0)
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(quota.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("RateLimit-Reset", seconds(tat.Sub(now)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", quota.Limit, seconds(quota.Period)))
+
+		if !allowed {
+			header.Set("Retry-After", seconds(tat.Add(quota.interval()).Sub(now.Add(quota.burst()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// key returns the bucket of the request and its quota.
+func (l *Limiter) key(r *http.Request) (string, Quota) {
+	if userID, err := auth.GetUserIDFromToken(l.secret, auth.GetTokenFromRequest(r)); err == nil {
+		return fmt.Sprintf("%s:user:%d", l.config.Group, userID), l.config.User
+	}
+	return fmt.Sprintf("%s:ip:%s", l.config.Group, realip.Host(r)), l.config.IP
+}
+
+// seconds formats d as a whole number of seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 0))
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestLimiter(t *testing.T) {
+	token, err := auth.CreateJWT([]byte("testSecret"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, backend := range map[string]Backend{
+		"memory": NewMemoryBackend(),
+		"store":  store.NewMockStore(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := time.Now().UTC()
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			newHandler := func(group string) http.Handler {
+				l := New(backend, []byte("testSecret"), Config{
+					Group: group,
+					User:  Quota{Limit: 5, Period: time.Minute},
+					IP:    Quota{Limit: 3, Period: time.Minute},
+				})
+				l.now = func() time.Time { return clock }
+				return l.Handler(next)
+			}
+			handler := newHandler("api")
+
+			do := func(handler http.Handler, token string) *httptest.ResponseRecorder {
+				t.Helper()
+				req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+				if token != "" {
+					req.Header.Set("Authorization", token)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				return rr
+			}
+
+			for _, want := range []string{"2", "1", "0"} {
+				rr := do(handler, "")
+				if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != want {
+					t.Fatalf("got %d, remaining %q, want %s", rr.Code, rr.Header().Get("RateLimit-Remaining"), want)
+				}
+			}
+			rr := do(handler, "")
+			if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "20" {
+				t.Errorf("over the quota: got %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+			}
+			if got := rr.Header().Get("RateLimit-Policy"); got != "3;w=60" {
+				t.Errorf("RateLimit-Policy = %q", got)
+			}
+			if got := rr.Header().Get("RateLimit-Reset"); got != "60" {
+				t.Errorf("RateLimit-Reset = %q", got)
+			}
+
+			// Users and other groups have buckets of their own.
+			if rr := do(handler, token); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "5" {
+				t.Errorf("authenticated: got %d, limit %q", rr.Code, rr.Header().Get("RateLimit-Limit"))
+			}
+			if rr := do(newHandler("auth"), ""); rr.Code != http.StatusOK {
+				t.Errorf("other group: got %d", rr.Code)
+			}
+
+			// A request is refilled every 20 seconds.
+			clock = clock.Add(20 * time.Second)
+			if rr := do(handler, ""); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "0" {
+				t.Errorf("after a refill: got %d, remaining %q", rr.Code, rr.Header().Get("RateLimit-Remaining"))
+			}
+		})
+	}
+}
+
+func TestParseQuota(t *testing.T) {
+	q, err := ParseQuota("100/1m")
+	if err != nil || q != (Quota{Limit: 100, Period: time.Minute}) {
+		t.Errorf("got %v, %v", q, err)
+	}
+	for _, s := range []string{"100", "0/1m", "-1/1m", "10/0s", "ten/1m", "10/minute"} {
+		if _, err := ParseQuota(s); err == nil {
+			t.Errorf("ParseQuota(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package realip finds the address of the client of a request made through
+// reverse proxies.
+//
+// The X-Forwarded-For header is only believed as far as it was written by
+// trusted proxies: its addresses are read from the right, the last one
+// appended, and the first address that is not a trusted proxy is the
+// client's. Requests that do not come from a trusted proxy keep their
+// RemoteAddr, since anyone can send the header.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const Header = "X-Forwarded-For"
+
+// ParseProxies parses a list of addresses or CIDR prefixes separated by
+// commas, e.g. "10.0.0.0/8, 192.168.1.1".
+func ParseProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy %q: %v", field, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", field, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Middleware sets the RemoteAddr of the requests made through the trusted
+// proxies to the address of their client, so that the handlers of next can
+// rely on it.
+func Middleware(trusted []netip.Prefix, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := ClientIP(r, trusted); ok && addr.String() != Host(r) {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = net.JoinHostPort(addr.String(), "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the address of the client of the request. It reports
+// false if RemoteAddr is not an IP address.
+func ClientIP(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(Host(r))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	hops := strings.Split(strings.Join(r.Header.Values(Header), ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrusted(addr, trusted); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// The proxy closest to the client wrote no usable address.
+			break
+		}
+		addr = hop.Unmap()
+	}
+	return addr, true
+}
+
+// Host returns the RemoteAddr of the request without its port.
+func Host(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package realip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4321", nil, "203.0.113.7"},
+		{"spoofed header from an untrusted client", "203.0.113.7:4321", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:80", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.1.2.3:80", []string{"198.51.100.1, 203.0.113.7, 192.168.1.1"}, "203.0.113.7"},
+		{"several headers", "10.1.2.3:80", []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"garbage past the trusted proxies", "10.1.2.3:80", []string{"garbage, 10.9.9.9"}, "10.9.9.9"},
+		{"only trusted proxies", "10.1.2.3:80", []string{"192.168.1.1"}, "192.168.1.1"},
+		{"IPv6", "[2001:db8::1]:443", nil, "2001:db8::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwarded {
+				r.Header.Add(Header, v)
+			}
+
+			got, ok := ClientIP(r, trusted)
+			if !ok || got.String() != tc.want {
+				t.Errorf("got %v, %v, want %s", got, ok, tc.want)
+			}
+		})
+	}
+
+	if _, err := ParseProxies("10.0.0.0/8, nonsense"); err == nil {
+		t.Error("ParseProxies accepted an invalid address")
+	}
+}
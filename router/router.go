@@ -0,0 +1,157 @@
+// Package router composes the middlewares of the server with the routes of
+// the services.
+//
+// A Router serves the routes registered on its groups, each a path prefix
+// with the middlewares that its routes pass through:
+//
+//	r := router.New()
+//	r.Use(middleware.RequestID, middleware.Logger)
+//	api := r.Group("/api/v1").Protect(auth.JWTMiddleware(store, secret))
+//	api.HandleFunc("GET /tasks", handleTasks)
+//	api.Public().HandleFunc("POST /auth/login", handleLogin)
+//
+// The routes of a protected group go through its check, and only those
+// registered on its Public group skip it, so a route cannot be left
+// unprotected by forgetting to protect it.
+package router
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Middleware wraps a handler with a concern shared by many routes.
+type Middleware func(http.Handler) http.Handler
+
+// Chain returns a middleware applying the middlewares in order, the first
+// being the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Router serves the routes of its groups, passing every request through
+// the middlewares given to Use.
+type Router struct {
+	mux     *http.ServeMux
+	handler http.Handler
+
+	mu          sync.Mutex
+	middlewares []Middleware
+	routes      []string
+}
+
+func New() *Router {
+	mux := http.NewServeMux()
+	return &Router{mux: mux, handler: mux}
+}
+
+// Use adds middlewares that every request goes through, including those
+// matching no route. It must be called before serving.
+func (r *Router) Use(middlewares ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.handler = Chain(r.middlewares...)(r.mux)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
+
+// Group returns a group of routes under the prefix going through the
+// middlewares.
+func (r *Router) Group(prefix string, middlewares ...Middleware) *Group {
+	return &Group{router: r, prefix: prefix, middlewares: middlewares}
+}
+
+// Routes returns the patterns of the registered routes, with their prefix,
+// in order.
+func (r *Router) Routes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := slices.Clone(r.routes)
+	slices.Sort(routes)
+	return routes
+}
+
+func (r *Router) handle(pattern string, handler http.Handler) {
+	r.mu.Lock()
+	r.routes = append(r.routes, pattern)
+	r.mu.Unlock()
+
+	r.mux.Handle(pattern, handler)
+}
+
+// Group is a set of routes sharing a path prefix and middlewares.
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []Middleware
+	// public is the group without the check of Protect, if any.
+	public *Group
+}
+
+// Group returns a group of routes under the prefix, appended to that of g,
+// going through the middlewares after those of g.
+func (g *Group) Group(prefix string, middlewares ...Middleware) *Group {
+	sub := &Group{
+		router:      g.router,
+		prefix:      g.prefix + prefix,
+		middlewares: append(slices.Clone(g.middlewares), middlewares...),
+	}
+	if g.public != nil {
+		sub.public = g.public.Group(prefix, middlewares...)
+	}
+	return sub
+}
+
+// Protect returns a group of the same prefix whose routes go through
+// check, such as an authentication middleware. The routes that must skip
+// it are registered on its Public group.
+func (g *Group) Protect(check Middleware) *Group {
+	return &Group{
+		router:      g.router,
+		prefix:      g.prefix,
+		middlewares: append(slices.Clone(g.middlewares), check),
+		public:      g,
+	}
+}
+
+// Public returns the group of the same prefix without the check of
+// Protect, or g itself if it is not protected.
+func (g *Group) Public() *Group {
+	if g.public == nil {
+		return g
+	}
+	return g.public
+}
+
+// Prefix returns the path prefix of the routes of the group.
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+// Handle registers the handler for the pattern, written as for
+// http.ServeMux without the prefix of the group, e.g. "GET /tasks/{id}".
+func (g *Group) Handle(pattern string, handler http.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if ok {
+		pattern = method + " " + g.prefix + path
+	} else {
+		pattern = g.prefix + pattern
+	}
+	g.router.handle(pattern, Chain(g.middlewares...)(handler))
+}
+
+func (g *Group) HandleFunc(pattern string, handler http.HandlerFunc) {
+	g.Handle(pattern, handler)
+}
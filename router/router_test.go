@@ -0,0 +1,83 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	// trace appends the name of every middleware a request goes through to
+	// the X-Trace response header.
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Trace", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				http.Error(w, "Permission denied", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := New()
+	r.Use(trace("global"), trace("second"))
+	api := r.Group("/api/v1", trace("api")).Protect(deny)
+	api.HandleFunc("GET /tasks/{id}", ok)
+	api.Public().HandleFunc("POST /auth/login", ok)
+	admin := api.Group("/admin", trace("admin"))
+	admin.HandleFunc("POST /users/{username}/unlock", ok)
+	admin.Public().HandleFunc("GET /status", ok)
+
+	do := func(method, target string, authorized bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		if authorized {
+			req.Header.Set("Authorization", "token")
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		authorized     bool
+		code           int
+		trace          string
+	}{
+		{"GET", "/api/v1/tasks/1", true, http.StatusOK, "global,second,api"},
+		{"GET", "/api/v1/tasks/1", false, http.StatusUnauthorized, "global,second,api"},
+		{"POST", "/api/v1/auth/login", false, http.StatusOK, "global,second,api"},
+		{"POST", "/api/v1/admin/users/johnDoe/unlock", false, http.StatusUnauthorized, "global,second,api"},
+		{"POST", "/api/v1/admin/users/johnDoe/unlock", true, http.StatusOK, "global,second,api,admin"},
+		{"GET", "/api/v1/admin/status", false, http.StatusOK, "global,second,api,admin"},
+		{"GET", "/unknown", false, http.StatusNotFound, "global,second"},
+	} {
+		rr := do(tc.method, tc.target, tc.authorized)
+		trace := strings.Join(rr.Header().Values("X-Trace"), ",")
+		if rr.Code != tc.code || trace != tc.trace {
+			t.Errorf("%s %s (authorized %v): got %d through %q, want %d through %q", tc.method, tc.target, tc.authorized, rr.Code, trace, tc.code, tc.trace)
+		}
+	}
+
+	want := []string{
+		"GET /api/v1/admin/status",
+		"GET /api/v1/tasks/{id}",
+		"POST /api/v1/admin/users/{username}/unlock",
+		"POST /api/v1/auth/login",
+	}
+	if got := r.Routes(); !slices.Equal(got, want) {
+		t.Errorf("Routes() = %q, want %q", got, want)
+	}
+}
@@ -1,39 +1,277 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"sync"
 
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/events"
+	"github.com/hsrvms/todoapp/idempotency"
+	"github.com/hsrvms/todoapp/middleware"
+	"github.com/hsrvms/todoapp/ratelimit"
+	"github.com/hsrvms/todoapp/realip"
+	"github.com/hsrvms/todoapp/router"
 	"github.com/hsrvms/todoapp/services"
 	"github.com/hsrvms/todoapp/store"
 )
 
+// eventBufferSize is the number of recent task events kept for clients
+// resuming a real-time stream.
+const eventBufferSize = 1000
+
 type APIServer struct {
-	addr       string
+	cfg        *config.Config
 	repository store.Store
+	events     *events.Broker
+	source     events.Source
+	// rateLimits keeps the buckets of the rate limiters, shared with the
+	// other instances if sharedRateLimits.
+	rateLimits       ratelimit.Backend
+	sharedRateLimits bool
+
+	mu     sync.Mutex
+	server *http.Server
+	addrs  net.Addr
+	// onShutdown are the functions closing the connections that the server
+	// does not wait for, such as event streams.
+	onShutdown []func()
+	stopJobs   context.CancelFunc
+	jobs       sync.WaitGroup
+	// ready is closed once the server listens, and stopped once it shut
+	// down.
+	ready        chan struct{}
+	stopped      chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
 }
 
-func NewAPIServer(addr string, repository store.Store) *APIServer {
+// NewAPIServer returns a server configured by cfg whose real-time clients
+// receive the task events delivered by the source.
+func NewAPIServer(cfg *config.Config, repository store.Store, source events.Source) *APIServer {
+	rateLimits, shared := rateLimitBackend(cfg.RateLimit.Backend, repository)
 	return &APIServer{
-		addr:       addr,
-		repository: repository,
+		cfg:              cfg,
+		repository:       repository,
+		events:           events.NewBroker(eventBufferSize),
+		source:           source,
+		rateLimits:       rateLimits,
+		sharedRateLimits: shared,
+		ready:            make(chan struct{}),
+		stopped:          make(chan struct{}),
+	}
+}
+
+// Start runs the background jobs and serves the API until the context is
+// cancelled, when it shuts down gracefully within the shutdown timeout, or
+// until Shutdown is called. It returns nil once shut down, and an error if
+// the server could not listen or stopped serving on its own.
+//
+// A server can only be started once.
+func (s *APIServer) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       s.cfg.HTTP.ReadTimeout,
+		WriteTimeout:      s.cfg.HTTP.WriteTimeout,
+		IdleTimeout:       s.cfg.HTTP.IdleTimeout,
+	}
+	for _, f := range s.onShutdown {
+		server.RegisterOnShutdown(f)
+	}
+
+	// The jobs are stopped by Shutdown, once the requests that may rely on
+	// them have completed.
+	jobsCtx, stopJobs := context.WithCancel(context.WithoutCancel(ctx))
+	s.mu.Lock()
+	s.server = server
+	s.addrs = listener.Addr()
+	s.stopJobs = stopJobs
+	s.mu.Unlock()
+	s.startJobs(jobsCtx)
+
+	log.Println("Starting API server on", listener.Addr())
+	close(s.ready)
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err := <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			<-s.stopped
+			return s.shutdownErr
+		}
+		stopJobs()
+		s.jobs.Wait()
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.HTTP.ShutdownTimeout)
+		defer cancel()
+		return s.Shutdown(shutdownCtx)
 	}
 }
 
-func (s *APIServer) Start() {
+// Shutdown stops accepting connections, waits for the in-flight requests to
+// complete, ends the event streams and WebSockets, and stops the background
+// jobs. If the context expires first, the remaining connections are closed
+// and its error is returned. Start returns once Shutdown is done.
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	if server == nil {
+		return errors.New("server was not started")
+	}
+
+	s.shutdownOnce.Do(func() {
+		log.Println("Shutting down API server")
+		err := server.Shutdown(ctx)
+		if err != nil {
+			server.Close()
+		}
+
+		s.stopJobs()
+		jobsDone := make(chan struct{})
+		go func() {
+			s.jobs.Wait()
+			close(jobsDone)
+		}()
+		select {
+		case <-jobsDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		s.shutdownErr = err
+		close(s.stopped)
+	})
+
+	<-s.stopped
+	return s.shutdownErr
+}
+
+// Ready returns a channel closed once Start listens.
+func (s *APIServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Addr returns the address the server listens on, such as the port chosen
+// for ":0", or nil before Start listens.
+func (s *APIServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addrs
+}
+
+// startJobs runs the background jobs until the context is cancelled.
+func (s *APIServer) startJobs(ctx context.Context) {
+	jobs := []func(context.Context){
+		s.listenTaskEvents,
+		func(ctx context.Context) { s.runAutoArchive(ctx, autoArchiveInterval) },
+		func(ctx context.Context) { s.runWebhookDeliveries(ctx, webhookDeliveryInterval) },
+		func(ctx context.Context) { s.runIdempotencyCleanup(ctx, idempotencyCleanupInterval) },
+	}
+	if s.sharedRateLimits {
+		jobs = append(jobs, func(ctx context.Context) { s.runRateLimitCleanup(ctx, rateLimitCleanupInterval) })
+	}
+
+	for _, job := range jobs {
+		s.jobs.Add(1)
+		go func() {
+			defer s.jobs.Done()
+			job(ctx)
+		}()
+	}
+}
+
+// Handler returns the router serving the routes of every service. It does
+// not start the background jobs of Start.
+//
+// Every request goes through the middlewares of the router, outermost
+// first. The routes under /api/v1 are protected by JWT authentication
+// unless the services register them as public, and mutating requests
+// there may carry an Idempotency-Key.
+func (s *APIServer) Handler() *router.Router {
 	const v1Prefix = "/api/v1"
-	userService := services.NewUserService(s.repository)
+	jwtSecret := []byte(s.cfg.JWTSecret)
+	userService := services.NewUserService(s.repository, s.cfg)
 	taskService := services.NewTaskService(s.repository)
+	calendarService := services.NewCalendarService(s.repository)
+	eventService := services.NewEventService(s.repository, s.events)
+	socketService := services.NewSocketService(s.repository, s.events, taskService)
+	apiServices := []services.Service{
+		userService,
+		taskService,
+		services.NewViewService(s.repository),
+		eventService,
+		socketService,
+		services.NewWebhookService(s.repository),
+		services.NewSyncService(s.repository, taskService),
+		services.NewImportService(s.repository, taskService),
+		services.NewExportService(s.repository),
+		calendarService,
+		services.NewOpenAPIService(),
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.onShutdown = append(s.onShutdown, eventService.Shutdown, socketService.Shutdown)
+	s.mu.Unlock()
+
+	r := router.New()
+	r.Use(
+		middleware.RequestID,
+		realIP(s.cfg.TrustedProxies),
+		middleware.Logger,
+		middleware.Recover,
+		middleware.CORS(s.cfg.CORSOrigins),
+		s.rateLimit(v1Prefix),
+		middleware.Compress,
+	)
+
+	root := r.Group("")
+	root.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		w.Write([]byte("health"))
 	})
 
-	userService.RegisterRoutes(mux, v1Prefix)
-	taskService.RegisterRoutes(mux, v1Prefix)
+	idempotent := idempotency.New(s.repository, jwtSecret, s.cfg.IdempotencyKeyTTL)
+	api := r.Group(v1Prefix, idempotent.Handler).Protect(auth.JWTMiddleware(s.repository, jwtSecret))
+	for _, service := range apiServices {
+		service.RegisterRoutes(api)
+	}
+
+	calendarService.RegisterFeedRoutes(root)
+	services.NewCalDAVService(s.repository, taskService).RegisterRoutes(root.Protect(userService.BasicMiddleware))
+	return r
+}
+
+// realIP resolves the client addresses of the requests made through the
+// trusted proxies.
+func realIP(trusted []netip.Prefix) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return realip.Middleware(trusted, next)
+	}
+}
 
-	log.Println("Starting API server on", s.addr)
-	log.Fatal(http.ListenAndServe(s.addr, mux))
+// listenTaskEvents publishes the task events from the source to the broker
+// until the context is cancelled.
+func (s *APIServer) listenTaskEvents(ctx context.Context) {
+	if s.source == nil {
+		return
+	}
+	if err := s.source.Listen(ctx, s.events.Publish); err != nil && ctx.Err() == nil {
+		log.Println("task events listener stopped:", err)
+	}
 }
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/store"
+)
+
+// testConfig is the configuration of the servers in tests.
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.JWTSecret = "testSecret"
+	return cfg
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	cfg := testConfig()
+	cfg.Addr = "127.0.0.1:0"
+	token, err := auth.CreateJWT([]byte(cfg.JWTSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewAPIServer(cfg, store.NewMockStore(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan error, 1)
+	go func() { started <- s.Start(ctx) }()
+
+	select {
+	case <-s.Ready():
+	case err := <-started:
+		t.Fatal(err)
+	}
+	url := "http://" + s.Addr().String()
+	// A spare connection dialled by a keep-alive client but never used
+	// would hold up the shutdown for a few seconds.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	resp, err := client.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /health: %d", resp.StatusCode)
+	}
+
+	// An open event stream must not hold up the shutdown.
+	req, _ := http.NewRequest("GET", url+"/api/v1/events", nil)
+	req.Header.Set("Authorization", token)
+	stream, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/v1/events: %d", stream.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	if _, err := io.ReadAll(stream.Body); err != nil {
+		t.Errorf("event stream not ended cleanly: %v", err)
+	}
+	if _, err := client.Get(url + "/health"); err == nil {
+		t.Error("server still accepting connections")
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/webhooks"
+)
+
+// autoArchiveInterval is how often completed tasks are checked against the
+// users' auto-archive settings.
+const autoArchiveInterval = time.Hour
+
+// webhookDeliveryInterval is how often the webhook delivery queue is polled.
+const webhookDeliveryInterval = 5 * time.Second
+
+// idempotencyCleanupInterval is how often expired idempotency keys are
+// deleted.
+const idempotencyCleanupInterval = time.Hour
+
+// rateLimitCleanupInterval is how often the refilled rate limit buckets
+// shared by the instances are deleted.
+const rateLimitCleanupInterval = 10 * time.Minute
+
+// runAutoArchive archives completed tasks according to each user's
+// auto-archive setting, once immediately and then on every interval, until
+// the context is cancelled.
+func (s *APIServer) runAutoArchive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var tasks []*models.Task
+		err := s.repository.WithTx(ctx, func(tx store.Store) error {
+			var err error
+			tasks, err = tx.ArchiveCompletedTasks()
+			if err != nil {
+				return err
+			}
+			for _, task := range tasks {
+				event := &models.TaskEvent{Type: models.TaskUpdated, UserID: task.UserID, Task: task}
+				if err := tx.NotifyTaskEvent(event); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("auto-archive failed:", err)
+		} else if len(tasks) > 0 {
+			log.Printf("auto-archive: archived %d tasks\n", len(tasks))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWebhookDeliveries sends the queued webhook deliveries that are due on
+// every interval until the context is cancelled.
+func (s *APIServer) runWebhookDeliveries(ctx context.Context, interval time.Duration) {
+	dispatcher := webhooks.NewDispatcher(s.repository)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := dispatcher.DeliverPending(ctx); err != nil && ctx.Err() == nil {
+			log.Println("webhook delivery failed:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runIdempotencyCleanup deletes the expired idempotency keys on every
+// interval until the context is cancelled.
+func (s *APIServer) runIdempotencyCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.repository.DeleteExpiredIdempotencyKeys(time.Now().UTC()); err != nil {
+			log.Println("idempotency key cleanup failed:", err)
+		}
+	}
+}
+
+// runRateLimitCleanup deletes the refilled rate limit buckets on every
+// interval until the context is cancelled.
+func (s *APIServer) runRateLimitCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.repository.DeleteExpiredRateLimits(time.Now().UTC()); err != nil {
+			log.Println("rate limit cleanup failed:", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	handler := NewAPIServer(testConfig(), store.NewMockStore(), nil).Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/v1/openapi.json: %d %s", w.Code, w.Body)
+	}
+
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+
+	registered := map[string]bool{}
+	for _, endpoint := range handler.Routes() {
+		method, path, _ := strings.Cut(endpoint, " ")
+		path, ok := strings.CutPrefix(path, "/api/v1")
+		if !ok {
+			continue
+		}
+		registered[method+" "+path] = true
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("%s is missing from the OpenAPI document", endpoint)
+		}
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is described but not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPITaskSchemas(t *testing.T) {
+	handler := NewAPIServer(testConfig(), store.NewMockStore(), nil).Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Ref   string `json:"$ref"`
+					Items struct {
+						Ref string `json:"$ref"`
+					} `json:"items"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tasks are served as TaskResponse, whatever the route.
+	const taskResponse = "#/components/schemas/TaskResponse"
+	for _, tc := range []struct{ schema, property string }{
+		{"SocketMessage", "task"},
+		{"SyncResult", "task"},
+		{"SyncPullResponse", "tasks"},
+		{"BulkResult", "task"},
+		{"ImportResponse", "tasks"},
+		{"TaskEventResponse", "task"},
+	} {
+		property := doc.Components.Schemas[tc.schema].Properties[tc.property]
+		if property.Ref != taskResponse && property.Items.Ref != taskResponse {
+			t.Errorf("%s.%s does not refer to TaskResponse: %+v", tc.schema, tc.property, property)
+		}
+	}
+	if ref := doc.Components.Schemas["SocketRequest"].Properties["task"].Ref; ref != "#/components/schemas/CreateTaskRequest" {
+		t.Errorf("SocketRequest.task refers to %q", ref)
+	}
+
+	// The ID, owner and creation time are set by the server.
+	for _, schema := range []string{"CreateTaskRequest", "ViewRequest", "WebhookRequest"} {
+		for _, property := range []string{"id", "user_id", "created_at"} {
+			if _, ok := doc.Components.Schemas[schema].Properties[property]; ok {
+				t.Errorf("%s accepts %s", schema, property)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/ratelimit"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+)
+
+// rateLimitGroup is the quotas of the routes under a path prefix.
+type rateLimitGroup struct {
+	prefix string
+	config ratelimit.Config
+}
+
+// rateLimitGroups returns the route groups under the API prefix, the most
+// specific first. The last group is the default one.
+func rateLimitGroups(prefix string, quotas config.RateLimit) []rateLimitGroup {
+	return []rateLimitGroup{
+		{
+			prefix: prefix + "/auth/",
+			config: ratelimit.Config{Group: "auth", User: quotas.Auth, IP: quotas.Auth},
+		},
+		{
+			prefix: "/",
+			config: ratelimit.Config{Group: "api", User: quotas.User, IP: quotas.IP},
+		},
+	}
+}
+
+// rateLimit throttles the requests according to the quotas of their route
+// group. Health checks are not throttled.
+func (s *APIServer) rateLimit(prefix string) router.Middleware {
+	groups := rateLimitGroups(prefix, s.cfg.RateLimit)
+	limiters := make([]*ratelimit.Limiter, len(groups))
+	for i, group := range groups {
+		limiters[i] = ratelimit.New(s.rateLimits, []byte(s.cfg.JWTSecret), group.config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		handlers := make([]http.Handler, len(limiters))
+		for i, limiter := range limiters {
+			handlers[i] = limiter.Handler(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for i, group := range groups {
+				if strings.HasPrefix(r.URL.Path, group.prefix) {
+					handlers[i].ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitBackend returns where the buckets are kept: "memory" counts the
+// requests of each instance, and "postgres" shares the counts of all
+// instances through the repository.
+func rateLimitBackend(name string, repository store.Store) (backend ratelimit.Backend, shared bool) {
+	if name == "postgres" {
+		return repository, true
+	}
+	return ratelimit.NewMemoryBackend(), false
+}
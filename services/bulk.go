@@ -0,0 +1,273 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/utils"
+)
+
+// maxBulkOperations caps the number of operations in a single bulk request.
+const maxBulkOperations = 100
+
+const (
+	bulkModeAtomic  = "atomic"
+	bulkModePartial = "partial"
+)
+
+var ErrNoOperations = errors.New("operations are required")
+var ErrTooManyOperations = fmt.Errorf("at most %d operations are allowed", maxBulkOperations)
+var ErrInvalidBulkMode = fmt.Errorf("mode must be %q or %q", bulkModeAtomic, bulkModePartial)
+
+type bulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+type bulkOperation struct {
+	Op string `json:"op"`
+	ID int64  `json:"id"`
+	// Task is the task to create, or the fields replacing those of the task
+	// to update.
+	Task *CreateTaskRequest `json:"task"`
+}
+
+type bulkResult struct {
+	Index  int           `json:"index"`
+	Op     string        `json:"op"`
+	Status int           `json:"status"`
+	Task   *TaskResponse `json:"task,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Mode    string       `json:"mode"`
+	Results []bulkResult `json:"results"`
+}
+
+// # POST /tasks/bulk:
+//
+// Runs up to 100 create, update, complete and delete operations in one
+// transaction. In "atomic" mode (the default) any failure rolls back the
+// whole batch and the request fails with 422. In "partial" mode every
+// operation succeeds or fails on its own and the request returns 200.
+//
+// Payload:
+//
+//	{
+//	 "mode": "atomic",
+//	 "operations": [
+//	  {"op": "create", "task": {"title": "Learn Golang", "description": ""}},
+//	  {"op": "update", "id": 2, "task": {"title": "Learn Go", "description": ""}},
+//	  {"op": "complete", "id": 3},
+//	  {"op": "delete", "id": 4},
+//	 ],
+//	}
+//
+// Response:
+//
+//	{
+//	 "mode": "atomic",
+//	 "results": [
+//	  {"index": 0, "op": "create", "status": 201, "task": {...}},
+//	  {"index": 1, "op": "update", "status": 200, "task": {...}},
+//	  {"index": 2, "op": "complete", "status": 200, "task": {...}},
+//	  {"index": 3, "op": "delete", "status": 200, "task": {...}},
+//	 ],
+//	}
+func (s *TaskService) handleTaskBulk(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = bulkModeAtomic
+	}
+	if req.Mode != bulkModeAtomic && req.Mode != bulkModePartial {
+		http.Error(w, ErrInvalidBulkMode.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) == 0 {
+		http.Error(w, ErrNoOperations.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > maxBulkOperations {
+		http.Error(w, ErrTooManyOperations.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	atomic := req.Mode == bulkModeAtomic
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	var results []bulkResult
+	var failed bool
+	err := s.store.WithTx(r.Context(), func(tx store.Store) error {
+		// Every result is named up front, since a rolled back batch stops
+		// at the failing operation but reports those after it too.
+		results = make([]bulkResult, len(req.Operations))
+		for i, op := range req.Operations {
+			results[i] = bulkResult{Index: i, Op: op.Op}
+		}
+		failed = false
+
+		for i, op := range req.Operations {
+			var task *models.Task
+			var status int
+			var err error
+			if atomic {
+				task, status, err = s.applyBulkOperation(tx, userID, op)
+				if status == http.StatusInternalServerError {
+					return err
+				}
+			} else {
+				err = tx.WithTx(r.Context(), func(tx store.Store) error {
+					var opErr error
+					task, status, opErr = s.applyBulkOperation(tx, userID, op)
+					return opErr
+				})
+				if errors.Is(err, store.ErrSerializationFailure) {
+					return err
+				}
+			}
+
+			results[i].Status = status
+			if err != nil {
+				results[i].Error = err.Error()
+				if status == http.StatusInternalServerError {
+					results[i].Error = "Error executing operation"
+				}
+				failed = true
+				if atomic {
+					return errBatchRolledBack
+				}
+				continue
+			}
+			results[i].Task = newTaskResponse(task)
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchRolledBack) {
+		http.Error(w, "Error executing operations", http.StatusInternalServerError)
+		return
+	}
+
+	writeBulkResponse(w, req.Mode, results, failed && atomic)
+}
+
+// errBatchRolledBack aborts the transaction of an atomic batch after one of
+// its operations failed.
+var errBatchRolledBack = errors.New("batch rolled back")
+
+// applyBulkOperation validates op and applies it through tx on behalf of
+// the user. It returns the resulting task along with the per-item status
+// code. The tasks of other users are reported as not found.
+func (s *TaskService) applyBulkOperation(tx store.Store, userID int64, op bulkOperation) (*models.Task, int, error) {
+	id := strconv.FormatInt(op.ID, 10)
+
+	switch op.Op {
+	case "create":
+		if op.Task == nil {
+			return nil, http.StatusBadRequest, errors.New("task is required")
+		}
+		task := op.Task.task()
+		if err := validateTaskPayload(task); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		task.UserID = userID
+		if err := s.applyTransition(nil, task); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		createdTask, err := tx.CreateTask(task)
+		if err == nil {
+			err = notifyTaskEvent(tx, models.TaskCreated, createdTask)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return createdTask, http.StatusCreated, nil
+
+	case "update", "complete":
+		if op.ID <= 0 {
+			return nil, http.StatusBadRequest, errors.New("id is required")
+		}
+		existingTask, err := tx.GetTaskByID(id, userID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if existingTask == nil {
+			return nil, http.StatusNotFound, ErrTaskNotFound
+		}
+
+		var task models.Task
+		if op.Op == "complete" {
+			task = *existingTask
+			task.Status = models.StatusDone
+		} else {
+			if op.Task == nil {
+				return nil, http.StatusBadRequest, errors.New("task is required")
+			}
+			task = *op.Task.task()
+			if err := validateTaskPayload(&task); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+		}
+		if err := s.applyTransition(existingTask, &task); err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				return nil, http.StatusUnprocessableEntity, err
+			}
+			return nil, http.StatusBadRequest, err
+		}
+		updatedTask, err := tx.UpdateTask(id, userID, &task)
+		if err == nil {
+			err = notifyTaskEvent(tx, models.TaskUpdated, updatedTask)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return updatedTask, http.StatusOK, nil
+
+	case "delete":
+		if op.ID <= 0 {
+			return nil, http.StatusBadRequest, errors.New("id is required")
+		}
+		deletedTask, err := tx.DeleteTask(id, userID)
+		if err == nil {
+			err = notifyTaskEvent(tx, models.TaskDeleted, deletedTask)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if deletedTask == nil {
+			return nil, http.StatusNotFound, ErrTaskNotFound
+		}
+		return deletedTask, http.StatusOK, nil
+	}
+
+	return nil, http.StatusBadRequest, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// writeBulkResponse writes the per-item results. When the atomic batch was
+// rolled back, the operations that did not fail themselves are reported as
+// not executed.
+func writeBulkResponse(w http.ResponseWriter, mode string, results []bulkResult, rolledBack bool) {
+	if !rolledBack {
+		utils.WriteJSON(w, http.StatusOK, bulkResponse{Mode: mode, Results: results})
+		return
+	}
+
+	for i := range results {
+		if results[i].Error == "" {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Task = nil
+			results[i].Error = "not executed: batch rolled back"
+		}
+	}
+	utils.WriteJSON(w, http.StatusUnprocessableEntity, bulkResponse{Mode: mode, Results: results})
+}
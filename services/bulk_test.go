@@ -0,0 +1,157 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestTaskBulk(t *testing.T) {
+	token := testToken(t)
+
+	bulk := func(st store.Store, body string) (int, bulkResponse) {
+		t.Helper()
+		mux := newTestRouter(st, NewTaskService(st))
+		req := httptest.NewRequest("POST", "/tasks/bulk", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var resp bulkResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+	statuses := func(resp bulkResponse) string {
+		var s []string
+		for _, r := range resp.Results {
+			s = append(s, fmt.Sprint(r.Status))
+		}
+		return strings.Join(s, ",")
+	}
+	countTasks := func(st store.Store) int {
+		t.Helper()
+		tasks, err := st.GetAllTasks(store.TaskFilter{UserID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(tasks)
+	}
+
+	const failing = `{"mode": %q, "operations": [
+		{"op": "create", "task": {"title": "Learn Golang"}},
+		{"op": "complete", "id": 42}
+	]}`
+
+	// In atomic mode, a failure rolls back the operations before it.
+	st := store.NewMockStore()
+	code, resp := bulk(st, fmt.Sprintf(failing, bulkModeAtomic))
+	if code != http.StatusUnprocessableEntity || statuses(resp) != "424,404" {
+		t.Errorf("atomic: got %d %s", code, statuses(resp))
+	}
+	if n := countTasks(st); n != 0 {
+		t.Errorf("atomic: %d tasks created, want 0", n)
+	}
+
+	// The operations after the failing one are reported as rolled back too.
+	st = store.NewMockStore()
+	code, resp = bulk(st, `{"operations": [
+		{"op": "create", "task": {"title": "Learn Golang"}},
+		{"op": "complete", "id": 42},
+		{"op": "create", "task": {"title": "Call the bank"}},
+		{"op": "delete", "id": 9}
+	]}`)
+	var named []string
+	for _, r := range resp.Results {
+		named = append(named, fmt.Sprintf("%d:%s", r.Index, r.Op))
+	}
+	if code != http.StatusUnprocessableEntity || statuses(resp) != "424,404,424,424" ||
+		strings.Join(named, ",") != "0:create,1:complete,2:create,3:delete" {
+		t.Errorf("atomic, failing in the middle: got %d %s %v", code, statuses(resp), named)
+	}
+	if n := countTasks(st); n != 0 {
+		t.Errorf("atomic, failing in the middle: %d tasks created, want 0", n)
+	}
+
+	// In partial mode, the other operations still apply.
+	st = store.NewMockStore()
+	code, resp = bulk(st, fmt.Sprintf(failing, bulkModePartial))
+	if code != http.StatusOK || statuses(resp) != "201,404" {
+		t.Errorf("partial: got %d %s", code, statuses(resp))
+	}
+	if n := countTasks(st); n != 1 {
+		t.Errorf("partial: %d tasks created, want 1", n)
+	}
+
+	code, resp = bulk(st, `{"operations": [
+		{"op": "update", "id": 1, "task": {"title": "Learn Go"}},
+		{"op": "complete", "id": 1},
+		{"op": "delete", "id": 1}
+	]}`)
+	if code != http.StatusOK || statuses(resp) != "200,200,200" || resp.Mode != bulkModeAtomic {
+		t.Errorf("atomic success: got %d %s in mode %q", code, statuses(resp), resp.Mode)
+	}
+
+	ops := strings.Repeat(`{"op": "complete", "id": 1},`, maxBulkOperations+1)
+	if code, _ := bulk(st, `{"operations": [`+strings.TrimSuffix(ops, ",")+`]}`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many operations: got %d", code)
+	}
+}
+
+func TestTaskBulkOtherUsersTasks(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	other := otherUserTask(t, st)
+	mux := newTestRouter(st, NewTaskService(st))
+
+	for _, op := range []string{
+		`{"op": "update", "id": %d, "task": {"title": "Hijacked"}}`,
+		`{"op": "complete", "id": %d}`,
+		`{"op": "delete", "id": %d}`,
+	} {
+		body := `{"mode": "partial", "operations": [` + fmt.Sprintf(op, other.ID) + `]}`
+		req := httptest.NewRequest("POST", "/tasks/bulk", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var resp bulkResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if len(resp.Results) != 1 || resp.Results[0].Status != http.StatusNotFound {
+			t.Errorf("%s: got %d %s", op, rr.Code, rr.Body)
+		}
+	}
+
+	assertTaskUnchanged(t, st, other)
+}
+
+// otherUserTask creates a task of a user other than that of testToken.
+func otherUserTask(t *testing.T, st store.Store) *models.Task {
+	t.Helper()
+	user, err := st.CreateUser(&models.User{Username: "janeDoe", Password: "secretPassword"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := st.CreateTask(&models.Task{UserID: user.ID, Title: "Call the bank", Status: models.StatusTodo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// assertTaskUnchanged fails unless the task is stored as it was.
+func assertTaskUnchanged(t *testing.T, st store.Store, want *models.Task) {
+	t.Helper()
+	tasks, err := st.GetAllTasks(store.TaskFilter{UserID: want.UserID, IncludeArchived: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Title != want.Title || tasks[0].Status != want.Status || tasks[0].ArchivedAt != nil {
+		t.Errorf("task of another user changed: got %+v", tasks)
+	}
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/ical"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/validate"
+)
+
+const (
+	caldavRoot       = "/caldav/"
+	caldavCollection = "/caldav/tasks/"
+	// maxCalDAVBody caps the size of PUT and REPORT bodies.
+	maxCalDAVBody = 1 << 20
+)
+
+var ErrResourceExists = errors.New("resource already exists")
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// CalDAVService serves the caller's active tasks as a CalDAV (RFC 4791)
+// calendar collection of VTODOs, for calendar apps to sync two-way.
+type CalDAVService struct {
+	store store.Store
+	tasks *TaskService
+}
+
+// NewCalDAVService creates a CalDAVService whose changes go through the
+// validation of tasks.
+func NewCalDAVService(store store.Store, tasks *TaskService) *CalDAVService {
+	return &CalDAVService{store: store, tasks: tasks}
+}
+
+// caldavItem is a task served as a calendar resource.
+type caldavItem struct {
+	task *models.Task
+	name string
+	uid  string
+}
+
+func (it *caldavItem) href() string {
+	return caldavCollection + it.name
+}
+
+// etag changes whenever the task or its UID does.
+func (it *caldavItem) etag() string {
+	data, _ := json.Marshal(it.task)
+	sum := sha256.Sum256(append(data, it.uid...))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// CalDAV clients authenticate with HTTP Basic authentication using the
+// account's username and password, and find the collection from the
+// server URL alone (e.g. https://todo.example.com/).
+//
+// # PROPFIND /caldav/:
+//
+// The principal, which is also the calendar home.
+//
+// # PROPFIND /caldav/tasks/:
+//
+// The collection of the caller's active tasks, listing them with Depth: 1.
+// Its getctag changes whenever a task does.
+//
+// # REPORT /caldav/tasks/:
+//
+// calendar-query returns every task and calendar-multiget the tasks of the
+// given hrefs, with their etags and calendar data. Filters are ignored.
+//
+// # GET /caldav/tasks/{name}.ics:
+//
+// A calendar holding the task's VTODO. Tasks created over the API are
+// named "{id}.ics".
+//
+// # PUT /caldav/tasks/{name}.ics:
+//
+// Creates (201) or replaces (204) the task from the VTODO of the body.
+// SUMMARY, DESCRIPTION, DUE, PRIORITY, STATUS and COMPLETED are kept; the
+// status must follow the workflow (422). If-Match and If-None-Match are
+// honored.
+//
+// # DELETE /caldav/tasks/{name}.ics:
+//
+// Deletes the task.
+func (s *CalDAVService) RegisterRoutes(dav *router.Group) {
+	dav.Public().HandleFunc("/.well-known/caldav", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, caldavRoot, http.StatusMovedPermanently)
+	})
+	dav.Public().HandleFunc("OPTIONS /caldav/", s.handleCalDAVOptions)
+	dav.HandleFunc("PROPFIND /caldav/{$}", s.handleCalDAVPropfindRoot)
+	dav.HandleFunc("PROPFIND /caldav/tasks/{$}", s.handleCalDAVPropfindCollection)
+	dav.HandleFunc("PROPFIND /caldav/tasks/{name}", s.handleCalDAVPropfindItem)
+	dav.HandleFunc("REPORT /caldav/tasks/{$}", s.handleCalDAVReport)
+	dav.HandleFunc("GET /caldav/tasks/{name}", s.handleCalDAVGet)
+	dav.HandleFunc("PUT /caldav/tasks/{name}", s.handleCalDAVPut)
+	dav.HandleFunc("DELETE /caldav/tasks/{name}", s.handleCalDAVDelete)
+}
+
+func (s *CalDAVService) handleCalDAVOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, calendar-access")
+	w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *CalDAVService) handleCalDAVPropfindRoot(w http.ResponseWriter, r *http.Request) {
+	ms := &multistatus{}
+	ms.add(caldavRoot, `<d:resourcetype><d:collection/></d:resourcetype>`+
+		`<d:current-user-principal><d:href>`+caldavRoot+`</d:href></d:current-user-principal>`+
+		`<d:principal-URL><d:href>`+caldavRoot+`</d:href></d:principal-URL>`+
+		`<c:calendar-home-set><d:href>`+caldavRoot+`</d:href></c:calendar-home-set>`)
+
+	if r.Header.Get("Depth") == "1" {
+		items, ok := s.getItems(w, r)
+		if !ok {
+			return
+		}
+		ms.add(caldavCollection, collectionProps(items))
+	}
+
+	ms.write(w)
+}
+
+func (s *CalDAVService) handleCalDAVPropfindCollection(w http.ResponseWriter, r *http.Request) {
+	items, ok := s.getItems(w, r)
+	if !ok {
+		return
+	}
+
+	ms := &multistatus{}
+	ms.add(caldavCollection, collectionProps(items))
+	if r.Header.Get("Depth") == "1" {
+		for _, it := range items {
+			ms.add(it.href(), itemProps(it, false))
+		}
+	}
+
+	ms.write(w)
+}
+
+func (s *CalDAVService) handleCalDAVPropfindItem(w http.ResponseWriter, r *http.Request) {
+	it, ok := s.getItem(w, r)
+	if !ok {
+		return
+	}
+
+	ms := &multistatus{}
+	ms.add(it.href(), itemProps(it, false))
+	ms.write(w)
+}
+
+func (s *CalDAVService) handleCalDAVReport(w http.ResponseWriter, r *http.Request) {
+	report, hrefs, err := parseReport(io.LimitReader(r.Body, maxCalDAVBody))
+	if err != nil {
+		http.Error(w, "Invalid REPORT body", http.StatusBadRequest)
+		return
+	}
+
+	if report != "calendar-query" && report != "calendar-multiget" {
+		http.Error(w, "Unsupported report", http.StatusForbidden)
+		return
+	}
+
+	items, ok := s.getItems(w, r)
+	if !ok {
+		return
+	}
+
+	ms := &multistatus{}
+	if report == "calendar-query" {
+		for _, it := range items {
+			ms.add(it.href(), itemProps(it, true))
+		}
+		ms.write(w)
+		return
+	}
+
+	byHref := make(map[string]*caldavItem, len(items))
+	for _, it := range items {
+		byHref[it.href()] = it
+	}
+	for _, href := range hrefs {
+		if it, ok := byHref[caldavCollection+path.Base(href)]; ok {
+			ms.add(it.href(), itemProps(it, true))
+		} else {
+			ms.addStatus(href, http.StatusNotFound)
+		}
+	}
+	ms.write(w)
+}
+
+func (s *CalDAVService) handleCalDAVGet(w http.ResponseWriter, r *http.Request) {
+	it, ok := s.getItem(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", it.etag())
+	w.Write(it.calendar())
+}
+
+func (s *CalDAVService) handleCalDAVPut(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	todo, err := ical.ParseTodo(http.MaxBytesReader(w, r.Body, maxCalDAVBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if !errors.As(err, &tooLarge) {
+			err = fmt.Errorf("%w: invalid calendar data: %v", validate.ErrInvalidPayload, err)
+		}
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	task := todo.Task
+	if err := validateTaskPayload(&task); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	task.UserID = userID
+
+	var created bool
+	var saved *caldavItem
+	err = s.store.WithTx(r.Context(), func(tx store.Store) error {
+		existing, err := findCalDAVItem(tx, userID, name)
+		if err != nil {
+			return err
+		}
+		if err := checkPreconditions(r, existing); err != nil {
+			return err
+		}
+
+		created = existing == nil
+		completedAt := task.CompletedAt
+		if created {
+			err = s.tasks.applyTransition(nil, &task)
+		} else {
+			if task.Status == "" {
+				task.Status = existing.task.Status
+			}
+			err = s.tasks.applyTransition(existing.task, &task)
+		}
+		if err != nil {
+			return err
+		}
+		if task.CompletedAt != nil && completedAt != nil {
+			task.CompletedAt = completedAt
+		}
+
+		var result *models.Task
+		eventType := models.TaskCreated
+		if created {
+			result, err = tx.CreateTask(&task)
+		} else {
+			eventType = models.TaskUpdated
+			result, err = tx.UpdateTask(strconv.FormatInt(existing.task.ID, 10), userID, &task)
+		}
+		if err != nil {
+			return err
+		}
+
+		uid := todo.UID
+		if uid == "" {
+			uid = ical.UID(result.ID)
+		}
+		saved = &caldavItem{task: result, name: name, uid: uid}
+		if created || existing.uid != uid {
+			res := &models.CalDAVResource{TaskID: result.ID, UserID: userID, Name: name, UID: uid}
+			if err := tx.SaveCalDAVResource(res); err != nil {
+				return err
+			}
+		}
+
+		return notifyTaskEvent(tx, eventType, result)
+	})
+	switch {
+	case errors.Is(err, ErrPreconditionFailed):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, ErrInvalidStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Error saving task", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", saved.etag())
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *CalDAVService) handleCalDAVDelete(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	name := r.PathValue("name")
+
+	_, err := s.tasks.mutate(r.Context(), models.TaskDeleted, func(tx store.Store) (*models.Task, error) {
+		existing, err := findCalDAVItem(tx, userID, name)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return nil, ErrTaskNotFound
+		}
+		if err := checkPreconditions(r, existing); err != nil {
+			return nil, err
+		}
+
+		return tx.DeleteTask(strconv.FormatInt(existing.task.ID, 10), userID)
+	})
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrPreconditionFailed):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	case err != nil:
+		http.Error(w, "Error deleting task", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getItems loads the caller's items. It writes the error response and
+// reports false when it fails.
+func (s *CalDAVService) getItems(w http.ResponseWriter, r *http.Request) ([]*caldavItem, bool) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	items, err := loadCalDAVItems(s.store, userID)
+	if err != nil {
+		http.Error(w, "Error retrieving tasks", http.StatusInternalServerError)
+		return nil, false
+	}
+	return items, true
+}
+
+// getItem loads the caller's item named by the request path. It writes the
+// error response and reports false when it fails.
+func (s *CalDAVService) getItem(w http.ResponseWriter, r *http.Request) (*caldavItem, bool) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	it, err := findCalDAVItem(s.store, userID, r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "Error retrieving task", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if it == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return it, true
+}
+
+func loadCalDAVItems(st store.Store, userID int64) ([]*caldavItem, error) {
+	tasks, err := st.GetAllTasks(store.TaskFilter{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	resources, err := st.GetCalDAVResources(userID)
+	if err != nil {
+		return nil, err
+	}
+	byTask := make(map[int64]*models.CalDAVResource, len(resources))
+	for _, res := range resources {
+		byTask[res.TaskID] = res
+	}
+
+	items := make([]*caldavItem, len(tasks))
+	for i, task := range tasks {
+		items[i] = &caldavItem{task: task, name: fmt.Sprintf("%d.ics", task.ID), uid: ical.UID(task.ID)}
+		if res, ok := byTask[task.ID]; ok {
+			items[i].name, items[i].uid = res.Name, res.UID
+		}
+	}
+	return items, nil
+}
+
+// findCalDAVItem returns the user's item of the given name, or nil.
+func findCalDAVItem(st store.Store, userID int64, name string) (*caldavItem, error) {
+	items, err := loadCalDAVItems(st, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		if it.name == name {
+			return it, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPreconditions applies the If-Match and If-None-Match headers to the
+// existing item, nil when there is none.
+func checkPreconditions(r *http.Request, existing *caldavItem) error {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if existing == nil || (match != "*" && match != existing.etag()) {
+			return ErrPreconditionFailed
+		}
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && existing != nil {
+		if noneMatch == "*" || noneMatch == existing.etag() {
+			return fmt.Errorf("%w: %w", ErrPreconditionFailed, ErrResourceExists)
+		}
+	}
+	return nil
+}
+
+func (it *caldavItem) calendar() []byte {
+	var b bytes.Buffer
+	cal := ical.NewWriter(&b, "Tasks", time.Now())
+	cal.WriteTodo(it.task, it.uid)
+	cal.Close()
+	return b.Bytes()
+}
+
+func collectionProps(items []*caldavItem) string {
+	ctag := sha256.New()
+	for _, it := range items {
+		io.WriteString(ctag, it.name+it.etag())
+	}
+
+	return `<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>` +
+		`<d:displayname>Tasks</d:displayname>` +
+		`<d:current-user-principal><d:href>` + caldavRoot + `</d:href></d:current-user-principal>` +
+		`<d:current-user-privilege-set><d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege></d:current-user-privilege-set>` +
+		`<c:supported-calendar-component-set><c:comp name="VTODO"/></c:supported-calendar-component-set>` +
+		`<cs:getctag>` + hex.EncodeToString(ctag.Sum(nil)[:16]) + `</cs:getctag>`
+}
+
+func itemProps(it *caldavItem, withData bool) string {
+	props := `<d:resourcetype/>` +
+		`<d:getcontenttype>text/calendar; charset=utf-8; component=VTODO</d:getcontenttype>` +
+		`<d:getetag>` + xmlEscape(it.etag()) + `</d:getetag>`
+	if withData {
+		props += `<c:calendar-data>` + xmlEscape(string(it.calendar())) + `</c:calendar-data>`
+	}
+	return props
+}
+
+// multistatus builds a WebDAV 207 Multi-Status response.
+type multistatus struct {
+	b strings.Builder
+}
+
+func (ms *multistatus) add(href, props string) {
+	fmt.Fprintf(&ms.b, `<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`,
+		xmlEscape(href), props)
+}
+
+func (ms *multistatus) addStatus(href string, status int) {
+	fmt.Fprintf(&ms.b, `<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>`,
+		xmlEscape(href), status, http.StatusText(status))
+}
+
+func (ms *multistatus) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+	io.WriteString(w, ms.b.String())
+	io.WriteString(w, `</d:multistatus>`)
+}
+
+// parseReport returns the name of the report requested by the body and the
+// hrefs it lists.
+func parseReport(r io.Reader) (string, []string, error) {
+	dec := xml.NewDecoder(r)
+	var report string
+	var hrefs []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if report == "" {
+			report = start.Name.Local
+			continue
+		}
+		if start.Name.Space == "DAV:" && start.Name.Local == "href" {
+			var href string
+			if err := dec.DecodeElement(&href, &start); err != nil {
+				return "", nil, err
+			}
+			hrefs = append(hrefs, strings.TrimSpace(href))
+		}
+	}
+
+	return report, hrefs, nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+)
+
+const testVTodo = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTODO\r\nUID:4b1c@example.com\r\nSUMMARY:Call the bank\r\nDUE:20240430T170000Z\r\nPRIORITY:1\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+
+func TestCalDAV(t *testing.T) {
+	st := store.NewMockStore()
+	hashed, _ := hashPassword("secretPassword")
+	user, _ := st.CreateUser(&models.User{Username: "johnDoe", Password: hashed})
+	st.CreateTask(&models.Task{UserID: user.ID, Title: "Learn Golang", Status: models.StatusTodo})
+
+	mux := router.New()
+	NewCalDAVService(st, NewTaskService(st)).RegisterRoutes(mux.Group("").Protect(NewUserService(st, testConfig()).BasicMiddleware))
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth("johnDoe", "secretPassword")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req := httptest.NewRequest("PROPFIND", "/caldav/tasks/", nil)
+	req.SetBasicAuth("johnDoe", "wrongPassword")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("wrong password: got %d", rr.Code)
+	}
+
+	rr = do("PROPFIND", "/caldav/tasks/", "", map[string]string{"Depth": "1"})
+	if rr.Code != http.StatusMultiStatus || !strings.Contains(rr.Body.String(), "<d:href>/caldav/tasks/1.ics</d:href>") {
+		t.Fatalf("PROPFIND: got %d %s", rr.Code, rr.Body)
+	}
+
+	rr = do("PUT", "/caldav/tasks/4b1c.ics", testVTodo, map[string]string{"If-None-Match": "*"})
+	if rr.Code != http.StatusCreated || rr.Header().Get("ETag") == "" {
+		t.Fatalf("PUT new: got %d %s", rr.Code, rr.Body)
+	}
+	etag := rr.Header().Get("ETag")
+
+	rr = do("GET", "/caldav/tasks/4b1c.ics", "", nil)
+	if rr.Header().Get("ETag") != etag || !strings.Contains(rr.Body.String(), "UID:4b1c@example.com\r\n") {
+		t.Errorf("GET: got %s %s", rr.Header().Get("ETag"), rr.Body)
+	}
+
+	done := strings.Replace(testVTodo, "PRIORITY:1", "STATUS:COMPLETED\r\nCOMPLETED:20240414T090000Z", 1)
+	if rr = do("PUT", "/caldav/tasks/4b1c.ics", done, map[string]string{"If-Match": `"stale"`}); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale etag: got %d", rr.Code)
+	}
+	if rr = do("PUT", "/caldav/tasks/4b1c.ics", done, map[string]string{"If-Match": etag}); rr.Code != http.StatusNoContent {
+		t.Fatalf("PUT update: got %d %s", rr.Code, rr.Body)
+	}
+	tasks, _ := st.GetAllTasks(store.TaskFilter{UserID: user.ID})
+	if len(tasks) != 2 || tasks[1].Status != models.StatusDone || tasks[1].Priority != "" || !tasks[1].CompletedAt.Equal(time.Date(2024, 4, 14, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("after update: got %+v", tasks[1])
+	}
+
+	multiget := `<?xml version="1.0"?><c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+		`<d:prop><d:getetag/><c:calendar-data/></d:prop><d:href>/caldav/tasks/4b1c.ics</d:href><d:href>/caldav/tasks/missing.ics</d:href></c:calendar-multiget>`
+	rr = do("REPORT", "/caldav/tasks/", multiget, nil)
+	if body := rr.Body.String(); rr.Code != http.StatusMultiStatus || !strings.Contains(body, "STATUS:COMPLETED") || !strings.Contains(body, "404 Not Found") || strings.Contains(body, "Learn Golang") {
+		t.Errorf("REPORT: got %d %s", rr.Code, body)
+	}
+
+	if rr = do("DELETE", "/caldav/tasks/4b1c.ics", "", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got %d", rr.Code)
+	}
+	if rr = do("GET", "/caldav/tasks/4b1c.ics", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: got %d", rr.Code)
+	}
+}
+
+func TestCalDAVLockout(t *testing.T) {
+	st := store.NewMockStore()
+	hashed, _ := hashPassword("secretPassword")
+	st.CreateUser(&models.User{Username: "johnDoe", Password: hashed})
+
+	users := NewUserService(st, testConfig())
+	mux := router.New()
+	users.RegisterRoutes(mux.Group(""))
+	NewCalDAVService(st, NewTaskService(st)).RegisterRoutes(mux.Group("").Protect(users.BasicMiddleware))
+
+	propfind := func(username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("PROPFIND", "/caldav/tasks/", nil)
+		req.SetBasicAuth(username, password)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Unknown usernames fail like wrong passwords.
+	unknown := propfind("nobody", "secretPassword")
+	wrong := propfind("johnDoe", "wrongPassword")
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != unknown.Code || wrong.Body.String() != unknown.Body.String() {
+		t.Errorf("unknown user: %d %q, wrong password: %d %q", unknown.Code, unknown.Body, wrong.Code, wrong.Body)
+	}
+
+	for i := 1; i < userLockoutPolicy.Threshold; i++ {
+		propfind("johnDoe", "wrongPassword")
+	}
+	rr := propfind("johnDoe", "secretPassword")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("locked out: got %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	// The lockout also applies to logging in.
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "johnDoe", "password": "secretPassword"}`))
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("login after CalDAV lockout: got %d", rr.Code)
+	}
+}
+
+func TestCalendarFeed(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	due := time.Date(2024, 4, 30, 17, 0, 0, 0, time.UTC)
+	st.CreateTask(&models.Task{UserID: 1, Title: "Call the bank", Status: models.StatusTodo, DueAt: &due})
+	st.CreateTask(&models.Task{UserID: 1, Title: "Someday", Status: models.StatusTodo})
+
+	mux := newTestRouter(st, NewCalendarService(st))
+	NewCalendarService(st).RegisterFeedRoutes(mux.Group(""))
+
+	auth := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/calendar/token", nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	feed := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	var resp calendarTokenResponse
+	if err := json.Unmarshal(auth("POST").Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	path := "/ical/" + resp.Token + ".ics"
+	if !strings.HasSuffix(resp.URL, path) {
+		t.Errorf("got url %q", resp.URL)
+	}
+
+	rr := feed(path)
+	if body := rr.Body.String(); rr.Code != http.StatusOK || !strings.Contains(body, "SUMMARY:Call the bank") || strings.Contains(body, "Someday") {
+		t.Errorf("feed: got %d %s", rr.Code, body)
+	}
+
+	auth("DELETE")
+	if rr := feed(path); rr.Code != http.StatusNotFound {
+		t.Errorf("revoked feed: got %d", rr.Code)
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/ical"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/utils"
+)
+
+type CalendarService struct {
+	store store.Store
+}
+
+func NewCalendarService(store store.Store) *CalendarService {
+	return &CalendarService{store: store}
+}
+
+type calendarTokenResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// The calendar feed is an iCalendar file of the caller's active tasks that
+// have a due date, for calendar apps to subscribe to. Its URL holds a
+// secret token instead of requiring a login, so anyone with the URL can
+// read the feed until the token is rotated or revoked. Only a hash of the
+// token is stored: the URL is shown when the token is created.
+//
+// # POST /calendar/token:
+//
+// Creates the feed token, replacing the previous one.
+//
+// Response:
+//
+//	{
+//	 "token": "8c0b5e4bcb0a2f5c0a6f6c8bd0f5e0b1a9f8a4d9c2e1f0b7a6c5d4e3f2a1b0c9",
+//	 "url": "https://todo.example.com/ical/8c0b5e4bcb0a2f5c0a6f6c8bd0f5e0b1a9f8a4d9c2e1f0b7a6c5d4e3f2a1b0c9.ics",
+//	}
+//
+// # DELETE /calendar/token:
+//
+// Revokes the feed token. Response: 204 No Content.
+//
+// # GET /ical/{token}.ics:
+//
+// The feed, served outside of the API prefix. Task UIDs match the ones
+// served over CalDAV.
+func (s *CalendarService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("POST /calendar/token", s.handleCalendarTokenCreate)
+	api.HandleFunc("DELETE /calendar/token", s.handleCalendarTokenDelete)
+}
+
+// RegisterFeedRoutes registers GET /ical/{file}, which calendar apps
+// subscribe to outside the API prefix.
+func (s *CalendarService) RegisterFeedRoutes(root *router.Group) {
+	root.HandleFunc("GET /ical/{file}", s.handleCalendarFeed)
+}
+
+func (s *CalendarService) handleCalendarTokenCreate(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Error creating calendar token", http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	if err := s.store.SetCalendarToken(userID, hashCalendarToken(token)); err != nil {
+		http.Error(w, "Error creating calendar token", http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	utils.WriteJSON(w, http.StatusCreated, calendarTokenResponse{
+		Token: token,
+		URL:   scheme + "://" + r.Host + "/ical/" + token + ".ics",
+	})
+}
+
+func (s *CalendarService) handleCalendarTokenDelete(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	if err := s.store.SetCalendarToken(userID, ""); err != nil {
+		http.Error(w, "Error revoking calendar token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *CalendarService) handleCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutSuffix(r.PathValue("file"), ".ics")
+	if !ok || token == "" {
+		http.Error(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := s.store.GetUserByCalendarToken(hashCalendarToken(token))
+	if err != nil {
+		http.Error(w, "Error retrieving calendar", http.StatusInternalServerError)
+		return
+	}
+
+	if user == nil {
+		http.Error(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+
+	resources, err := s.store.GetCalDAVResources(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving calendar", http.StatusInternalServerError)
+		return
+	}
+	uids := make(map[int64]string, len(resources))
+	for _, res := range resources {
+		uids[res.TaskID] = res.UID
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	cal := ical.NewWriter(w, "Tasks", time.Now())
+	err = s.store.EachTask(store.TaskFilter{UserID: user.ID}, func(task *models.Task) error {
+		if task.DueAt == nil {
+			return nil
+		}
+		uid, ok := uids[task.ID]
+		if !ok {
+			uid = ical.UID(task.ID)
+		}
+		return cal.WriteTodo(task, uid)
+	})
+	if err == nil {
+		err = cal.Close()
+	}
+	if err != nil {
+		log.Println("calendar feed failed:", err)
+	}
+}
+
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
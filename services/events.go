@@ -0,0 +1,175 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/events"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+)
+
+// sseHeartbeatInterval is how often a comment is sent on an idle stream to
+// keep proxies from closing it.
+const sseHeartbeatInterval = 15 * time.Second
+
+// sseRetry is the reconnection delay suggested to clients, in milliseconds.
+const sseRetry = 3000
+
+// TaskEventResponse is a task event as served by the API.
+type TaskEventResponse struct {
+	ID        int64                `json:"id"`
+	Type      models.TaskEventType `json:"type"`
+	UserID    int64                `json:"user_id"`
+	Task      *TaskResponse        `json:"task"`
+	CreatedAt time.Time            `json:"created_at"`
+	Partial   bool                 `json:"partial,omitempty"`
+}
+
+func newTaskEventResponse(e *models.TaskEvent) *TaskEventResponse {
+	return &TaskEventResponse{
+		ID:        e.ID,
+		Type:      e.Type,
+		UserID:    e.UserID,
+		Task:      newTaskResponse(e.Task),
+		CreatedAt: e.CreatedAt,
+		Partial:   e.Partial,
+	}
+}
+
+// encodeTaskEvent encodes the event as served by GET /events, and as
+// queued for webhooks.
+func encodeTaskEvent(e *models.TaskEvent) ([]byte, error) {
+	return json.Marshal(newTaskEventResponse(e))
+}
+
+type EventService struct {
+	store  store.Store
+	broker *events.Broker
+
+	// shutdown is closed by Shutdown to end the streams.
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+func NewEventService(store store.Store, broker *events.Broker) *EventService {
+	return &EventService{store: store, broker: broker, shutdown: make(chan struct{})}
+}
+
+// Shutdown ends the open streams, which would otherwise keep the server
+// from shutting down. Clients reconnect to another instance and resume.
+func (s *EventService) Shutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// # GET /events:
+//
+// Streams the caller's task changes as Server-Sent Events. Browsers can
+// pass the token as ?token= since EventSource cannot set headers.
+//
+//	id: 42
+//	event: task.updated
+//	data: {"id":42,"type":"task.updated","user_id":1,"task":{...},"created_at":"..."}
+//
+// Clients resume with the Last-Event-ID header (or ?lastEventId=). When
+// the missed events are no longer buffered, a "reset" event is sent first
+// and the client should reload its tasks. Slow clients are disconnected
+// and can resume the same way.
+func (s *EventService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("GET /events", s.handleEvents)
+}
+
+func (s *EventService) handleEvents(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	var after int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		after = id
+	}
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the server's write timeout.
+	rc.SetWriteDeadline(time.Time{})
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	sub, replay, complete := s.broker.Subscribe(userID, after)
+	defer s.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if !complete {
+		writeSSE(w, &models.TaskEvent{Type: models.TasksReset})
+	}
+	for _, e := range replay {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		log.Println("event stream does not support flushing:", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e *models.TaskEvent) error {
+	// A reset has no ID, so that the client resumes from its last event
+	// and finds out whether it missed anything.
+	if e.Type == models.TasksReset {
+		_, err := fmt.Fprintf(w, "event: %s\ndata: {}\n\n", e.Type)
+		return err
+	}
+
+	data, err := encodeTaskEvent(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
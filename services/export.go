@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/ical"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/todotxt"
+)
+
+const exportFormatICS = "ics"
+
+var ErrInvalidExportFormat = fmt.Errorf("format must be %q, %q, %q or %q", importFormatCSV, importFormatJSON, importFormatTodoTxt, exportFormatICS)
+
+// exportColumns is the header of CSV exports. POST /import reads the task
+// fields back and ignores the others.
+var exportColumns = []string{"id", "title", "description", "status", "priority", "due_at", "completed_at", "created_at", "archived_at"}
+
+type ExportService struct {
+	store store.Store
+}
+
+func NewExportService(store store.Store) *ExportService {
+	return &ExportService{store: store}
+}
+
+// taskEncoder writes tasks in an export format.
+type taskEncoder interface {
+	Encode(task *models.Task) error
+	Close() error
+}
+
+// # GET /export?format=csv&archived=true:
+//
+// Streams the caller's tasks as a file download, in ID order. format is
+// "csv", "json", "todotxt" or "ics". Both active and archived tasks are
+// exported unless archived is given, which then selects one or the other
+// like for GET /tasks.
+//
+//   - csv: a header row and one row per task, with RFC 3339 times.
+//   - json: an array of tasks as returned by GET /tasks.
+//   - todotxt: one line per task; see package todotxt. Descriptions are
+//     not exported.
+//   - ics: an iCalendar file with a VTODO per task; see package ical.
+//
+// The csv, json and todotxt exports can be imported with POST /import.
+func (s *ExportService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("GET /export", s.handleExport)
+}
+
+func (s *ExportService) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	filter := store.TaskFilter{UserID: userID, IncludeArchived: true}
+	if archived := r.URL.Query().Get("archived"); archived != "" {
+		value, err := strconv.ParseBool(archived)
+		if err != nil {
+			http.Error(w, "Invalid archived parameter", http.StatusBadRequest)
+			return
+		}
+		filter.IncludeArchived = false
+		filter.Archived = value
+	}
+
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case importFormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	case importFormatJSON:
+		contentType = "application/json"
+	case importFormatTodoTxt:
+		contentType = "text/plain; charset=utf-8"
+	case exportFormatICS:
+		contentType = "text/calendar; charset=utf-8"
+	default:
+		http.Error(w, ErrInvalidExportFormat.Error(), http.StatusBadRequest)
+		return
+	}
+
+	extension := format
+	if format == importFormatTodoTxt {
+		extension = "txt"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasks.%s"`, extension))
+
+	enc := newTaskEncoder(w, format)
+	err := s.store.EachTask(filter, enc.Encode)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		// The response has started, so the client is left with a
+		// truncated file.
+		log.Println("export failed:", err)
+	}
+}
+
+func newTaskEncoder(w io.Writer, format string) taskEncoder {
+	switch format {
+	case importFormatCSV:
+		return &csvTaskEncoder{w: csv.NewWriter(w)}
+	case importFormatJSON:
+		return &jsonTaskEncoder{w: bufio.NewWriter(w)}
+	case importFormatTodoTxt:
+		return &todoTxtTaskEncoder{w: bufio.NewWriter(w)}
+	}
+	return &icsTaskEncoder{w: ical.NewWriter(w, "Tasks", time.Now())}
+}
+
+type csvTaskEncoder struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (e *csvTaskEncoder) Encode(task *models.Task) error {
+	if !e.headerWritten {
+		e.headerWritten = true
+		e.w.Write(exportColumns)
+	}
+	return e.w.Write([]string{
+		strconv.FormatInt(task.ID, 10),
+		task.Title,
+		task.Description,
+		string(task.Status),
+		task.Priority,
+		formatExportTime(task.DueAt),
+		formatExportTime(task.CompletedAt),
+		formatExportTime(&task.CreatedAt),
+		formatExportTime(task.ArchivedAt),
+	})
+}
+
+func (e *csvTaskEncoder) Close() error {
+	if !e.headerWritten {
+		e.headerWritten = true
+		e.w.Write(exportColumns)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type jsonTaskEncoder struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (e *jsonTaskEncoder) Encode(task *models.Task) error {
+	data, err := json.Marshal(newTaskResponse(task))
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if e.count == 0 {
+		separator = "[\n"
+	}
+	e.count++
+	e.w.WriteString(separator)
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonTaskEncoder) Close() error {
+	if e.count == 0 {
+		e.w.WriteString("[")
+	}
+	e.w.WriteString("]\n")
+	return e.w.Flush()
+}
+
+type todoTxtTaskEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *todoTxtTaskEncoder) Encode(task *models.Task) error {
+	_, err := e.w.WriteString(todotxt.Format(task) + "\n")
+	return err
+}
+
+func (e *todoTxtTaskEncoder) Close() error {
+	return e.w.Flush()
+}
+
+type icsTaskEncoder struct {
+	w *ical.Writer
+}
+
+func (e *icsTaskEncoder) Encode(task *models.Task) error {
+	return e.w.WriteTask(task)
+}
+
+func (e *icsTaskEncoder) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestExport(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	due := time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
+	st.CreateTask(&models.Task{UserID: 1, Title: "Learn Golang", Description: "Tour, of Go", Status: models.StatusTodo, Priority: "A", DueAt: &due})
+	st.CreateTask(&models.Task{UserID: 1, Title: "Call the bank", Status: models.StatusDone})
+	st.CreateTask(&models.Task{UserID: 2, Title: "Not mine", Status: models.StatusTodo})
+	st.ArchiveTask("2", 1)
+
+	mux := newTestRouter(st, NewExportService(st), NewImportService(st, NewTaskService(st)))
+
+	export := func(query string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/export?"+query, nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET /export?%s: got %d: %s", query, rr.Code, rr.Body)
+		}
+		return rr.Body.String()
+	}
+
+	csvFile := export("format=csv")
+	if lines := strings.Split(strings.TrimSpace(csvFile), "\n"); len(lines) != 3 || !strings.Contains(lines[1], `"Tour, of Go",todo,A,2024-04-30T00:00:00Z`) {
+		t.Errorf("csv: got\n%s", csvFile)
+	}
+	if active := export("format=todotxt&archived=false"); !strings.HasPrefix(active, "(A) ") || strings.Count(active, "\n") != 1 {
+		t.Errorf("todotxt: got\n%s", active)
+	}
+	if ics := export("format=ics"); strings.Count(ics, "BEGIN:VTODO") != 2 || strings.Contains(ics, "Not mine") {
+		t.Errorf("ics: got\n%s", ics)
+	}
+	if archived := export("format=json&archived=true"); !strings.Contains(archived, "Call the bank") || strings.Contains(archived, "Learn Golang") {
+		t.Errorf("json: got\n%s", archived)
+	}
+
+	// A JSON export can be imported back.
+	req := httptest.NewRequest("POST", "/import?format=json", strings.NewReader(export("format=json")))
+	req.Header.Set("Authorization", token)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("import of the export: got %d: %s", rr.Code, rr.Body)
+	}
+}
@@ -1,29 +1,81 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/realip"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/types"
+	"github.com/hsrvms/todoapp/utils"
+	"github.com/hsrvms/todoapp/validate"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func generateEndpoint(method, prefix, path string) string {
-	return fmt.Sprintf("%v %v%v", method, prefix, path)
+// Service is implemented by the services, which register their routes on
+// a protected group of the router, and the routes that need no token on
+// its Public group.
+type Service interface {
+	RegisterRoutes(api *router.Group)
 }
 
-func decodeJSON(r *http.Request, v any) error {
-	fmt.Println(r.Body)
-	body, err := io.ReadAll(r.Body)
+// maxRequestBytes caps the JSON bodies of requests.
+const maxRequestBytes = 1 << 20
+
+// decodeJSON decodes the JSON body of the request into v, rejecting unknown
+// fields and bodies over maxRequestBytes.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
 	if err != nil {
 		return err
 	}
 	defer r.Body.Close()
-	return json.Unmarshal(body, v)
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		// encoding/json has no error type for unknown fields, so the field is
+		// read from the message, which has been the same since Go 1.10.
+		// TestDecodeJSONUnknownField catches a change.
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field, _ = strconv.Unquote(field)
+			v := validate.New()
+			v.Add(field, fmt.Errorf("%w %q", validate.ErrUnknownField, field))
+			return v.Err()
+		}
+		return fmt.Errorf("%w: %v", validate.ErrInvalidPayload, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("%w: unexpected data after the JSON value", validate.ErrInvalidPayload)
+	}
+	return nil
+}
+
+// writeInvalidPayload answers 400 to a payload that could not be decoded or
+// failed validation, listing the failure of every field as JSON, or 413 to
+// a body over its size limit.
+func writeInvalidPayload(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.WriteJSON(w, http.StatusRequestEntityTooLarge, types.ErrorResponse{Error: fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit)})
+		return
+	}
+
+	var errs validate.Errors
+	if errors.As(err, &errs) {
+		utils.WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: errs.Error(), Fields: errs})
+		return
+	}
+	utils.WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: err.Error()})
 }
 
 func hashPassword(password string) (string, error) {
@@ -31,15 +83,28 @@ func hashPassword(password string) (string, error) {
 	return string(hashed), err
 }
 
+// dummyPasswordHash returns the hash that passwords of unknown users are
+// checked against.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword("not the password of any user")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hash
+})
+
 func checkPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	log.Println(err)
-	return err == nil
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// clientIP returns the address of the client of the request, without the
+// port. Behind reverse proxies, the server resolves it with realip.
+func clientIP(r *http.Request) string {
+	return realip.Host(r)
 }
 
-func createAndSetAuthCookie(id int64, w http.ResponseWriter) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	token, err := auth.CreateJWT([]byte(secret), id)
+func createAndSetAuthCookie(secret []byte, id int64, w http.ResponseWriter) (string, error) {
+	token, err := auth.CreateJWT(secret, id)
 	if err != nil {
 		return "", err
 	}
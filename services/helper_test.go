@@ -0,0 +1,164 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/types"
+	"github.com/hsrvms/todoapp/validate"
+)
+
+// testConfig is the configuration of the services in tests.
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.JWTSecret = "testSecret"
+	return cfg
+}
+
+// newTestRouter serves the routes of the services without a prefix,
+// protected by JWT authentication as under /api/v1.
+func newTestRouter(st store.Store, services ...Service) *router.Router {
+	r := router.New()
+	api := r.Group("").Protect(auth.JWTMiddleware(st, []byte(testConfig().JWTSecret)))
+	for _, service := range services {
+		service.RegisterRoutes(api)
+	}
+	return r
+}
+
+// testToken returns a token for the user of store.NewMockStore.
+func testToken(t *testing.T) string {
+	t.Helper()
+	token, err := auth.CreateJWT([]byte(testConfig().JWTSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInvalidPayload(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewTaskService(st), NewUserService(st, testConfig()))
+
+	post := func(target, body string) (int, types.ErrorResponse) {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var resp types.ErrorResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+	fields := func(resp types.ErrorResponse) []string {
+		var names []string
+		for _, f := range resp.Fields {
+			names = append(names, f.Field)
+		}
+		return names
+	}
+
+	long := strings.Repeat("é", maxTitleLength+1)
+	code, resp := post("/tasks", `{"title": "  ", "description": "`+long+`", "priority": "AB"}`)
+	if code != http.StatusBadRequest || strings.Join(fields(resp), ",") != "title,description,priority" {
+		t.Errorf("invalid task: got %d %+v", code, resp)
+	}
+	if resp.Error != "title is required; description must be at most 255 characters; priority must be a letter from A to Z" {
+		t.Errorf("invalid task: got message %q", resp.Error)
+	}
+
+	code, resp = post("/tasks", `{"title": "Learn Golang", "done": true}`)
+	if code != http.StatusBadRequest || strings.Join(fields(resp), ",") != "done" {
+		t.Errorf("unknown field: got %d %+v", code, resp)
+	}
+
+	// Fields set by the server are not part of the request.
+	code, resp = post("/tasks", `{"id": 7, "title": "Learn Golang"}`)
+	if code != http.StatusBadRequest || strings.Join(fields(resp), ",") != "id" {
+		t.Errorf("server field: got %d %+v", code, resp)
+	}
+
+	code, resp = post("/auth/register", `{"username": "jo hn", "password": "short"}`)
+	if code != http.StatusBadRequest || strings.Join(fields(resp), ",") != "username,password" {
+		t.Errorf("invalid user: got %d %+v", code, resp)
+	}
+
+	// Titles are trimmed and stored composed.
+	code, _ = post("/tasks", `{"title": " Cafe\u0301 "}`)
+	if code != http.StatusCreated {
+		t.Fatalf("valid task: got %d", code)
+	}
+	task, _ := st.GetTaskByID("1", 1)
+	if task.Title != "Caf\u00e9" {
+		t.Errorf("title = %q, want %q", task.Title, "Caf\u00e9")
+	}
+}
+
+func TestDecodeJSONUnknownField(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"title": "Learn Golang", "colour": "red"}`))
+	var v struct {
+		Title string `json:"title"`
+	}
+	err := decodeJSON(httptest.NewRecorder(), req, &v)
+	if !errors.Is(err, validate.ErrUnknownField) || err.Error() != `unknown field "colour"` {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestInvalidPayloadResponses(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	hashed, _ := hashPassword("secretPassword")
+	st.CreateUser(&models.User{Username: "johnDoe", Password: hashed})
+	tasks := NewTaskService(st)
+	mux := newTestRouter(st, tasks, NewImportService(st, tasks))
+	NewCalDAVService(st, tasks).RegisterRoutes(mux.Group("").Protect(NewUserService(st, testConfig()).BasicMiddleware))
+
+	const untitled = "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:4b1c@example.com\r\nSUMMARY: \r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+		fields               string
+	}{
+		{"POST", "/tasks", `{"title": "` + strings.Repeat("a", maxRequestBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{"POST", "/tasks", `{"title": `, http.StatusBadRequest, ""},
+		{"POST", "/import?format=json", `{"title": `, http.StatusBadRequest, ""},
+		{"POST", "/import?format=xml", `<tasks/>`, http.StatusBadRequest, ""},
+		{"POST", "/import?format=todotxt", strings.Repeat("a", maxImportSize+1), http.StatusRequestEntityTooLarge, ""},
+		{"PUT", "/caldav/tasks/4b1c.ics", "not a calendar", http.StatusBadRequest, ""},
+		{"PUT", "/caldav/tasks/4b1c.ics", untitled, http.StatusBadRequest, "title"},
+		{"PUT", "/caldav/tasks/4b1c.ics", "BEGIN:VCALENDAR\r\n" + strings.Repeat("X-PAD:a\r\n", maxCalDAVBody/9), http.StatusRequestEntityTooLarge, ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", token)
+		if strings.HasPrefix(tc.target, "/caldav/") {
+			req.SetBasicAuth("johnDoe", "secretPassword")
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var resp types.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+			t.Errorf("%s %s: got %d %.100s, want a JSON error", tc.method, tc.target, rr.Code, rr.Body)
+			continue
+		}
+		var fields []string
+		for _, f := range resp.Fields {
+			fields = append(fields, f.Field)
+		}
+		if rr.Code != tc.code || strings.Join(fields, ",") != tc.fields {
+			t.Errorf("%s %s: got %d %+v, want %d with fields %q", tc.method, tc.target, rr.Code, resp, tc.code, tc.fields)
+		}
+	}
+}
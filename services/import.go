@@ -0,0 +1,391 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/todotxt"
+	"github.com/hsrvms/todoapp/utils"
+)
+
+const (
+	// maxImportSize caps the size of an imported file.
+	maxImportSize = 10 << 20
+	// maxImportTasks caps the number of tasks imported at once.
+	maxImportTasks = 5000
+)
+
+const (
+	importFormatCSV     = "csv"
+	importFormatJSON    = "json"
+	importFormatTodoTxt = "todotxt"
+)
+
+var ErrInvalidImportFormat = fmt.Errorf("format must be %q, %q or %q", importFormatCSV, importFormatJSON, importFormatTodoTxt)
+var ErrTooManyImportTasks = fmt.Errorf("at most %d tasks can be imported at once", maxImportTasks)
+var ErrImportTitleColumn = errors.New("a column must be mapped to title")
+var ErrInvalidColumnMapping = errors.New("columns must be a list of header:field pairs")
+var ErrInvalidDate = errors.New("dates must be RFC 3339 or YYYY-MM-DD")
+
+// importFields are the task fields a CSV column can be mapped to.
+var importFields = []string{"title", "description", "status", "priority", "due_at", "completed_at"}
+
+type ImportService struct {
+	store store.Store
+	tasks *TaskService
+}
+
+// NewImportService creates an ImportService whose tasks go through the
+// validation of tasks.
+func NewImportService(store store.Store, tasks *TaskService) *ImportService {
+	return &ImportService{store: store, tasks: tasks}
+}
+
+type importRecord struct {
+	line int
+	task *models.Task
+	err  error
+}
+
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type importResponse struct {
+	DryRun bool            `json:"dry_run"`
+	Tasks  []*TaskResponse `json:"tasks"`
+	Errors []importError   `json:"errors"`
+}
+
+// # POST /import?format=csv&dry_run=true:
+//
+// Creates the tasks of the file sent as the request body, all in one
+// transaction: if any record is invalid nothing is created and the request
+// fails with 422. With dry_run=true nothing is created either way, and the
+// response lists the tasks that would be.
+//
+// format is "csv", "json" or "todotxt", and defaults from the Content-Type
+// (text/csv, application/json or text/plain).
+//
+//   - csv: the first row is the header. Columns named like a task field
+//     ("title", "description", "status", "priority", "due_at",
+//     "completed_at") are imported, others are ignored. Other names are
+//     mapped with ?columns=Name:title,Notes:description.
+//   - json: an array of tasks as returned by GET /tasks. IDs, owners and
+//     creation times are not imported.
+//   - todotxt: one task per line; see package todotxt. Projects and
+//     contexts stay in the title.
+//
+// Dates are RFC 3339 or YYYY-MM-DD. "line" is the line of the record in
+// the file, or its position in the array for json.
+//
+// Response:
+//
+//	{
+//	 "dry_run": false,
+//	 "tasks": [{"id": 12, "title": "Call the bank +Finances @phone", "priority": "A", ...}],
+//	 "errors": [],
+//	}
+//
+// or, with status 422:
+//
+//	{
+//	 "dry_run": false,
+//	 "tasks": [],
+//	 "errors": [{"line": 4, "error": "title is required"}],
+//	}
+func (s *ImportService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("POST /import", s.handleImport)
+}
+
+func (s *ImportService) handleImport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormatFromContentType(r.Header.Get("Content-Type"))
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	var records []importRecord
+	switch format {
+	case importFormatCSV:
+		var columns map[string]string
+		columns, err = parseColumnMapping(r.URL.Query().Get("columns"))
+		if err == nil {
+			records, err = parseCSVImport(body, columns)
+		}
+	case importFormatJSON:
+		records, err = parseJSONImport(body)
+	case importFormatTodoTxt:
+		records, err = parseTodoTxtImport(body)
+	default:
+		err = ErrInvalidImportFormat
+	}
+	if err == nil && len(records) > maxImportTasks {
+		err = ErrTooManyImportTasks
+	}
+	if err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	resp := importResponse{DryRun: dryRun, Tasks: []*TaskResponse{}, Errors: []importError{}}
+	var tasks []*models.Task
+	for _, record := range records {
+		if record.err == nil {
+			record.task.UserID = userID
+			record.err = s.prepareTask(record.task)
+		}
+		if record.err != nil {
+			resp.Errors = append(resp.Errors, importError{Line: record.line, Error: record.err.Error()})
+			continue
+		}
+		tasks = append(tasks, record.task)
+	}
+
+	if dryRun {
+		resp.Tasks = newTaskResponses(tasks)
+		utils.WriteJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if len(resp.Errors) > 0 {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, resp)
+		return
+	}
+
+	err = s.store.WithTx(r.Context(), func(tx store.Store) error {
+		for i, task := range tasks {
+			createdTask, err := tx.CreateTask(task)
+			if err != nil {
+				return err
+			}
+			if err := notifyTaskEvent(tx, models.TaskCreated, createdTask); err != nil {
+				return err
+			}
+			tasks[i] = createdTask
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Error importing tasks", http.StatusInternalServerError)
+		return
+	}
+
+	resp.Tasks = newTaskResponses(tasks)
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// prepareTask validates an imported task and resolves its status like a
+// task created through the API, keeping the imported completion time.
+func (s *ImportService) prepareTask(task *models.Task) error {
+	if err := validateTaskPayload(task); err != nil {
+		return err
+	}
+
+	completedAt := task.CompletedAt
+	if err := s.tasks.applyTransition(nil, task); err != nil {
+		return err
+	}
+	if task.CompletedAt != nil && completedAt != nil {
+		task.CompletedAt = completedAt
+	}
+
+	return nil
+}
+
+func importFormatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return importFormatCSV
+	case "application/json":
+		return importFormatJSON
+	case "text/plain":
+		return importFormatTodoTxt
+	}
+	return ""
+}
+
+// parseColumnMapping parses "Name:title,Notes:description" into a map of
+// lower-cased CSV headers to task fields.
+func parseColumnMapping(value string) (map[string]string, error) {
+	columns := map[string]string{}
+	if value == "" {
+		return columns, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		header, field, ok := strings.Cut(pair, ":")
+		header = strings.ToLower(strings.TrimSpace(header))
+		field = strings.TrimSpace(field)
+		if !ok || header == "" || !isImportField(field) {
+			return nil, ErrInvalidColumnMapping
+		}
+		columns[header] = field
+	}
+
+	return columns, nil
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCSVImport(body []byte, columns map[string]string) ([]importRecord, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	// fields holds the task field of each column, or "" to ignore it.
+	fields := make([]string, len(header))
+	hasTitle := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field, ok := columns[name]; ok {
+			fields[i] = field
+		} else if isImportField(name) {
+			fields[i] = name
+		}
+		hasTitle = hasTitle || fields[i] == "title"
+	}
+	if !hasTitle {
+		return nil, ErrImportTitleColumn
+	}
+
+	var records []importRecord
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				records = append(records, importRecord{line: parseErr.StartLine, err: parseErr.Err})
+				continue
+			}
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		task := &models.Task{}
+		for i, value := range row {
+			if i >= len(fields) || fields[i] == "" {
+				continue
+			}
+			if err = setImportField(task, fields[i], strings.TrimSpace(value)); err != nil {
+				break
+			}
+		}
+		records = append(records, importRecord{line: line, task: task, err: err})
+	}
+
+	return records, nil
+}
+
+func setImportField(task *models.Task, field, value string) error {
+	switch field {
+	case "title":
+		task.Title = value
+	case "description":
+		task.Description = value
+	case "status":
+		task.Status = models.TaskStatus(strings.ToLower(value))
+	case "priority":
+		task.Priority = strings.ToUpper(value)
+	case "due_at", "completed_at":
+		if value == "" {
+			return nil
+		}
+		t, err := parseImportDate(value)
+		if err != nil {
+			return err
+		}
+		if field == "due_at" {
+			task.DueAt = &t
+		} else {
+			task.CompletedAt = &t
+		}
+	}
+	return nil
+}
+
+func parseImportDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, ErrInvalidDate
+}
+
+func parseJSONImport(body []byte) ([]importRecord, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	records := make([]importRecord, len(items))
+	for i, item := range items {
+		var task models.Task
+		err := json.Unmarshal(item, &task)
+		task.ID, task.UserID, task.CreatedAt, task.ArchivedAt = 0, 0, time.Time{}, nil
+		records[i] = importRecord{line: i + 1, task: &task, err: err}
+	}
+
+	return records, nil
+}
+
+func parseTodoTxtImport(body []byte) ([]importRecord, error) {
+	var records []importRecord
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxImportSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		task, err := todotxt.Parse(text)
+		records = append(records, importRecord{line: line, task: task, err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
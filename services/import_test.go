@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestImport(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewImportService(st, NewTaskService(st)))
+
+	do := func(target, contentType, body string, expCode int) importResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != expCode {
+			t.Fatalf("POST %s: got %d want %d: %s", target, rr.Code, expCode, rr.Body)
+		}
+		var resp importResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	count := func() int {
+		tasks, _ := st.GetAllTasks(store.TaskFilter{UserID: 1})
+		return len(tasks)
+	}
+
+	csvFile := "Name,Notes,Priority,Due\nLearn Golang,Tour of Go,b,2024-04-30\n,Missing title,,\nCall the bank,,,tomorrow\n"
+	resp := do("/import?format=csv&dry_run=true&columns=Name:title,Notes:description,Due:due_at", "text/plain", csvFile, http.StatusOK)
+	if len(resp.Tasks) != 1 || resp.Tasks[0].Priority != "B" || resp.Tasks[0].DueAt == nil || resp.Tasks[0].Description != "Tour of Go" {
+		t.Errorf("dry run: got tasks %+v", resp.Tasks)
+	}
+	if len(resp.Errors) != 2 || resp.Errors[0].Line != 3 || resp.Errors[1].Line != 4 {
+		t.Errorf("dry run: got errors %+v", resp.Errors)
+	}
+
+	do("/import?format=csv&columns=Name:title", "", csvFile, http.StatusUnprocessableEntity)
+	if n := count(); n != 0 {
+		t.Fatalf("failed import created %d tasks", n)
+	}
+
+	todo := "(A) Call the bank +Finances @phone due:2024-04-15\n\nx 2024-04-14 Learn Golang\n"
+	resp = do("/import", "text/plain; charset=utf-8", todo, http.StatusCreated)
+	if len(resp.Tasks) != 2 || resp.Tasks[0].ID == 0 || resp.Tasks[1].Status != models.StatusDone || resp.Tasks[1].CompletedAt.Year() != 2024 {
+		t.Errorf("todo.txt: got %+v", resp.Tasks)
+	}
+
+	export, _ := json.Marshal(resp.Tasks)
+	resp = do("/import?format=json", "", string(export), http.StatusCreated)
+	if len(resp.Tasks) != 2 || resp.Tasks[0].ID != 3 || resp.Tasks[0].Title != "Call the bank +Finances @phone" {
+		t.Errorf("json: got %+v", resp.Tasks)
+	}
+	if n := count(); n != 4 {
+		t.Errorf("got %d tasks want 4", n)
+	}
+}
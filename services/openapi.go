@@ -0,0 +1,364 @@
+package services
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/openapi"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/types"
+	"github.com/hsrvms/todoapp/utils"
+)
+
+const apiVersion = "1.0.0"
+
+type OpenAPIService struct {
+	doc *openapi.Document
+}
+
+func NewOpenAPIService() *OpenAPIService {
+	return &OpenAPIService{}
+}
+
+// # GET /openapi.json:
+//
+// The OpenAPI 3.1 document of the routes under the prefix, generated from
+// apiRoutes. Every route registered under the prefix must be described
+// there.
+func (s *OpenAPIService) RegisterRoutes(api *router.Group) {
+	s.doc = newOpenAPIDocument(api.Prefix())
+	api.Public().HandleFunc("GET /openapi.json", s.handleSpec)
+}
+
+func (s *OpenAPIService) handleSpec(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, s.doc)
+}
+
+// apiRoute describes a route for the OpenAPI document.
+type apiRoute struct {
+	summary string
+	tag     string
+	// public routes are served without a token.
+	public bool
+	query  []*openapi.Parameter
+
+	// request is a value of the type of the JSON request body, if any.
+	request any
+	// requestTypes lists the media types of a request body that is not
+	// JSON-encoded.
+	requestTypes []string
+
+	// status is the status code of a successful response, and response a
+	// value of the type of its JSON body, if any.
+	status   int
+	response any
+	// responseTypes lists the media types of a successful response that is
+	// not JSON-encoded.
+	responseTypes []string
+	// others maps the other status codes answered with a JSON body to a
+	// value of its type.
+	others map[int]any
+	// messages are values of the types of the JSON messages exchanged over
+	// a WebSocket, whose schemas are added to the components.
+	messages []any
+}
+
+func queryParam(name, typ, description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: typ}}
+}
+
+// apiRoutes describes the routes of the services by their endpoint without
+// the prefix.
+var apiRoutes = map[string]apiRoute{
+	"POST /auth/register": {
+		summary: "Create an account", tag: "users", public: true,
+		request: CredentialsRequest{}, status: http.StatusCreated, response: "",
+	},
+	"POST /auth/login": {
+		summary: "Log in, returning the session token", tag: "users", public: true,
+		request: CredentialsRequest{}, status: http.StatusOK, response: "",
+	},
+	"GET /users/me": {
+		summary: "Get the caller's account", tag: "users",
+		status: http.StatusOK, response: UserResponse{},
+	},
+	"GET /users/me/settings": {
+		summary: "Get the caller's settings", tag: "users",
+		status: http.StatusOK, response: models.UserSettings{},
+	},
+	"PUT /users/me/settings": {
+		summary: "Update the caller's settings", tag: "users",
+		request: models.UserSettings{}, status: http.StatusOK, response: models.UserSettings{},
+	},
+
+	"POST /admin/users/{username}/unlock": {
+		summary: "Lift the login lockout of a username", tag: "admin",
+		status: http.StatusNoContent,
+	},
+	"POST /admin/ips/{ip}/unlock": {
+		summary: "Lift the login lockout of a client address", tag: "admin",
+		status: http.StatusNoContent,
+	},
+
+	"POST /tasks": {
+		summary: "Create a task", tag: "tasks",
+		request: CreateTaskRequest{}, status: http.StatusCreated, response: TaskResponse{},
+	},
+	"GET /tasks": {
+		summary: "List the caller's tasks", tag: "tasks",
+		query:  []*openapi.Parameter{queryParam("archived", "boolean", "list archived tasks instead of active ones")},
+		status: http.StatusOK, response: []*TaskResponse{},
+	},
+	"GET /tasks/search": {
+		summary: "Search the caller's tasks", tag: "tasks",
+		query: []*openapi.Parameter{
+			queryParam("q", "string", "search query"),
+			queryParam("archived", "boolean", "search archived tasks instead of active ones"),
+			queryParam("limit", "integer", "maximum number of results"),
+		},
+		status: http.StatusOK, response: []*TaskSearchResultResponse{},
+	},
+	"GET /tasks/{id}": {
+		summary: "Get a task", tag: "tasks",
+		status: http.StatusOK, response: TaskResponse{},
+	},
+	"PUT /tasks/{id}": {
+		summary: "Replace a task", tag: "tasks",
+		request: UpdateTaskRequest{}, status: http.StatusOK, response: TaskResponse{},
+	},
+	"DELETE /tasks/{id}": {
+		summary: "Delete a task", tag: "tasks",
+		status: http.StatusOK, response: TaskResponse{},
+	},
+	"POST /tasks/{id}/archive": {
+		summary: "Archive a task", tag: "tasks",
+		status: http.StatusOK, response: TaskResponse{},
+	},
+	"POST /tasks/{id}/unarchive": {
+		summary: "Unarchive a task", tag: "tasks",
+		status: http.StatusOK, response: TaskResponse{},
+	},
+	"POST /tasks/bulk": {
+		summary: "Apply several task operations at once", tag: "tasks",
+		request: bulkRequest{}, status: http.StatusOK, response: bulkResponse{},
+		others: map[int]any{http.StatusUnprocessableEntity: bulkResponse{}},
+	},
+	"GET /workflow": {
+		summary: "Get the task workflow", tag: "tasks",
+		status: http.StatusOK, response: models.Workflow{},
+	},
+
+	"POST /views": {
+		summary: "Save a view", tag: "views",
+		request: ViewRequest{}, status: http.StatusCreated, response: ViewResponse{},
+	},
+	"GET /views": {
+		summary: "List the caller's views", tag: "views",
+		status: http.StatusOK, response: []*ViewResponse{},
+	},
+	"GET /views/{id}": {
+		summary: "Get a view", tag: "views",
+		status: http.StatusOK, response: ViewResponse{},
+	},
+	"PUT /views/{id}": {
+		summary: "Update a view", tag: "views",
+		request: ViewRequest{}, status: http.StatusOK, response: ViewResponse{},
+	},
+	"DELETE /views/{id}": {
+		summary: "Delete a view", tag: "views",
+		status: http.StatusOK, response: ViewResponse{},
+	},
+	"GET /views/{id}/tasks": {
+		summary: "List the tasks matching a view", tag: "views",
+		status: http.StatusOK, response: []*TaskResponse{},
+	},
+
+	"GET /events": {
+		summary: "Stream task events as Server-Sent Events", tag: "events",
+		query:  []*openapi.Parameter{queryParam("lastEventId", "string", "ID of the last event received")},
+		status: http.StatusOK, responseTypes: []string{"text/event-stream"},
+	},
+	"GET /ws": {
+		summary: "Open a WebSocket for task events and mutations", tag: "events",
+		status:   http.StatusSwitchingProtocols,
+		messages: []any{socketRequest{}, socketMessage{}},
+	},
+
+	"POST /webhooks": {
+		summary: "Create a webhook", tag: "webhooks",
+		request: WebhookRequest{}, status: http.StatusCreated, response: WebhookResponse{},
+	},
+	"GET /webhooks": {
+		summary: "List the caller's webhooks", tag: "webhooks",
+		status: http.StatusOK, response: []*WebhookResponse{},
+	},
+	"GET /webhooks/{id}": {
+		summary: "Get a webhook", tag: "webhooks",
+		status: http.StatusOK, response: WebhookResponse{},
+	},
+	"PUT /webhooks/{id}": {
+		summary: "Update a webhook", tag: "webhooks",
+		request: WebhookRequest{}, status: http.StatusOK, response: WebhookResponse{},
+	},
+	"DELETE /webhooks/{id}": {
+		summary: "Delete a webhook", tag: "webhooks",
+		status: http.StatusOK, response: WebhookResponse{},
+	},
+	"GET /webhooks/{id}/deliveries": {
+		summary: "List the deliveries of a webhook", tag: "webhooks",
+		query: []*openapi.Parameter{
+			queryParam("status", "string", "list only deliveries in this status"),
+			queryParam("limit", "integer", "maximum number of deliveries"),
+		},
+		status: http.StatusOK, response: []*models.WebhookDelivery{},
+	},
+	"POST /webhooks/{id}/deliveries/{deliveryID}/retry": {
+		summary: "Retry a delivery", tag: "webhooks",
+		status: http.StatusOK, response: models.WebhookDelivery{},
+	},
+
+	"GET /sync": {
+		summary: "Pull the task changes since a sync token", tag: "sync",
+		query: []*openapi.Parameter{
+			queryParam("since", "string", "token returned by the previous pull"),
+			queryParam("limit", "integer", "maximum number of changes"),
+		},
+		status: http.StatusOK, response: syncPullResponse{},
+	},
+	"POST /sync": {
+		summary: "Push offline task changes", tag: "sync",
+		request: syncPushRequest{}, status: http.StatusOK, response: syncPushResponse{},
+	},
+
+	"POST /import": {
+		summary: "Import tasks from a file", tag: "import",
+		query: []*openapi.Parameter{
+			queryParam("format", "string", "csv, json or todotxt; defaults from the Content-Type"),
+			queryParam("dry_run", "boolean", "validate the file without creating tasks"),
+			queryParam("columns", "string", "CSV header to task field mapping, e.g. Name:title"),
+		},
+		requestTypes: []string{"text/csv", "application/json", "text/plain"},
+		status:       http.StatusCreated, response: importResponse{},
+		others: map[int]any{http.StatusOK: importResponse{}, http.StatusUnprocessableEntity: importResponse{}},
+	},
+	"GET /export": {
+		summary: "Export tasks to a file", tag: "import",
+		query: []*openapi.Parameter{
+			queryParam("format", "string", "csv, json, todotxt or ics"),
+			queryParam("archived", "boolean", "export only archived or only active tasks"),
+		},
+		status: http.StatusOK, responseTypes: []string{"text/csv", "application/json", "text/plain", "text/calendar"},
+	},
+
+	"POST /calendar/token": {
+		summary: "Create the calendar feed token", tag: "calendar",
+		status: http.StatusCreated, response: calendarTokenResponse{},
+	},
+	"DELETE /calendar/token": {
+		summary: "Revoke the calendar feed token", tag: "calendar",
+		status: http.StatusNoContent,
+	},
+
+	"GET /openapi.json": {
+		summary: "Get this document", tag: "meta", public: true,
+		status: http.StatusOK, responseTypes: []string{"application/json"},
+	},
+}
+
+func newOpenAPIDocument(prefix string) *openapi.Document {
+	doc := openapi.New("Todo API", apiVersion, prefix)
+	doc.Components.SecuritySchemes["token"] = &openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        "Authorization",
+		Description: "The token returned by POST /auth/login, without a scheme.",
+	}
+
+	var statuses []any
+	for status := range models.DefaultWorkflow.Transitions {
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b any) int {
+		return strings.Compare(string(a.(models.TaskStatus)), string(b.(models.TaskStatus)))
+	})
+	doc.DefineEnum(models.TaskStatus(""), statuses...)
+	doc.DefineEnum(models.TaskEventType(""), models.TaskCreated, models.TaskUpdated, models.TaskDeleted, models.TasksReset)
+	doc.DefineEnum(models.WebhookDeliveryStatus(""), models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead)
+
+	endpoints := make([]string, 0, len(apiRoutes))
+	for endpoint := range apiRoutes {
+		endpoints = append(endpoints, endpoint)
+	}
+	slices.Sort(endpoints)
+	for _, endpoint := range endpoints {
+		method, path, _ := strings.Cut(endpoint, " ")
+		doc.AddOperation(method, path, apiRoutes[endpoint].operation(doc))
+	}
+	return doc
+}
+
+func (route apiRoute) operation(doc *openapi.Document) *openapi.Operation {
+	op := &openapi.Operation{
+		Summary:    route.summary,
+		Tags:       []string{route.tag},
+		Parameters: route.query,
+		Responses: map[string]*openapi.Response{
+			"default": {
+				Description: "Error",
+				Content:     map[string]*openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}},
+			},
+		},
+	}
+
+	if route.request != nil {
+		op.RequestBody = &openapi.RequestBody{
+			Required: true,
+			Content:  map[string]*openapi.MediaType{"application/json": {Schema: doc.Schema(route.request)}},
+		}
+		op.Responses[strconv.Itoa(http.StatusBadRequest)] = &openapi.Response{
+			Description: "Invalid payload",
+			Content:     map[string]*openapi.MediaType{"application/json": {Schema: doc.Schema(types.ErrorResponse{})}},
+		}
+	} else if route.requestTypes != nil {
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: rawContent(route.requestTypes)}
+	}
+
+	success := &openapi.Response{Description: http.StatusText(route.status)}
+	if route.response != nil {
+		success.Content = map[string]*openapi.MediaType{"application/json": {Schema: doc.Schema(route.response)}}
+	} else if route.responseTypes != nil {
+		success.Content = rawContent(route.responseTypes)
+	}
+	op.Responses[strconv.Itoa(route.status)] = success
+
+	for status, body := range route.others {
+		op.Responses[strconv.Itoa(status)] = &openapi.Response{
+			Description: http.StatusText(status),
+			Content:     map[string]*openapi.MediaType{"application/json": {Schema: doc.Schema(body)}},
+		}
+	}
+
+	for _, message := range route.messages {
+		doc.Schema(message)
+	}
+
+	if !route.public {
+		op.Security = []map[string][]string{{"token": {}}}
+		op.Responses[strconv.Itoa(http.StatusUnauthorized)] = &openapi.Response{
+			Description: "Missing or invalid token",
+			Content:     map[string]*openapi.MediaType{"application/json": {Schema: doc.Schema(types.ErrorResponse{})}},
+		}
+	}
+	return op
+}
+
+func rawContent(mediaTypes []string) map[string]*openapi.MediaType {
+	content := make(map[string]*openapi.MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string"}}
+	}
+	return content
+}
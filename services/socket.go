@@ -0,0 +1,352 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/events"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+)
+
+const (
+	// socketWriteWait is the time allowed to write a message to a client.
+	socketWriteWait = 10 * time.Second
+	// socketPongWait is the time allowed between two pongs from a client
+	// before the connection is considered dead.
+	socketPongWait = 60 * time.Second
+	// socketPingInterval is how often the server pings a client. It must be
+	// shorter than socketPongWait.
+	socketPingInterval = 30 * time.Second
+	// maxSocketMessageSize caps the size of a message sent by a client.
+	maxSocketMessageSize = 64 << 10
+	// socketSendBuffer is the number of messages queued for a client before
+	// queueing more waits for the client to catch up.
+	socketSendBuffer = 64
+)
+
+var ErrUnknownMessageType = errors.New("unknown message type")
+
+type SocketService struct {
+	store    store.Store
+	broker   *events.Broker
+	tasks    *TaskService
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[*socketConn]struct{}
+	// shuttingDown is set by Shutdown, after which connections are closed
+	// as soon as they open.
+	shuttingDown bool
+}
+
+// NewSocketService creates a SocketService whose mutations go through the
+// validation of tasks.
+func NewSocketService(store store.Store, broker *events.Broker, tasks *TaskService) *SocketService {
+	return &SocketService{
+		store:  store,
+		broker: broker,
+		tasks:  tasks,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
+		conns: map[*socketConn]struct{}{},
+	}
+}
+
+// Shutdown closes the open connections with 1001 (going away), since the
+// server does not track hijacked connections when it shuts down. Clients
+// reconnect to another instance and resume.
+func (s *SocketService) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shuttingDown = true
+	for c := range s.conns {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// socketRequest is a message sent by the client.
+type socketRequest struct {
+	Type string `json:"type"`
+	// Ref is chosen by the client and echoed in the reply to its message.
+	Ref         string `json:"ref,omitempty"`
+	LastEventID int64  `json:"last_event_id,omitempty"`
+	ID          int64  `json:"id,omitempty"`
+	// Task is the task to create, or the fields replacing those of the task
+	// to update.
+	Task *CreateTaskRequest `json:"task,omitempty"`
+}
+
+// socketMessage is a message sent by the server.
+type socketMessage struct {
+	Type   string             `json:"type"`
+	Ref    string             `json:"ref,omitempty"`
+	Status int                `json:"status,omitempty"`
+	Task   *TaskResponse      `json:"task,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Event  *TaskEventResponse `json:"event,omitempty"`
+}
+
+// # GET /ws:
+//
+// Upgrades to a WebSocket carrying JSON text messages. As with GET /events,
+// browsers can pass the token as ?token=.
+//
+// Client messages:
+//
+//	{"type": "subscribe", "ref": "1", "last_event_id": 41}
+//	{"type": "unsubscribe", "ref": "2"}
+//	{"type": "create", "ref": "3", "task": {"title": "Learn Golang"}}
+//	{"type": "update", "ref": "4", "id": 1, "task": {"title": "Learn Go", "status": "in_progress"}}
+//	{"type": "complete", "ref": "5", "id": 1}
+//	{"type": "delete", "ref": "6", "id": 1}
+//
+// Every client message is answered with a result, or an error carrying the
+// status code the REST API would have returned:
+//
+//	{"type": "result", "ref": "3", "status": 201, "task": {...}}
+//	{"type": "error", "ref": "4", "status": 422, "error": "invalid status transition ..."}
+//
+// While subscribed, the caller's task changes are pushed as they happen.
+// Resuming from last_event_id works as for GET /events, including the
+// "reset" message when the missed events are no longer buffered:
+//
+//	{"type": "event", "event": {"id": 42, "type": "task.updated", ...}}
+//	{"type": "reset"}
+//
+// Mutations are applied one at a time in the order they are received, and
+// the next message is not read until the reply is queued. The server pings
+// every 30 seconds and closes connections that stop answering, that send
+// messages over 64 KiB, or whose events fall 64 messages behind.
+func (s *SocketService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("GET /ws", s.handleSocket)
+}
+
+func (s *SocketService) handleSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an error.
+		return
+	}
+
+	// The request context is not cancelled when a hijacked connection
+	// closes, so the connection gets its own.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	c := &socketConn{
+		conn:   conn,
+		userID: userID,
+		send:   make(chan *socketMessage, socketSendBuffer),
+		done:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	if s.shuttingDown {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	writerDone := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(writerDone)
+	}()
+
+	s.readLoop(ctx, c)
+
+	c.unsubscribe(s.broker)
+	<-writerDone
+}
+
+// readLoop handles the client's messages until the connection closes.
+func (s *SocketService) readLoop(ctx context.Context, c *socketConn) {
+	c.conn.SetReadLimit(maxSocketMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				c.close(websocket.CloseMessageTooBig, "message too big")
+			} else {
+				c.close(websocket.CloseNormalClosure, "")
+			}
+			return
+		}
+
+		var msg socketRequest
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.enqueue(&socketMessage{Type: "error", Status: http.StatusBadRequest, Error: "Invalid message payload"})
+			continue
+		}
+
+		c.enqueue(s.handleMessage(ctx, c, &msg))
+	}
+}
+
+// handleMessage applies a client message and returns the reply.
+func (s *SocketService) handleMessage(ctx context.Context, c *socketConn, msg *socketRequest) *socketMessage {
+	switch msg.Type {
+	case "subscribe":
+		c.subscribe(s.broker, msg.LastEventID)
+		return &socketMessage{Type: "result", Ref: msg.Ref, Status: http.StatusOK}
+
+	case "unsubscribe":
+		c.unsubscribe(s.broker)
+		return &socketMessage{Type: "result", Ref: msg.Ref, Status: http.StatusOK}
+
+	case "create", "update", "complete", "delete":
+		op := bulkOperation{Op: msg.Type, ID: msg.ID, Task: msg.Task}
+
+		var task *models.Task
+		var status int
+		var opErr error
+		err := s.store.WithTx(ctx, func(tx store.Store) error {
+			task, status, opErr = s.tasks.applyBulkOperation(tx, c.userID, op)
+			return opErr
+		})
+		if err != nil {
+			if opErr == nil || status == http.StatusInternalServerError {
+				return &socketMessage{Type: "error", Ref: msg.Ref, Status: http.StatusInternalServerError, Error: "Error executing operation"}
+			}
+			return &socketMessage{Type: "error", Ref: msg.Ref, Status: status, Error: opErr.Error()}
+		}
+		return &socketMessage{Type: "result", Ref: msg.Ref, Status: status, Task: newTaskResponse(task)}
+	}
+
+	return &socketMessage{Type: "error", Ref: msg.Ref, Status: http.StatusBadRequest, Error: ErrUnknownMessageType.Error()}
+}
+
+// socketConn is a client connection. Only writeLoop writes to conn.
+type socketConn struct {
+	conn   *websocket.Conn
+	userID int64
+	send   chan *socketMessage
+
+	closeOnce sync.Once
+	done      chan struct{}
+	closeCode int
+	closeText string
+
+	mu  sync.Mutex
+	sub *events.Subscription
+}
+
+// enqueue queues a message for the client, waiting while the queue is
+// full. Since replies are queued by the read loop, a client that does not
+// read its replies stops being read from.
+func (c *socketConn) enqueue(msg *socketMessage) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	}
+}
+
+// close ends the connection with the close code and text. Only the first
+// call has an effect.
+func (c *socketConn) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeText = code, text
+		close(c.done)
+	})
+}
+
+// writeLoop writes the queued messages and the pings until the connection
+// is closed, then sends the close message.
+func (c *socketConn) writeLoop() {
+	ticker := time.NewTicker(socketPingInterval)
+	defer ticker.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			message := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+			c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteWait))
+			return
+		}
+	}
+}
+
+// subscribe starts forwarding the user's task events, replacing any
+// previous subscription.
+func (c *socketConn) subscribe(broker *events.Broker, lastEventID int64) {
+	c.unsubscribe(broker)
+
+	sub, replay, complete := broker.Subscribe(c.userID, lastEventID)
+	c.mu.Lock()
+	c.sub = sub
+	c.mu.Unlock()
+
+	if !complete {
+		c.enqueue(&socketMessage{Type: "reset"})
+	}
+	for _, e := range replay {
+		c.enqueue(eventMessage(e))
+	}
+
+	go func() {
+		for e := range sub.C {
+			c.enqueue(eventMessage(e))
+		}
+
+		// The channel is also closed when the broker drops a subscriber
+		// that fell behind; the client then resumes by reconnecting.
+		c.mu.Lock()
+		dropped := c.sub == sub
+		c.mu.Unlock()
+		if dropped {
+			c.close(websocket.CloseTryAgainLater, "client too slow")
+		}
+	}()
+}
+
+func (c *socketConn) unsubscribe(broker *events.Broker) {
+	c.mu.Lock()
+	sub := c.sub
+	c.sub = nil
+	c.mu.Unlock()
+
+	if sub != nil {
+		broker.Unsubscribe(sub)
+	}
+}
+
+func eventMessage(e *models.TaskEvent) *socketMessage {
+	if e.Type == models.TasksReset {
+		return &socketMessage{Type: "reset"}
+	}
+	return &socketMessage{Type: "event", Event: newTaskEventResponse(e)}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hsrvms/todoapp/events"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestSocket(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	broker := events.NewBroker(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go st.Listen(ctx, broker.Publish)
+	waitListening(t, st, broker)
+
+	mux := newTestRouter(st, NewSocketService(st, broker, NewTaskService(st)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	exchange := func(msg socketRequest) *socketMessage {
+		t.Helper()
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+		var reply socketMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return &reply
+	}
+
+	reply := exchange(socketRequest{Type: "subscribe", Ref: "1"})
+	if reply.Type != "result" || reply.Ref != "1" {
+		t.Fatalf("subscribe: got %+v", reply)
+	}
+
+	reply = exchange(socketRequest{Type: "create", Ref: "2", Task: &CreateTaskRequest{}})
+	if reply.Type != "error" || reply.Status != http.StatusBadRequest || reply.Error != ErrTitleRequired.Error() {
+		t.Errorf("invalid create: got %+v", reply)
+	}
+
+	reply = exchange(socketRequest{Type: "create", Ref: "3", Task: &CreateTaskRequest{Title: "Learn Golang"}})
+	if reply.Type != "result" || reply.Status != http.StatusCreated || reply.Task == nil {
+		t.Fatalf("create: got %+v", reply)
+	}
+
+	var event socketMessage
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "event" || event.Event.Type != models.TaskCreated || event.Event.Task.ID != reply.Task.ID {
+		t.Errorf("event: got %+v", event)
+	}
+
+	// The tasks of other users cannot be changed over the socket either.
+	other := otherUserTask(t, st)
+	for _, op := range []string{"update", "complete", "delete"} {
+		reply = exchange(socketRequest{Type: op, Ref: op, ID: other.ID, Task: &CreateTaskRequest{Title: "Hijacked"}})
+		if reply.Type != "error" || reply.Status != http.StatusNotFound {
+			t.Errorf("%s of another user's task: got %+v", op, reply)
+		}
+	}
+	assertTaskUnchanged(t, st, other)
+
+	reply = exchange(socketRequest{Type: "publish", Ref: "4"})
+	if reply.Type != "error" || reply.Error != ErrUnknownMessageType.Error() {
+		t.Errorf("unknown type: got %+v", reply)
+	}
+}
+
+// waitListening waits until the events notified through st reach broker.
+func waitListening(t *testing.T, st store.Store, broker *events.Broker) {
+	t.Helper()
+	const probeUserID = -1
+	sub, _, _ := broker.Subscribe(probeUserID, 0)
+	defer broker.Unsubscribe(sub)
+
+	deadline := time.After(5 * time.Second)
+	for {
+		st.NotifyTaskEvent(&models.TaskEvent{Type: models.TaskUpdated, UserID: probeUserID})
+		select {
+		case <-sub.C:
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("listener not started")
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/utils"
+)
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+	// maxSyncMutations caps the number of mutations pushed at once.
+	maxSyncMutations = 500
+)
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+var ErrNoMutations = errors.New("mutations are required")
+var ErrTooManyMutations = fmt.Errorf("at most %d mutations are allowed", maxSyncMutations)
+var ErrTaskDeleted = errors.New("task was deleted")
+
+// syncFields are the task fields merged field by field when pushed.
+var syncFields = []string{"title", "description", "status"}
+
+type SyncService struct {
+	store store.Store
+	tasks *TaskService
+}
+
+// NewSyncService creates a SyncService whose mutations go through the
+// validation of tasks.
+func NewSyncService(store store.Store, tasks *TaskService) *SyncService {
+	return &SyncService{store: store, tasks: tasks}
+}
+
+type syncPullResponse struct {
+	Token   string          `json:"token"`
+	Tasks   []*TaskResponse `json:"tasks"`
+	Deleted []int64         `json:"deleted"`
+	HasMore bool            `json:"has_more"`
+}
+
+type syncPushRequest struct {
+	Mutations []syncMutation `json:"mutations"`
+}
+
+type syncMutation struct {
+	Op string `json:"op"`
+	// ID names the task to update or delete. Ref can name a task created
+	// earlier in the same push instead.
+	ID         int64                      `json:"id"`
+	Ref        string                     `json:"ref"`
+	Fields     map[string]json.RawMessage `json:"fields"`
+	ModifiedAt time.Time                  `json:"modified_at"`
+}
+
+type syncResult struct {
+	Index     int           `json:"index"`
+	Op        string        `json:"op"`
+	Status    int           `json:"status"`
+	Task      *TaskResponse `json:"task,omitempty"`
+	Applied   []string      `json:"applied,omitempty"`
+	Discarded []string      `json:"discarded,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type syncPushResponse struct {
+	Results []syncResult `json:"results"`
+}
+
+// # GET /sync?since=<token>&limit=500:
+//
+// Returns the caller's tasks changed since the token, including archived
+// ones, and the IDs of the tasks deleted since, in the order the changes
+// were made. Without since every task is returned. The token is opaque;
+// pass the returned one as since on the next call, straight away while
+// has_more is set.
+//
+// Response:
+//
+//	{
+//	 "token": "1042",
+//	 "tasks": [{"id": 1, "title": "Learn Golang", ...}],
+//	 "deleted": [7, 9],
+//	 "has_more": false,
+//	}
+//
+// # POST /sync:
+//
+// Applies the mutations queued by an offline client, in order and each on
+// its own. "modified_at" is when the client made the change; times in the
+// future are taken as now.
+//
+// Payload:
+//
+//	{
+//	 "mutations": [
+//	  {"op": "create", "ref": "a1", "fields": {"title": "Learn Golang"}, "modified_at": "2024-04-12T18:02:27Z"},
+//	  {"op": "update", "ref": "a1", "fields": {"status": "done"}, "modified_at": "2024-04-12T18:05:00Z"},
+//	  {"op": "update", "id": 2, "fields": {"title": "Learn Go", "description": ""}, "modified_at": "2024-04-12T18:06:00Z"},
+//	  {"op": "delete", "id": 3, "modified_at": "2024-04-12T18:07:00Z"},
+//	 ],
+//	}
+//
+// Conflicts are resolved per field, "title", "description" and "status",
+// by last writer wins: a field is only changed when the mutation is newer
+// than the field's last write, and the server's value is kept on a tie.
+// The fields kept are listed in "discarded". Deletions always win: a
+// deleted task cannot be updated again (410) and deleting it twice
+// succeeds. The status must still follow the workflow (422).
+//
+// Response:
+//
+//	{
+//	 "results": [
+//	  {"index": 0, "op": "create", "status": 201, "task": {...}, "applied": ["title"]},
+//	  {"index": 1, "op": "update", "status": 200, "task": {...}, "applied": ["status"]},
+//	  {"index": 2, "op": "update", "status": 200, "task": {...}, "applied": ["description"], "discarded": ["title"]},
+//	  {"index": 3, "op": "delete", "status": 200, "task": {...}},
+//	 ],
+//	}
+func (s *SyncService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("GET /sync", s.handleSyncPull)
+	api.HandleFunc("POST /sync", s.handleSyncPush)
+}
+
+func (s *SyncService) handleSyncPull(w http.ResponseWriter, r *http.Request) {
+	since := int64(-1)
+	if token := r.URL.Query().Get("since"); token != "" {
+		value, err := strconv.ParseInt(token, 10, 64)
+		if err != nil || value < 0 {
+			http.Error(w, ErrInvalidSyncToken.Error(), http.StatusBadRequest)
+			return
+		}
+		since = value
+	}
+
+	limit := defaultSyncLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		value, err := strconv.Atoi(l)
+		if err != nil || value < 1 || value > maxSyncLimit {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	changes, err := s.store.GetTaskChanges(userID, since, limit)
+	if err != nil {
+		http.Error(w, "Error retrieving changes", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, syncPullResponse{
+		Token:   strconv.FormatInt(changes.Seq, 10),
+		Tasks:   newTaskResponses(changes.Tasks),
+		Deleted: changes.Deleted,
+		HasMore: changes.HasMore,
+	})
+}
+
+func (s *SyncService) handleSyncPush(w http.ResponseWriter, r *http.Request) {
+	var req syncPushRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	if len(req.Mutations) == 0 {
+		http.Error(w, ErrNoMutations.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Mutations) > maxSyncMutations {
+		http.Error(w, ErrTooManyMutations.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	var results []syncResult
+	err := s.store.WithTx(r.Context(), func(tx store.Store) error {
+		results = make([]syncResult, len(req.Mutations))
+		refs := map[string]int64{}
+
+		for i, m := range req.Mutations {
+			var result syncResult
+			err := tx.WithTx(r.Context(), func(tx store.Store) error {
+				result = s.applyMutation(tx, userID, m, refs)
+				if result.Error != "" {
+					return errors.New(result.Error)
+				}
+				return nil
+			})
+			if errors.Is(err, store.ErrSerializationFailure) {
+				return err
+			}
+			if err != nil && result.Error == "" {
+				result = syncResult{Status: http.StatusInternalServerError}
+			}
+			if result.Status == http.StatusCreated && m.Ref != "" {
+				refs[m.Ref] = result.Task.ID
+			}
+
+			result.Index, result.Op = i, m.Op
+			if result.Status == http.StatusInternalServerError {
+				result.Error = "Error executing mutation"
+			}
+			results[i] = result
+		}
+
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Error executing mutations", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, syncPushResponse{Results: results})
+}
+
+// applyMutation merges one mutation into the task it names. refs maps the
+// refs of the tasks created so far to their IDs.
+func (s *SyncService) applyMutation(tx store.Store, userID int64, m syncMutation, refs map[string]int64) syncResult {
+	fail := func(status int, err error) syncResult {
+		return syncResult{Status: status, Error: err.Error()}
+	}
+
+	if m.ModifiedAt.IsZero() {
+		return fail(http.StatusBadRequest, errors.New("modified_at is required"))
+	}
+	modifiedAt := m.ModifiedAt.UTC()
+	if now := time.Now().UTC(); modifiedAt.After(now) {
+		modifiedAt = now
+	}
+
+	if m.Op == "create" {
+		return s.createTask(tx, userID, m, modifiedAt)
+	}
+	if m.Op != "update" && m.Op != "delete" {
+		return fail(http.StatusBadRequest, fmt.Errorf("unknown op %q", m.Op))
+	}
+
+	taskID := m.ID
+	if taskID == 0 && m.Ref != "" {
+		taskID = refs[m.Ref]
+	}
+	if taskID <= 0 {
+		return fail(http.StatusBadRequest, errors.New("id is required"))
+	}
+	id := strconv.FormatInt(taskID, 10)
+
+	existingTask, err := tx.GetTaskByID(id, userID)
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+	if existingTask == nil {
+		deleted, err := tx.IsTaskDeleted(id, userID)
+		switch {
+		case err != nil:
+			return fail(http.StatusInternalServerError, err)
+		case !deleted:
+			return fail(http.StatusNotFound, ErrTaskNotFound)
+		case m.Op == "delete":
+			return syncResult{Status: http.StatusOK}
+		default:
+			return fail(http.StatusGone, ErrTaskDeleted)
+		}
+	}
+
+	if m.Op == "delete" {
+		deletedTask, err := tx.DeleteTask(id, userID)
+		if err == nil {
+			err = notifyTaskEvent(tx, models.TaskDeleted, deletedTask)
+		}
+		if err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+		return syncResult{Status: http.StatusOK, Task: newTaskResponse(deletedTask)}
+	}
+
+	times, err := tx.GetTaskFieldTimes(id)
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	task := *existingTask
+	task.Status = ""
+	var result syncResult
+	for _, field := range sortedFields(m.Fields) {
+		if !slices.Contains(syncFields, field) {
+			return fail(http.StatusBadRequest, fmt.Errorf("unknown field %q", field))
+		}
+		if !modifiedAt.After(times[field]) {
+			result.Discarded = append(result.Discarded, field)
+			continue
+		}
+		if err := setSyncField(&task, field, m.Fields[field]); err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+		times[field] = modifiedAt
+		result.Applied = append(result.Applied, field)
+	}
+	if !slices.Contains(result.Applied, "status") {
+		task.Status = existingTask.Status
+	}
+
+	if len(result.Applied) == 0 {
+		result.Status, result.Task = http.StatusOK, newTaskResponse(existingTask)
+		return result
+	}
+
+	if err := validateTaskPayload(&task); err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+	if err := s.tasks.applyTransition(existingTask, &task); err != nil {
+		if errors.Is(err, ErrInvalidTransition) {
+			return fail(http.StatusUnprocessableEntity, err)
+		}
+		return fail(http.StatusBadRequest, err)
+	}
+
+	updatedTask, err := tx.UpdateTaskFields(id, userID, &task, times)
+	if err == nil {
+		err = notifyTaskEvent(tx, models.TaskUpdated, updatedTask)
+	}
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	result.Status, result.Task = http.StatusOK, newTaskResponse(updatedTask)
+	return result
+}
+
+// createTask creates the task of a create mutation, dating its fields at
+// modifiedAt so that later mutations from the same client apply.
+func (s *SyncService) createTask(tx store.Store, userID int64, m syncMutation, modifiedAt time.Time) syncResult {
+	fail := func(status int, err error) syncResult {
+		return syncResult{Status: status, Error: err.Error()}
+	}
+
+	var task models.Task
+	times := map[string]time.Time{}
+	for _, field := range syncFields {
+		times[field] = modifiedAt
+	}
+	for _, field := range sortedFields(m.Fields) {
+		if !slices.Contains(syncFields, field) {
+			return fail(http.StatusBadRequest, fmt.Errorf("unknown field %q", field))
+		}
+		if err := setSyncField(&task, field, m.Fields[field]); err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+	}
+
+	if err := validateTaskPayload(&task); err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+	task.UserID = userID
+	if err := s.tasks.applyTransition(nil, &task); err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+
+	createdTask, err := tx.CreateTask(&task)
+	if err == nil {
+		createdTask, err = tx.UpdateTaskFields(strconv.FormatInt(createdTask.ID, 10), userID, createdTask, times)
+	}
+	if err == nil {
+		err = notifyTaskEvent(tx, models.TaskCreated, createdTask)
+	}
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	return syncResult{Status: http.StatusCreated, Task: newTaskResponse(createdTask), Applied: sortedFields(m.Fields)}
+}
+
+func setSyncField(task *models.Task, field string, value json.RawMessage) error {
+	var err error
+	switch field {
+	case "title":
+		err = json.Unmarshal(value, &task.Title)
+	case "description":
+		err = json.Unmarshal(value, &task.Description)
+	case "status":
+		err = json.Unmarshal(value, &task.Status)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s", field)
+	}
+	return nil
+}
+
+func sortedFields(fields map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
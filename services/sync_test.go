@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestSync(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewSyncService(st, NewTaskService(st)))
+
+	do := func(method, target string, body any, v any) {
+		t.Helper()
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(payload))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s %s: got %d: %s", method, target, rr.Code, rr.Body)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	push := func(mutations ...syncMutation) []syncResult {
+		t.Helper()
+		var resp syncPushResponse
+		do("POST", "/sync", syncPushRequest{Mutations: mutations}, &resp)
+		return resp.Results
+	}
+	pull := func(since string) syncPullResponse {
+		t.Helper()
+		var resp syncPullResponse
+		do("GET", "/sync?since="+since, nil, &resp)
+		return resp
+	}
+
+	offline := time.Now().UTC().Add(-time.Hour)
+	results := push(
+		syncMutation{Op: "create", Ref: "a1", Fields: fields(t, "title", "Learn Golang"), ModifiedAt: offline},
+		syncMutation{Op: "update", Ref: "a1", Fields: fields(t, "status", "done"), ModifiedAt: offline.Add(time.Minute)},
+		syncMutation{Op: "create", Fields: fields(t, "title", ""), ModifiedAt: offline},
+	)
+	if results[0].Status != http.StatusCreated || results[1].Status != http.StatusOK || results[1].Task.Status != models.StatusDone {
+		t.Fatalf("create then update: got %+v", results[:2])
+	}
+	if results[2].Status != http.StatusBadRequest {
+		t.Errorf("invalid create: got %+v", results[2])
+	}
+	taskID := results[0].Task.ID
+
+	initial := pull("")
+	if len(initial.Tasks) != 1 || initial.Tasks[0].ID != taskID {
+		t.Fatalf("initial pull: got %+v", initial)
+	}
+
+	// A write made on the server after the client went offline wins over
+	// the client's older change to the same field.
+	if _, err := st.UpdateTask("1", 1, &models.Task{Title: "Learn Go", Status: models.StatusDone}); err != nil {
+		t.Fatal(err)
+	}
+	results = push(syncMutation{
+		Op:         "update",
+		ID:         taskID,
+		Fields:     fields(t, "title", "Learn Golang well", "description", "Tour of Go"),
+		ModifiedAt: offline.Add(2 * time.Minute),
+	})
+	if !slices.Equal(results[0].Applied, []string{"description"}) || !slices.Equal(results[0].Discarded, []string{"title"}) {
+		t.Errorf("conflict: got applied %v discarded %v", results[0].Applied, results[0].Discarded)
+	}
+	if results[0].Task.Title != "Learn Go" || results[0].Task.Description != "Tour of Go" {
+		t.Errorf("conflict: got task %+v", results[0].Task)
+	}
+
+	results = push(
+		syncMutation{Op: "delete", ID: taskID, ModifiedAt: offline},
+		syncMutation{Op: "update", ID: taskID, Fields: fields(t, "title", "Too late"), ModifiedAt: time.Now().UTC()},
+		syncMutation{Op: "delete", ID: taskID, ModifiedAt: offline},
+	)
+	if results[0].Status != http.StatusOK || results[1].Status != http.StatusGone || results[2].Status != http.StatusOK {
+		t.Errorf("delete: got %+v", results)
+	}
+
+	changes := pull(initial.Token)
+	if len(changes.Tasks) != 0 || !slices.Equal(changes.Deleted, []int64{taskID}) || changes.HasMore {
+		t.Errorf("pull after delete: got %+v", changes)
+	}
+	if again := pull(changes.Token); len(again.Tasks) != 0 || len(again.Deleted) != 0 || again.Token != changes.Token {
+		t.Errorf("pull without changes: got %+v", again)
+	}
+}
+
+func TestSyncOtherUsersDeletedTask(t *testing.T) {
+	st := store.NewMockStore()
+	other := otherUserTask(t, st)
+	if _, err := st.DeleteTask(strconv.FormatInt(other.ID, 10), other.UserID); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestRouter(st, NewSyncService(st, NewTaskService(st)))
+
+	payload, _ := json.Marshal(syncPushRequest{Mutations: []syncMutation{
+		{Op: "update", ID: other.ID, Fields: fields(t, "title", "Hijacked"), ModifiedAt: time.Now().UTC()},
+		{Op: "delete", ID: other.ID, ModifiedAt: time.Now().UTC()},
+	}})
+	req := httptest.NewRequest("POST", "/sync", bytes.NewReader(payload))
+	req.Header.Set("Authorization", testToken(t))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	// Another user's deleted task is not found, as if it never existed.
+	var resp syncPushResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("got %d %s", rr.Code, rr.Body)
+	}
+	for i, result := range resp.Results {
+		if result.Status != http.StatusNotFound {
+			t.Errorf("mutation %d: got %+v", i, result)
+		}
+	}
+}
+
+// fields builds the fields of a mutation from name and value pairs.
+func fields(t *testing.T, pairs ...string) map[string]json.RawMessage {
+	t.Helper()
+	m := map[string]json.RawMessage{}
+	for i := 0; i < len(pairs); i += 2 {
+		value, err := json.Marshal(pairs[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[pairs[i]] = value
+	}
+	return m
+}
@@ -1,26 +1,138 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/hsrvms/todoapp/auth"
 	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/query"
+	"github.com/hsrvms/todoapp/router"
 	"github.com/hsrvms/todoapp/store"
 	"github.com/hsrvms/todoapp/utils"
+	"github.com/hsrvms/todoapp/validate"
 )
 
 var ErrTitleRequired = errors.New("title is required")
+var ErrTaskNotFound = errors.New("task not found")
+var ErrQueryRequired = errors.New("q is required")
+var ErrInvalidStatus = errors.New("invalid status")
+var ErrInvalidTransition = errors.New("invalid status transition")
+var ErrInvalidPriority = errors.New("priority must be a letter from A to Z")
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// maxTitleLength and maxDescriptionLength are the sizes of the columns,
+	// in characters.
+	maxTitleLength       = 255
+	maxDescriptionLength = 255
+)
 
 type TaskService struct {
-	store store.Store
+	store    store.Store
+	workflow models.Workflow
 }
 
 func NewTaskService(store store.Store) *TaskService {
-	return &TaskService{store: store}
+	return &TaskService{store: store, workflow: models.DefaultWorkflow}
+}
+
+// CreateTaskRequest is the payload of POST /tasks. The ID, owner and times
+// of a task are set by the server.
+type CreateTaskRequest struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Status      models.TaskStatus `json:"status"`
+	DueAt       *time.Time        `json:"due_at"`
+	Priority    string            `json:"priority"`
+}
+
+func (req *CreateTaskRequest) task() *models.Task {
+	return &models.Task{
+		Title:       req.Title,
+		Description: req.Description,
+		Status:      req.Status,
+		DueAt:       req.DueAt,
+		Priority:    req.Priority,
+	}
+}
+
+// UpdateTaskRequest is the payload of PUT /tasks/{id}, which replaces every
+// field a client can set.
+type UpdateTaskRequest CreateTaskRequest
+
+func (req *UpdateTaskRequest) task() *models.Task {
+	return (*CreateTaskRequest)(req).task()
+}
+
+// TaskResponse is a task as served by the API.
+type TaskResponse struct {
+	ID          int64             `json:"id"`
+	UserID      int64             `json:"user_id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Status      models.TaskStatus `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CompletedAt *time.Time        `json:"completed_at"`
+	ArchivedAt  *time.Time        `json:"archived_at"`
+	DueAt       *time.Time        `json:"due_at"`
+	Priority    string            `json:"priority"`
+}
+
+func newTaskResponse(task *models.Task) *TaskResponse {
+	if task == nil {
+		return nil
+	}
+	return &TaskResponse{
+		ID:          task.ID,
+		UserID:      task.UserID,
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		CreatedAt:   task.CreatedAt,
+		CompletedAt: task.CompletedAt,
+		ArchivedAt:  task.ArchivedAt,
+		DueAt:       task.DueAt,
+		Priority:    task.Priority,
+	}
+}
+
+func newTaskResponses(tasks []*models.Task) []*TaskResponse {
+	resp := make([]*TaskResponse, len(tasks))
+	for i, task := range tasks {
+		resp[i] = newTaskResponse(task)
+	}
+	return resp
+}
+
+// TaskSearchResultResponse is a task matched by a search.
+type TaskSearchResultResponse struct {
+	Task    *TaskResponse `json:"task"`
+	Rank    float64       `json:"rank"`
+	Snippet string        `json:"snippet"`
+}
+
+func newTaskSearchResultResponses(results []*models.TaskSearchResult) []*TaskSearchResultResponse {
+	resp := make([]*TaskSearchResultResponse, len(results))
+	for i, r := range results {
+		resp[i] = &TaskSearchResultResponse{Task: newTaskResponse(r.Task), Rank: r.Rank, Snippet: r.Snippet}
+	}
+	return resp
 }
 
+// Task statuses follow the workflow served at GET /workflow. Moving a task
+// into a done status stamps "completed_at". For older clients "status" also
+// accepts a boolean: true moves the task to "done" and false reopens a done
+// task. "due_at" is optional and "priority" is a letter from "A" (highest)
+// to "Z", or empty.
+//
 // # POST /tasks:
 //
 // Payload:
@@ -28,6 +140,8 @@ func NewTaskService(store store.Store) *TaskService {
 //	{
 //	 "title": "Learn Golang",
 //	 "description": "Learning process of Golang",
+//	 "due_at": "2024-04-30T17:00:00Z",
+//	 "priority": "B",
 //	}
 //
 // Response:
@@ -36,12 +150,17 @@ func NewTaskService(store store.Store) *TaskService {
 //	 "id": 1,
 //	 "title": "Learn Golang",
 //	 "description": "Learning process of Golang",
-//	 "status": false,
-//	 "created_at": "2024-04-12 18:02:27.924693",
+//	 "status": "todo",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
+//	 "due_at": "2024-04-30T17:00:00Z",
+//	 "priority": "B",
 //	}
 //
 // # GET /tasks:
 //
+// Archived tasks are left out unless ?archived=true is given, in which case
+// only the archived tasks are returned.
+//
 // Response:
 //
 //	[
@@ -49,8 +168,25 @@ func NewTaskService(store store.Store) *TaskService {
 //		"id": 1,
 //		"title": "Learn Golang",
 //		"description": "Learning process of Golang",
-//		"status": false,
-//		"created_at": "2024-04-12 18:02:27.924693",
+//		"status": "todo",
+//		"created_at": "2024-04-12T18:02:27.924693Z",
+//	 },
+//	]
+//
+// # GET /tasks/search?q=golang+proc+status:open&limit=20:
+//
+// q is written in the query language of package query. Every free text word
+// must match a word of the title or description, either fully or as its
+// prefix. Results are ordered by relevance and the snippet marks the
+// matched words. Archived tasks are searched with ?archived=true.
+//
+// Response:
+//
+//	[
+//	 {
+//		"task": {"id": 1, "title": "Learn Golang", ...},
+//		"rank": 0.6079271,
+//		"snippet": "Learn <mark>Golang</mark> Learning <mark>process</mark> of Golang",
 //	 },
 //	]
 //
@@ -62,8 +198,8 @@ func NewTaskService(store store.Store) *TaskService {
 //	 "id": 1,
 //	 "title": "Learn Golang",
 //	 "description": "Learning process of Golang",
-//	 "status": false,
-//	 "created_at": "2024-04-12 18:02:27.924693",
+//	 "status": "todo",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
 //	}
 //
 // # PUT /tasks/{id}:
@@ -73,7 +209,7 @@ func NewTaskService(store store.Store) *TaskService {
 //	{
 //	 "title": "Learn Golang +",
 //	 "description": "Learning process of Golang",
-//	 "status": false,
+//	 "status": "todo",
 //	}
 //
 // Response:
@@ -82,8 +218,8 @@ func NewTaskService(store store.Store) *TaskService {
 //	 "id": 1,
 //	 "title": "Learn Golang +",
 //	 "description": "Learning process of Golang",
-//	 "status": false,
-//	 "created_at": "2024-04-12 18:02:27.924693",
+//	 "status": "todo",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
 //	}
 //
 // # DELETE /tasks/{id}:
@@ -94,36 +230,76 @@ func NewTaskService(store store.Store) *TaskService {
 //	 "id": 1,
 //	 "title": "Learn Golang",
 //	 "description": "Learning process of Golang",
-//	 "status": false,
-//	 "created_at": "2024-04-12 18:02:27.924693",
+//	 "status": "todo",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
 //	}
-func (s *TaskService) RegisterRoutes(mux *http.ServeMux, prefix string) {
-	endpointCreate := generateEndpoint("POST", prefix, "/tasks")
-	endpointGetAll := generateEndpoint("GET", prefix, "/tasks")
-	endpointGetByID := generateEndpoint("GET", prefix, "/tasks/{id}")
-	endpointUpdate := generateEndpoint("PUT", prefix, "/tasks/{id}")
-	endpointDelete := generateEndpoint("DELETE", prefix, "/tasks/{id}")
-
-	mux.HandleFunc(endpointCreate, auth.WithJWTAuth(s.handleTaskCreate, s.store))
-	mux.HandleFunc(endpointGetAll, auth.WithJWTAuth(s.handleTaskGetAll, s.store))
-	mux.HandleFunc(endpointGetByID, auth.WithJWTAuth(s.handleTaskGetByID, s.store))
-	mux.HandleFunc(endpointUpdate, auth.WithJWTAuth(s.handleTaskUpdate, s.store))
-	mux.HandleFunc(endpointDelete, auth.WithJWTAuth(s.handleTaskDelete, s.store))
+//
+// # POST /tasks/{id}/archive:
+//
+// Response:
+//
+//	{
+//	 "id": 1,
+//	 "title": "Learn Golang",
+//	 "description": "Learning process of Golang",
+//	 "status": "done",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
+//	 "completed_at": "2024-04-13T09:12:05.182311Z",
+//	 "archived_at": "2024-04-20T10:00:00.000000Z",
+//	}
+//
+// # POST /tasks/{id}/unarchive:
+//
+// Response: the task with "archived_at" set to null.
+//
+// # POST /tasks/bulk:
+//
+// See handleTaskBulk.
+//
+// # GET /workflow:
+//
+// Response:
+//
+//	{
+//	 "initial": "todo",
+//	 "done": ["done", "wont_do"],
+//	 "transitions": {"todo": ["in_progress", "blocked", "done", "wont_do"], ...},
+//	}
+func (s *TaskService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("POST /tasks", s.handleTaskCreate)
+	api.HandleFunc("GET /tasks", s.handleTaskGetAll)
+	api.HandleFunc("GET /tasks/search", s.handleTaskSearch)
+	api.HandleFunc("GET /tasks/{id}", s.handleTaskGetByID)
+	api.HandleFunc("PUT /tasks/{id}", s.handleTaskUpdate)
+	api.HandleFunc("DELETE /tasks/{id}", s.handleTaskDelete)
+	api.HandleFunc("POST /tasks/{id}/archive", s.handleTaskArchive)
+	api.HandleFunc("POST /tasks/{id}/unarchive", s.handleTaskUnarchive)
+	api.HandleFunc("GET /workflow", s.handleWorkflowGet)
+	api.HandleFunc("POST /tasks/bulk", s.handleTaskBulk)
 }
 
 func (s *TaskService) handleTaskCreate(w http.ResponseWriter, r *http.Request) {
-	var task models.Task
-	if err := decodeJSON(r, &task); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	var req CreateTaskRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
 		return
 	}
 
-	if err := validateTaskPayload(&task); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	task := req.task()
+	if err := validateTaskPayload(task); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+	task.UserID, _ = auth.GetUserIDFromContext(r.Context())
+
+	if err := s.applyTransition(nil, task); err != nil {
+		writeInvalidPayload(w, statusError(err))
 		return
 	}
 
-	createdTask, err := s.store.CreateTask(&task)
+	createdTask, err := s.mutate(r.Context(), models.TaskCreated, func(tx store.Store) (*models.Task, error) {
+		return tx.CreateTask(task)
+	})
 	if err != nil {
 		http.Error(w, "Error creating task", http.StatusInternalServerError)
 		return
@@ -134,17 +310,93 @@ func (s *TaskService) handleTaskCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, createdTask)
+	utils.WriteJSON(w, http.StatusCreated, newTaskResponse(createdTask))
 }
 
 func (s *TaskService) handleTaskGetAll(w http.ResponseWriter, r *http.Request) {
-	tasks, err := s.store.GetAllTasks()
+	filter := store.TaskFilter{}
+	filter.UserID, _ = auth.GetUserIDFromContext(r.Context())
+
+	if archived := r.URL.Query().Get("archived"); archived != "" {
+		value, err := strconv.ParseBool(archived)
+		if err != nil {
+			http.Error(w, "Invalid archived parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Archived = value
+	}
+
+	tasks, err := s.store.GetAllTasks(filter)
 	if err != nil {
 		http.Error(w, "Error retrieving tasks", http.StatusInternalServerError)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, tasks)
+	utils.WriteJSON(w, http.StatusOK, newTaskResponses(tasks))
+}
+
+func (s *TaskService) handleTaskSearch(w http.ResponseWriter, r *http.Request) {
+	input := r.URL.Query().Get("q")
+	if input == "" {
+		http.Error(w, ErrQueryRequired.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := query.Parse(input, s.workflow, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Filter.UserID, _ = auth.GetUserIDFromContext(r.Context())
+
+	if archived := r.URL.Query().Get("archived"); archived != "" {
+		value, err := strconv.ParseBool(archived)
+		if err != nil {
+			http.Error(w, "Invalid archived parameter", http.StatusBadRequest)
+			return
+		}
+		q.Filter.Archived = value
+	}
+
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		value, err := strconv.Atoi(l)
+		if err != nil || value < 1 || value > maxSearchLimit {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	results, err := findTasks(s.store, q, limit)
+	if err != nil {
+		http.Error(w, "Error searching tasks", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newTaskSearchResultResponses(results))
+}
+
+// findTasks evaluates a parsed query. Queries with free text are ranked by
+// the full-text search; the others list the matching tasks in order.
+func findTasks(st store.Store, q *query.Query, limit int) ([]*models.TaskSearchResult, error) {
+	if len(q.Text) > 0 {
+		return st.SearchTasks(q.Filter, q.TextQuery(), limit)
+	}
+
+	tasks, err := st.GetAllTasks(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+
+	results := make([]*models.TaskSearchResult, len(tasks))
+	for i, task := range tasks {
+		results[i] = &models.TaskSearchResult{Task: task}
+	}
+	return results, nil
 }
 
 func (s *TaskService) handleTaskGetByID(w http.ResponseWriter, r *http.Request) {
@@ -155,7 +407,8 @@ func (s *TaskService) handleTaskGetByID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	task, err := s.store.GetTaskByID(taskID)
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	task, err := s.store.GetTaskByID(taskID, userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Task not found", http.StatusNotFound)
@@ -165,7 +418,12 @@ func (s *TaskService) handleTaskGetByID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, task)
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newTaskResponse(task))
 }
 
 func (s *TaskService) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
@@ -176,19 +434,46 @@ func (s *TaskService) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var task models.Task
-	if err := decodeJSON(r, &task); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	var req UpdateTaskRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
 		return
 	}
 
-	if err := validateTaskPayload(&task); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	task := req.task()
+	if err := validateTaskPayload(task); err != nil {
+		writeInvalidPayload(w, err)
 		return
 	}
 
-	updatedTask, err := s.store.UpdateTask(taskID, &task)
-	if err != nil {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	updatedTask, err := s.mutate(r.Context(), models.TaskUpdated, func(tx store.Store) (*models.Task, error) {
+		existingTask, err := tx.GetTaskByID(taskID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if existingTask == nil {
+			return nil, ErrTaskNotFound
+		}
+
+		if err := s.applyTransition(existingTask, task); err != nil {
+			return nil, err
+		}
+
+		return tx.UpdateTask(taskID, userID, task)
+	})
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, ErrInvalidStatus):
+		writeInvalidPayload(w, statusError(err))
+		return
+	case err != nil:
 		http.Error(w, "Error updating task", http.StatusInternalServerError)
 		return
 	}
@@ -198,7 +483,7 @@ func (s *TaskService) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, updatedTask)
+	utils.WriteJSON(w, http.StatusOK, newTaskResponse(updatedTask))
 }
 
 func (s *TaskService) handleTaskDelete(w http.ResponseWriter, r *http.Request) {
@@ -209,24 +494,168 @@ func (s *TaskService) handleTaskDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deletedTask, err := s.store.DeleteTask(taskID)
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	deletedTask, err := s.mutate(r.Context(), models.TaskDeleted, func(tx store.Store) (*models.Task, error) {
+		return tx.DeleteTask(taskID, userID)
+	})
 	if err != nil {
 		http.Error(w, "Error deleting task", http.StatusInternalServerError)
 		return
 	}
 
 	if deletedTask == nil {
-		http.Error(w, "Error deleting task", http.StatusInternalServerError)
+		http.Error(w, "Task not found", http.StatusNotFound)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, deletedTask)
+	utils.WriteJSON(w, http.StatusOK, newTaskResponse(deletedTask))
 }
 
-func validateTaskPayload(task *models.Task) error {
-	if task.Title == "" {
-		return ErrTitleRequired
+func (s *TaskService) handleTaskArchive(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	archivedTask, err := s.mutate(r.Context(), models.TaskUpdated, func(tx store.Store) (*models.Task, error) {
+		return tx.ArchiveTask(taskID, userID)
+	})
+	if err != nil {
+		http.Error(w, "Error archiving task", http.StatusInternalServerError)
+		return
+	}
+
+	if archivedTask == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newTaskResponse(archivedTask))
+}
+
+func (s *TaskService) handleTaskUnarchive(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	unarchivedTask, err := s.mutate(r.Context(), models.TaskUpdated, func(tx store.Store) (*models.Task, error) {
+		return tx.UnarchiveTask(taskID, userID)
+	})
+	if err != nil {
+		http.Error(w, "Error unarchiving task", http.StatusInternalServerError)
+		return
+	}
+
+	if unarchivedTask == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newTaskResponse(unarchivedTask))
+}
+
+func (s *TaskService) handleWorkflowGet(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, s.workflow)
+}
+
+// mutate runs fn in a transaction and, in the same transaction, notifies
+// real-time clients of the change to the task it returns.
+func (s *TaskService) mutate(ctx context.Context, eventType models.TaskEventType, fn func(tx store.Store) (*models.Task, error)) (*models.Task, error) {
+	var task *models.Task
+	err := s.store.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		task, err = fn(tx)
+		if err != nil {
+			return err
+		}
+
+		return notifyTaskEvent(tx, eventType, task)
+	})
+
+	return task, err
+}
+
+// notifyTaskEvent notifies real-time clients of a change to the task. It
+// does nothing when there is no task.
+func notifyTaskEvent(tx store.Store, eventType models.TaskEventType, task *models.Task) error {
+	if task == nil {
+		return nil
+	}
+
+	return tx.NotifyTaskEvent(&models.TaskEvent{Type: eventType, UserID: task.UserID, Task: task})
+}
+
+// statusError reports err, an invalid status, as an error of the status
+// field.
+func statusError(err error) error {
+	v := validate.New()
+	v.Add("status", err)
+	return v.Err()
+}
+
+// applyTransition resolves the requested status of task against the
+// workflow and stamps its completion time. existing is nil for new tasks.
+func (s *TaskService) applyTransition(existing *models.Task, task *models.Task) error {
+	if existing == nil {
+		if task.Status == "" {
+			task.Status = s.workflow.Initial
+		}
+		if !s.workflow.Has(task.Status) {
+			return fmt.Errorf("%w: %q", ErrInvalidStatus, task.Status)
+		}
+		if s.workflow.IsDone(task.Status) {
+			now := time.Now().UTC()
+			task.CompletedAt = &now
+		}
+		return nil
+	}
+
+	if task.Status == "" {
+		task.Status = existing.Status
+		if s.workflow.IsDone(existing.Status) {
+			task.Status = s.workflow.Initial
+		}
+	}
+	if !s.workflow.Has(task.Status) {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, task.Status)
+	}
+	if !s.workflow.CanTransition(existing.Status, task.Status) {
+		return fmt.Errorf("%w from %q to %q", ErrInvalidTransition, existing.Status, task.Status)
+	}
+
+	switch {
+	case !s.workflow.IsDone(task.Status):
+		task.CompletedAt = nil
+	case s.workflow.IsDone(existing.Status) && existing.CompletedAt != nil:
+		task.CompletedAt = existing.CompletedAt
+	default:
+		now := time.Now().UTC()
+		task.CompletedAt = &now
 	}
 
 	return nil
 }
+
+// validateTaskPayload normalizes the text of the task and checks its
+// fields.
+func validateTaskPayload(task *models.Task) error {
+	task.Title = validate.Normalize(task.Title)
+	task.Description = validate.Normalize(task.Description)
+
+	v := validate.New()
+	v.Check("title", task.Title != "", ErrTitleRequired)
+	v.MaxLength("title", task.Title, maxTitleLength)
+	v.MaxLength("description", task.Description, maxDescriptionLength)
+
+	p := task.Priority
+	v.Check("priority", p == "" || (len(p) == 1 && p[0] >= 'A' && p[0] <= 'Z'), ErrInvalidPriority)
+
+	return v.Err()
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/types"
+)
+
+func TestTaskOtherUsersTasks(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	other := otherUserTask(t, st)
+	mux := newTestRouter(st, NewTaskService(st))
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{"GET", "/tasks/%d", ""},
+		{"PUT", "/tasks/%d", `{"title": "Hijacked", "status": "done"}`},
+		{"DELETE", "/tasks/%d", ""},
+		{"POST", "/tasks/%d/archive", ""},
+		{"POST", "/tasks/%d/unarchive", ""},
+	} {
+		path := fmt.Sprintf(tc.path, other.ID)
+		req := httptest.NewRequest(tc.method, path, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s %s: got %d %s", tc.method, path, rr.Code, rr.Body)
+		}
+	}
+
+	assertTaskUnchanged(t, st, other)
+}
+
+func TestTaskDeleteNotFound(t *testing.T) {
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewTaskService(st))
+
+	req := httptest.NewRequest("DELETE", "/tasks/42", nil)
+	req.Header.Set("Authorization", testToken(t))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound || strings.TrimSpace(rr.Body.String()) != "Task not found" {
+		t.Errorf("got %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestTaskArchive(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	task, err := st.CreateTask(&models.Task{UserID: 1, Title: "Learn Golang", Status: models.StatusTodo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestRouter(st, NewTaskService(st))
+
+	do := func(method, path string) (int, TaskResponse) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var resp TaskResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+	listed := func(path string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var tasks []TaskResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("GET %s: %v: %s", path, err, rr.Body)
+		}
+		return len(tasks)
+	}
+
+	code, resp := do("POST", fmt.Sprintf("/tasks/%d/archive", task.ID))
+	if code != http.StatusOK || resp.ArchivedAt == nil {
+		t.Fatalf("archive: got %d %+v", code, resp)
+	}
+	if n := listed("/tasks"); n != 0 {
+		t.Errorf("archived task listed in GET /tasks: %d tasks", n)
+	}
+	if n := listed("/tasks?archived=true"); n != 1 {
+		t.Errorf("GET /tasks?archived=true: %d tasks, want 1", n)
+	}
+
+	code, resp = do("POST", fmt.Sprintf("/tasks/%d/unarchive", task.ID))
+	if code != http.StatusOK || resp.ArchivedAt != nil {
+		t.Fatalf("unarchive: got %d %+v", code, resp)
+	}
+	if n := listed("/tasks"); n != 1 {
+		t.Errorf("unarchived task not listed in GET /tasks: %d tasks", n)
+	}
+
+	for _, path := range []string{"/tasks/42/archive", "/tasks/42/unarchive"} {
+		if code, _ := do("POST", path); code != http.StatusNotFound {
+			t.Errorf("POST %s: got %d", path, code)
+		}
+	}
+}
+
+func TestSettings(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewUserService(st, testConfig()))
+
+	do := func(method, body string) (int, models.UserSettings) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/users/me/settings", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var settings models.UserSettings
+		json.Unmarshal(rr.Body.Bytes(), &settings)
+		return rr.Code, settings
+	}
+
+	if code, settings := do("GET", ""); code != http.StatusOK || settings.AutoArchiveDays != 0 {
+		t.Errorf("GET defaults: got %d %+v", code, settings)
+	}
+	if code, settings := do("PUT", `{"auto_archive_days": 7}`); code != http.StatusOK || settings.AutoArchiveDays != 7 {
+		t.Errorf("PUT: got %d %+v", code, settings)
+	}
+	if code, settings := do("GET", ""); code != http.StatusOK || settings.AutoArchiveDays != 7 {
+		t.Errorf("GET after PUT: got %d %+v", code, settings)
+	}
+
+	for _, body := range []string{
+		`{"auto_archive_days": -1}`,
+		fmt.Sprintf(`{"auto_archive_days": %d}`, maxAutoArchiveDays+1),
+		`{"auto_archive_days": "weekly"}`,
+	} {
+		if code, _ := do("PUT", body); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: got %d", body, code)
+		}
+	}
+	if _, settings := do("GET", ""); settings.AutoArchiveDays != 7 {
+		t.Errorf("invalid PUT changed the settings: %+v", settings)
+	}
+}
+
+func TestArchiveCompletedTasks(t *testing.T) {
+	st := store.NewMockStore()
+	if _, err := st.UpdateUserSettings("1", &models.UserSettings{AutoArchiveDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	other := otherUserTask(t, st)
+
+	ago := func(days int) *time.Time {
+		at := time.Now().AddDate(0, 0, -days)
+		return &at
+	}
+	for _, task := range []*models.Task{
+		{UserID: 1, Title: "Completed long ago", Status: models.StatusDone, CompletedAt: ago(8)},
+		{UserID: 1, Title: "Completed recently", Status: models.StatusDone, CompletedAt: ago(1)},
+		{UserID: 1, Title: "Never completed", Status: models.StatusTodo},
+		{UserID: other.UserID, Title: "Auto-archive disabled", Status: models.StatusDone, CompletedAt: ago(30)},
+	} {
+		if _, err := st.CreateTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archived, err := st.ArchiveCompletedTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].Title != "Completed long ago" || archived[0].ArchivedAt == nil {
+		t.Fatalf("got %+v, want the task completed long ago", archived)
+	}
+
+	// Running the job again archives nothing more.
+	if archived, err := st.ArchiveCompletedTasks(); err != nil || len(archived) != 0 {
+		t.Errorf("second run: got %+v, %v", archived, err)
+	}
+}
+
+func TestTaskStatusTransitions(t *testing.T) {
+	token := testToken(t)
+	completedAt := time.Date(2024, 4, 20, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name   string
+		from   models.TaskStatus
+		status string
+		code   int
+		want   models.TaskStatus
+		done   bool
+	}{
+		{"start", models.StatusTodo, `"in_progress"`, http.StatusOK, models.StatusInProgress, false},
+		{"complete", models.StatusTodo, `"done"`, http.StatusOK, models.StatusDone, true},
+		{"block", models.StatusInProgress, `"blocked"`, http.StatusOK, models.StatusBlocked, false},
+		{"give up", models.StatusBlocked, `"wont_do"`, http.StatusOK, models.StatusWontDo, true},
+		{"reopen", models.StatusDone, `"todo"`, http.StatusOK, models.StatusTodo, false},
+		{"keep done", models.StatusDone, `"done"`, http.StatusOK, models.StatusDone, true},
+		{"omitted", models.StatusInProgress, "", http.StatusOK, models.StatusInProgress, false},
+		{"legacy true", models.StatusTodo, `true`, http.StatusOK, models.StatusDone, true},
+		{"legacy false reopens", models.StatusDone, `false`, http.StatusOK, models.StatusTodo, false},
+		{"legacy false keeps open status", models.StatusInProgress, `false`, http.StatusOK, models.StatusInProgress, false},
+		{"blocked to done", models.StatusBlocked, `"done"`, http.StatusUnprocessableEntity, models.StatusBlocked, false},
+		{"wont do to done", models.StatusWontDo, `true`, http.StatusUnprocessableEntity, models.StatusWontDo, true},
+		{"unknown status", models.StatusTodo, `"finished"`, http.StatusBadRequest, models.StatusTodo, false},
+		{"invalid status", models.StatusTodo, `1`, http.StatusBadRequest, models.StatusTodo, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st := store.NewMockStore()
+			task := &models.Task{UserID: 1, Title: "Learn Golang", Status: tc.from}
+			if models.DefaultWorkflow.IsDone(tc.from) {
+				task.CompletedAt = &completedAt
+			}
+			if _, err := st.CreateTask(task); err != nil {
+				t.Fatal(err)
+			}
+			mux := newTestRouter(st, NewTaskService(st))
+
+			body := `{"title": "Learn Golang"}`
+			if tc.status != "" {
+				body = `{"title": "Learn Golang", "status": ` + tc.status + `}`
+			}
+			req := httptest.NewRequest("PUT", "/tasks/1", strings.NewReader(body))
+			req.Header.Set("Authorization", token)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Fatalf("got %d %s, want %d", rr.Code, rr.Body, tc.code)
+			}
+
+			stored, _ := st.GetTaskByID("1", 1)
+			if stored.Status != tc.want || (stored.CompletedAt != nil) != tc.done {
+				t.Errorf("got status %q completed at %v, want %q", stored.Status, stored.CompletedAt, tc.want)
+			}
+			// Staying done keeps the time of completion.
+			if models.DefaultWorkflow.IsDone(tc.from) && tc.done && !stored.CompletedAt.Equal(completedAt) {
+				t.Errorf("completed at %v, want %v", stored.CompletedAt, completedAt)
+			}
+		})
+	}
+}
+
+func TestTaskCreateStatus(t *testing.T) {
+	token := testToken(t)
+
+	for _, tc := range []struct {
+		status string
+		code   int
+		want   models.TaskStatus
+	}{
+		{"", http.StatusCreated, models.StatusTodo},
+		{`"blocked"`, http.StatusCreated, models.StatusBlocked},
+		{`"wont_do"`, http.StatusCreated, models.StatusWontDo},
+		{`true`, http.StatusCreated, models.StatusDone},
+		{`false`, http.StatusCreated, models.StatusTodo},
+		{`"finished"`, http.StatusBadRequest, ""},
+	} {
+		st := store.NewMockStore()
+		mux := newTestRouter(st, NewTaskService(st))
+
+		body := `{"title": "Learn Golang"}`
+		if tc.status != "" {
+			body = `{"title": "Learn Golang", "status": ` + tc.status + `}`
+		}
+		req := httptest.NewRequest("POST", "/tasks", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != tc.code {
+			t.Errorf("status %s: got %d %s", tc.status, rr.Code, rr.Body)
+			continue
+		}
+		if tc.code != http.StatusCreated {
+			var resp types.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp.Fields) != 1 || resp.Fields[0].Field != "status" {
+				t.Errorf("status %s: got %s, want an error of the status field", tc.status, rr.Body)
+			}
+			continue
+		}
+
+		var resp TaskResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if resp.Status != tc.want || (resp.CompletedAt != nil) != models.DefaultWorkflow.IsDone(tc.want) {
+			t.Errorf("status %s: got %s", tc.status, rr.Body)
+		}
+	}
+}
@@ -5,63 +5,174 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/config"
+	"github.com/hsrvms/todoapp/lockout"
 	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
 	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/types"
 	"github.com/hsrvms/todoapp/utils"
+	"github.com/hsrvms/todoapp/validate"
 )
 
 var ErrUsernameRequired = errors.New("username is required")
 var ErrPasswordRequired = errors.New("password is required")
+var ErrPasswordIsUsername = errors.New("password must differ from the username")
+var ErrInvalidAutoArchiveDays = fmt.Errorf("auto_archive_days must be between 0 and %d", maxAutoArchiveDays)
+
+const maxAutoArchiveDays = 3650
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+	// maxPasswordBytes is the length bcrypt hashes passwords to. Longer
+	// passwords are rejected rather than silently truncated.
+	maxPasswordBytes = 72
+)
+
+// userLockoutPolicy locks out usernames failing to log in, whether or not
+// they have an account, so that lockouts do not reveal which do.
+var userLockoutPolicy = lockout.Policy{
+	Threshold: 5,
+	BaseDelay: time.Minute,
+	MaxDelay:  time.Hour,
+	Window:    time.Hour,
+}
+
+// ipLockoutPolicy locks out client addresses failing to log in. It allows
+// more failures than userLockoutPolicy, since many users may share an
+// address, but catches guessing the passwords of many usernames.
+var ipLockoutPolicy = lockout.Policy{
+	Threshold: 20,
+	BaseDelay: time.Minute,
+	MaxDelay:  time.Hour,
+	Window:    time.Hour,
+}
 
 type UserService struct {
-	store store.Store
+	store      store.Store
+	jwtSecret  []byte
+	admins     []string
+	userLogins *lockout.Tracker
+	ipLogins   *lockout.Tracker
+}
+
+// NewUserService creates a UserService signing session tokens with the
+// configured JWT secret.
+func NewUserService(store store.Store, cfg *config.Config) *UserService {
+	return &UserService{
+		store:      store,
+		jwtSecret:  []byte(cfg.JWTSecret),
+		admins:     cfg.AdminUsers,
+		userLogins: lockout.New(userLockoutPolicy),
+		ipLogins:   lockout.New(ipLockoutPolicy),
+	}
+}
+
+// CredentialsRequest is the payload of POST /auth/register and
+// POST /auth/login.
+type CredentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserResponse is an account as served by the API, without its password.
+type UserResponse struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
-func NewUserService(store store.Store) *UserService {
-	return &UserService{store: store}
+func newUserResponse(user *models.User) *UserResponse {
+	return &UserResponse{ID: user.ID, Username: user.Username, CreatedAt: user.CreatedAt}
 }
 
 // POST /auth/register:
 //
+// Usernames are 3 to 32 ASCII letters, digits, ".", "_" or "-". Passwords
+// are 8 characters to 72 bytes long and differ from the username.
+//
 // Payload:
 //
 //	{"username": "johnDoe", "password": "secretPassword"}
 //
 // POST /auth/login:
 //
+// Unknown usernames and wrong passwords fail alike with 401. After 5 failed
+// attempts for a username, or 20 from a client address, logins are refused
+// with 429 and a Retry-After header for a minute, doubling with every
+// further failure up to an hour.
+//
 // Payload:
 //
 //	{"username": "johnDoe", "password": "secretPassword"}
-func (s *UserService) RegisterRoutes(mux *http.ServeMux, prefix string) {
-	endpointRegister := generateEndpoint("POST", prefix, "/auth/register")
-	endpointLogin := generateEndpoint("POST", prefix, "/auth/login")
+//
+// GET /users/me:
+//
+// Response:
+//
+//	{"id": 1, "username": "johnDoe", "created_at": "2024-04-12T18:02:27.924693Z"}
+//
+// GET /users/me/settings:
+//
+// Response:
+//
+//	{"auto_archive_days": 30}
+//
+// PUT /users/me/settings:
+//
+// Payload:
+//
+//	{"auto_archive_days": 30}
+//
+// POST /admin/users/{username}/unlock:
+//
+// Lifts the login lockout of the username. Only the configured admin users
+// (admin_users, or ADMIN_USERS separated by commas) may unlock.
+//
+// POST /admin/ips/{ip}/unlock:
+//
+// Lifts the login lockout of the client address.
+func (s *UserService) RegisterRoutes(api *router.Group) {
+	api.Public().HandleFunc("POST /auth/register", s.handleUserRegister)
+	api.Public().HandleFunc("POST /auth/login", s.handleUserLogin)
+	api.HandleFunc("GET /users/me", s.handleUserGetMe)
+	api.HandleFunc("GET /users/me/settings", s.handleSettingsGet)
+	api.HandleFunc("PUT /users/me/settings", s.handleSettingsUpdate)
 
-	mux.HandleFunc(endpointRegister, s.handleUserRegister)
-	mux.HandleFunc(endpointLogin, s.handleUserLogin)
+	admin := api.Group("/admin", s.requireAdmin)
+	admin.HandleFunc("POST /users/{username}/unlock", s.handleUnlockUser)
+	admin.HandleFunc("POST /ips/{ip}/unlock", s.handleUnlockIP)
 }
 
 func (s *UserService) handleUserRegister(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := decodeJSON(r, &user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	var req CredentialsRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
 		return
 	}
 
-	if err := validateUserPayload(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := validateNewUserPayload(&req); err != nil {
+		writeInvalidPayload(w, err)
 		return
 	}
 
-	hashedPW, err := hashPassword(user.Password)
+	hashedPW, err := hashPassword(req.Password)
 	if err != nil {
 		http.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
-	user.Password = hashedPW
 
-	createdUser, err := s.store.CreateUser(&user)
+	createdUser, err := s.store.CreateUser(&models.User{Username: req.Username, Password: hashedPW})
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Error creating user", http.StatusInternalServerError)
@@ -73,7 +184,7 @@ func (s *UserService) handleUserRegister(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := createAndSetAuthCookie(createdUser.ID, w)
+	token, err := createAndSetAuthCookie(s.jwtSecret, createdUser.ID, w)
 	if err != nil {
 		http.Error(w, "Error creating session", http.StatusInternalServerError)
 		return
@@ -83,30 +194,35 @@ func (s *UserService) handleUserRegister(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *UserService) handleUserLogin(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := decodeJSON(r, &user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	var user CredentialsRequest
+	if err := decodeJSON(w, r, &user); err != nil {
+		writeInvalidPayload(w, err)
 		return
 	}
 
 	if err := validateUserPayload(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	existingUser, wait, err := s.authenticate(r, user.Username, user.Password)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error retrieving user", http.StatusInternalServerError)
 		return
 	}
 
-	existingUser, err := s.store.GetUserByUsername(user.Username)
-	if err != nil || existingUser == nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	if wait > 0 {
+		tooManyLoginAttempts(w, wait)
 		return
 	}
-	fmt.Printf("existingUser: %v \n newUser: %v\n", existingUser, user)
-	match := checkPasswordHash(user.Password, existingUser.Password)
-	if !match {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+
+	if existingUser == nil {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := createAndSetAuthCookie(existingUser.ID, w)
+	token, err := createAndSetAuthCookie(s.jwtSecret, existingUser.ID, w)
 	if err != nil {
 		http.Error(w, "Error creating session", http.StatusInternalServerError)
 		return
@@ -118,14 +234,221 @@ func (s *UserService) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func validateUserPayload(user *models.User) error {
+// authenticate checks the password of the user with the username. It
+// returns nil if the username or the password is wrong, and how long the
+// client must wait if the username or its address failed too often.
+// Failures count against both.
+func (s *UserService) authenticate(r *http.Request, username, password string) (*models.User, time.Duration, error) {
+	// The attempt is counted as failed before the password is checked, so
+	// that concurrent guesses cannot all get past the lockouts.
+	ip := clientIP(r)
+	if wait := s.ipLogins.Reserve(ip); wait > 0 {
+		return nil, wait, nil
+	}
+	if wait := s.userLogins.Reserve(username); wait > 0 {
+		s.ipLogins.Release(ip)
+		return nil, wait, nil
+	}
+
+	user, err := s.store.GetUserByUsername(username)
+	if err != nil {
+		s.userLogins.Release(username)
+		s.ipLogins.Release(ip)
+		return nil, 0, err
+	}
+
+	// Unknown usernames are checked against a dummy hash, so that they take
+	// as long to fail as wrong passwords.
+	hash := dummyPasswordHash()
+	if user != nil {
+		hash = user.Password
+	}
+	if !checkPasswordHash(password, hash) || user == nil {
+		return nil, 0, nil
+	}
+	// Only this attempt is released for the address, or a user could clear
+	// the failures of guesses from their address by logging in to their
+	// own account.
+	s.userLogins.Reset(username)
+	s.ipLogins.Release(ip)
+	return user, 0, nil
+}
+
+// BasicMiddleware authenticates requests with HTTP Basic authentication
+// against the user's username and password, for clients such as calendar
+// apps that cannot log in for a JWT. Failures count towards the lockouts
+// of logins. The user ID is available to the handlers like with
+// auth.JWTMiddleware.
+func (s *UserService) BasicMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		username = validate.Normalize(username)
+		if !ok || username == "" {
+			basicAuthRequired(w)
+			return
+		}
+
+		user, wait, err := s.authenticate(r, username, password)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+			return
+		}
+
+		if wait > 0 {
+			tooManyLoginAttempts(w, wait)
+			return
+		}
+
+		if user == nil {
+			basicAuthRequired(w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithUserID(r.Context(), user.ID)))
+	})
+}
+
+func basicAuthRequired(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="todoapp", charset="UTF-8"`)
+	utils.WriteJSON(w, http.StatusUnauthorized, types.ErrorResponse{Error: "Permission denied"})
+}
+
+func tooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+}
+
+// requireAdmin only lets the configured administrators through
+// to next. It must run after the authentication of the user.
+func (s *UserService) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := auth.GetUserIDFromContext(r.Context())
+
+		user, err := s.store.GetUserByID(strconv.FormatInt(userID, 10))
+		if err != nil {
+			http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+			return
+		}
+
+		if user == nil || !slices.Contains(s.admins, user.Username) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *UserService) handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	s.userLogins.Reset(r.PathValue("username"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *UserService) handleUnlockIP(w http.ResponseWriter, r *http.Request) {
+	s.ipLogins.Reset(r.PathValue("ip"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *UserService) handleUserGetMe(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	user, err := s.store.GetUserByID(strconv.FormatInt(userID, 10))
+	if err != nil {
+		http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+		return
+	}
+
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+func (s *UserService) handleSettingsGet(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	settings, err := s.store.GetUserSettings(strconv.FormatInt(userID, 10))
+	if err != nil {
+		http.Error(w, "Error retrieving settings", http.StatusInternalServerError)
+		return
+	}
+
+	if settings == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, settings)
+}
+
+func (s *UserService) handleSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	var settings models.UserSettings
+	if err := decodeJSON(w, r, &settings); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	v := validate.New()
+	v.Check("auto_archive_days", settings.AutoArchiveDays >= 0 && settings.AutoArchiveDays <= maxAutoArchiveDays, ErrInvalidAutoArchiveDays)
+	if err := v.Err(); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	updatedSettings, err := s.store.UpdateUserSettings(strconv.FormatInt(userID, 10), &settings)
+	if err != nil {
+		http.Error(w, "Error updating settings", http.StatusInternalServerError)
+		return
+	}
+
+	if updatedSettings == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updatedSettings)
+}
+
+// validateUserPayload checks the credentials of a login. Accounts created
+// before the rules of validateNewUserPayload can still log in.
+func validateUserPayload(user *CredentialsRequest) error {
+	user.Username = validate.Normalize(user.Username)
+
+	v := validate.New()
+	v.Check("username", user.Username != "", ErrUsernameRequired)
+	v.Check("password", user.Password != "", ErrPasswordRequired)
+	return v.Err()
+}
+
+// validateNewUserPayload checks the credentials of an account to create.
+func validateNewUserPayload(user *CredentialsRequest) error {
+	user.Username = validate.Normalize(user.Username)
+
+	v := validate.New()
 	if user.Username == "" {
-		return ErrUsernameRequired
+		v.Add("username", ErrUsernameRequired)
+	} else {
+		v.MinLength("username", user.Username, minUsernameLength)
+		v.MaxLength("username", user.Username, maxUsernameLength)
+		v.Chars("username", user.Username, isUsernameChar, `ASCII letters, digits, ".", "_" and "-"`)
 	}
 
 	if user.Password == "" {
-		return ErrPasswordRequired
+		v.Add("password", ErrPasswordRequired)
+	} else {
+		v.MinLength("password", user.Password, minPasswordLength)
+		v.MaxBytes("password", user.Password, maxPasswordBytes)
+		v.Check("password", !strings.EqualFold(user.Password, user.Username), ErrPasswordIsUsername)
 	}
 
-	return nil
+	return v.Err()
+}
+
+func isUsernameChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '.' || r == '_' || r == '-'
 }
@@ -5,29 +5,31 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/hsrvms/todoapp/models"
 	"github.com/hsrvms/todoapp/store"
 )
 
 func TestRegisterUser(t *testing.T) {
 	testCases := []struct {
 		name     string
-		payload  *models.User
+		payload  *CredentialsRequest
 		expCode  int
 		expError error
 	}{
 		{
 			name: "empty username",
-			payload: &models.User{
+			payload: &CredentialsRequest{
 				Username: "",
 			},
 			expCode: http.StatusBadRequest,
 		},
 		{
 			name: "empty password",
-			payload: &models.User{
+			payload: &CredentialsRequest{
 				Username: "testUser",
 				Password: "",
 			},
@@ -35,7 +37,7 @@ func TestRegisterUser(t *testing.T) {
 		},
 		{
 			name: "valid user",
-			payload: &models.User{
+			payload: &CredentialsRequest{
 				Username: "testUserRegister",
 				Password: "testPassword",
 			},
@@ -46,7 +48,7 @@ func TestRegisterUser(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			ms := &store.MockStore{}
-			service := NewUserService(ms)
+			service := NewUserService(ms, testConfig())
 
 			if service == nil {
 				t.Fatal("failed to create UserService")
@@ -63,8 +65,7 @@ func TestRegisterUser(t *testing.T) {
 			}
 			res := httptest.NewRecorder()
 
-			mux := http.NewServeMux()
-			service.RegisterRoutes(mux, "")
+			mux := newTestRouter(ms, service)
 
 			if mux == nil {
 				t.Fatal("failed to create ServeMux")
@@ -83,20 +84,20 @@ func TestRegisterUser(t *testing.T) {
 func TestLoginUser(t *testing.T) {
 	for _, tc := range []struct {
 		name     string
-		payload  *models.User
+		payload  *CredentialsRequest
 		expCode  int
 		expError error
 	}{
 		{
 			name: "empty username",
-			payload: &models.User{
+			payload: &CredentialsRequest{
 				Username: "",
 			},
 			expCode: http.StatusBadRequest,
 		},
 		{
 			name: "empty password",
-			payload: &models.User{
+			payload: &CredentialsRequest{
 				Username: "testUser",
 				Password: "",
 			},
@@ -105,7 +106,7 @@ func TestLoginUser(t *testing.T) {
 		{
 			// TODO: This doesn't work so far. Need to figure out why.
 			name: "valid user",
-			payload: &models.User{
+			payload: &CredentialsRequest{
 				Username: "testUserLogin",
 				Password: "testPassword",
 			},
@@ -114,7 +115,7 @@ func TestLoginUser(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			ms := store.NewMockStore()
-			service := NewUserService(ms)
+			service := NewUserService(ms, testConfig())
 			if service == nil {
 				t.Fatal("failed to create UserService")
 			}
@@ -130,8 +131,7 @@ func TestLoginUser(t *testing.T) {
 			}
 			res := httptest.NewRecorder()
 
-			mux := http.NewServeMux()
-			service.RegisterRoutes(mux, "")
+			mux := newTestRouter(ms, service)
 			if mux == nil {
 				t.Fatal("failed to create ServeMux")
 			}
@@ -144,3 +144,125 @@ func TestLoginUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMe(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewUserService(st, testConfig()))
+
+	req := httptest.NewRequest("GET", "/users/me", nil)
+	req.Header.Set("Authorization", token)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d want %d", rr.Code, http.StatusOK)
+	}
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp["password"]; ok || string(resp["id"]) != "1" {
+		t.Errorf("got %s", rr.Body)
+	}
+	var createdAt time.Time
+	if err := json.Unmarshal(resp["created_at"], &createdAt); err != nil {
+		t.Errorf("created_at is not RFC 3339: %v", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	// The requests are made by token, the user of store.NewMockStore and an
+	// admin, unless changed.
+	token := testToken(t)
+	st := store.NewMockStore()
+	cfg := testConfig()
+	cfg.AdminUsers = []string{"admin", "testUserLogin"}
+	mux := newTestRouter(st, NewUserService(st, cfg))
+
+	do := func(target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		t.Helper()
+		return do("/auth/login", `{"username": "janeDoe", "password": "`+password+`"}`)
+	}
+
+	register := do("/auth/register", `{"username": "janeDoe", "password": "secretPassword"}`)
+	var janeToken string
+	if register.Code != http.StatusCreated || json.Unmarshal(register.Body.Bytes(), &janeToken) != nil {
+		t.Fatalf("register: got %d %s", register.Code, register.Body)
+	}
+
+	// Unknown usernames and wrong passwords are told apart by neither status
+	// nor message.
+	unknown := do("/auth/login", `{"username": "nobody", "password": "secretPassword"}`)
+	wrong := login("wrongPassword")
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != unknown.Code || wrong.Body.String() != unknown.Body.String() {
+		t.Errorf("unknown user: %d %q, wrong password: %d %q", unknown.Code, unknown.Body, wrong.Code, wrong.Body)
+	}
+
+	for i := 1; i < userLockoutPolicy.Threshold; i++ {
+		login("wrongPassword")
+	}
+	rr := login("secretPassword")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("locked out: got %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	adminToken := token
+	token = janeToken
+	if rr := do("/admin/users/janeDoe/unlock", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("unlock by a non-admin: got %d", rr.Code)
+	}
+	token = adminToken
+	if rr := do("/admin/users/janeDoe/unlock", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("unlock by an admin: got %d", rr.Code)
+	}
+	if rr := login("secretPassword"); rr.Code != http.StatusOK {
+		t.Errorf("login after unlock: got %d", rr.Code)
+	}
+}
+
+func TestLoginLockoutConcurrent(t *testing.T) {
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewUserService(st, testConfig()))
+
+	do := func(target, body string) int {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := do("/auth/register", `{"username": "janeDoe", "password": "secretPassword"}`); code != http.StatusCreated {
+		t.Fatalf("register: got %d", code)
+	}
+
+	// Guesses made at once are counted before any of them is checked, so
+	// only the threshold of them get a password check.
+	const guesses = 15
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- do("/auth/login", `{"username": "janeDoe", "password": "wrongPassword"}`)
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusUnauthorized] != userLockoutPolicy.Threshold || counts[http.StatusTooManyRequests] != guesses-userLockoutPolicy.Threshold {
+		t.Errorf("got %v, want %d checked", counts, userLockoutPolicy.Threshold)
+	}
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/query"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/utils"
+	"github.com/hsrvms/todoapp/validate"
+)
+
+var ErrViewNameRequired = errors.New("name is required")
+var ErrViewQueryRequired = errors.New("query is required")
+
+// maxViewTasks caps the number of tasks returned when evaluating a view.
+const maxViewTasks = 500
+
+const (
+	maxViewNameLength  = 255
+	maxViewQueryLength = 1000
+)
+
+type ViewService struct {
+	store    store.Store
+	workflow models.Workflow
+}
+
+func NewViewService(store store.Store) *ViewService {
+	return &ViewService{store: store, workflow: models.DefaultWorkflow}
+}
+
+// ViewRequest is the payload of POST /views and PUT /views/{id}. The ID,
+// owner and creation time of a view are set by the server.
+type ViewRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+func (req *ViewRequest) view() *models.SavedView {
+	return &models.SavedView{Name: req.Name, Query: req.Query}
+}
+
+// ViewResponse is a saved view as served by the API.
+type ViewResponse struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newViewResponse(view *models.SavedView) *ViewResponse {
+	return &ViewResponse{
+		ID:        view.ID,
+		UserID:    view.UserID,
+		Name:      view.Name,
+		Query:     view.Query,
+		CreatedAt: view.CreatedAt,
+	}
+}
+
+func newViewResponses(views []*models.SavedView) []*ViewResponse {
+	resp := make([]*ViewResponse, len(views))
+	for i, view := range views {
+		resp[i] = newViewResponse(view)
+	}
+	return resp
+}
+
+// Saved views are named queries written in the query language of package
+// query, e.g. "status:open created>30d".
+//
+// # POST /views:
+//
+// Payload:
+//
+//	{"name": "Stale", "query": "status:open created>30d"}
+//
+// Response:
+//
+//	{
+//	 "id": 1,
+//	 "user_id": 1,
+//	 "name": "Stale",
+//	 "query": "status:open created>30d",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
+//	}
+//
+// # GET /views:
+//
+// Response: the caller's views ordered by name.
+//
+// # GET /views/{id}:
+//
+// Response: the view.
+//
+// # PUT /views/{id}:
+//
+// Payload:
+//
+//	{"name": "Stale", "query": "status:open created>60d"}
+//
+// Response: the updated view.
+//
+// # DELETE /views/{id}:
+//
+// Response: the deleted view.
+//
+// # GET /views/{id}/tasks:
+//
+// Response: the tasks matched by the view's query, evaluated now, in the
+// same format as GET /tasks.
+func (s *ViewService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("POST /views", s.handleViewCreate)
+	api.HandleFunc("GET /views", s.handleViewGetAll)
+	api.HandleFunc("GET /views/{id}", s.handleViewGetByID)
+	api.HandleFunc("PUT /views/{id}", s.handleViewUpdate)
+	api.HandleFunc("DELETE /views/{id}", s.handleViewDelete)
+	api.HandleFunc("GET /views/{id}/tasks", s.handleViewTasks)
+}
+
+func (s *ViewService) handleViewCreate(w http.ResponseWriter, r *http.Request) {
+	var req ViewRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	view := req.view()
+	if err := s.validateViewPayload(view); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+	view.UserID, _ = auth.GetUserIDFromContext(r.Context())
+
+	createdView, err := s.store.CreateSavedView(view)
+	if err != nil {
+		http.Error(w, "Error creating view", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, newViewResponse(createdView))
+}
+
+func (s *ViewService) handleViewGetAll(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	views, err := s.store.GetSavedViews(strconv.FormatInt(userID, 10))
+	if err != nil {
+		http.Error(w, "Error retrieving views", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newViewResponses(views))
+}
+
+func (s *ViewService) handleViewGetByID(w http.ResponseWriter, r *http.Request) {
+	view, ok := s.getOwnView(w, r)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newViewResponse(view))
+}
+
+func (s *ViewService) handleViewUpdate(w http.ResponseWriter, r *http.Request) {
+	var req ViewRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	view := req.view()
+	if err := s.validateViewPayload(view); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	existingView, ok := s.getOwnView(w, r)
+	if !ok {
+		return
+	}
+
+	updatedView, err := s.store.UpdateSavedView(strconv.FormatInt(existingView.ID, 10), view)
+	if err != nil {
+		http.Error(w, "Error updating view", http.StatusInternalServerError)
+		return
+	}
+
+	if updatedView == nil {
+		http.Error(w, "View not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newViewResponse(updatedView))
+}
+
+func (s *ViewService) handleViewDelete(w http.ResponseWriter, r *http.Request) {
+	existingView, ok := s.getOwnView(w, r)
+	if !ok {
+		return
+	}
+
+	deletedView, err := s.store.DeleteSavedView(strconv.FormatInt(existingView.ID, 10))
+	if err != nil {
+		http.Error(w, "Error deleting view", http.StatusInternalServerError)
+		return
+	}
+
+	if deletedView == nil {
+		http.Error(w, "View not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newViewResponse(deletedView))
+}
+
+func (s *ViewService) handleViewTasks(w http.ResponseWriter, r *http.Request) {
+	view, ok := s.getOwnView(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := query.Parse(view.Query, s.workflow, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	q.Filter.UserID = view.UserID
+
+	results, err := findTasks(s.store, q, maxViewTasks)
+	if err != nil {
+		http.Error(w, "Error retrieving tasks", http.StatusInternalServerError)
+		return
+	}
+
+	tasks := make([]*models.Task, len(results))
+	for i, result := range results {
+		tasks[i] = result.Task
+	}
+
+	utils.WriteJSON(w, http.StatusOK, newTaskResponses(tasks))
+}
+
+// getOwnView loads the view named by the request path and checks that it
+// belongs to the caller. It writes the error response and reports false
+// when it does not.
+func (s *ViewService) getOwnView(w http.ResponseWriter, r *http.Request) (*models.SavedView, bool) {
+	viewID := r.PathValue("id")
+
+	if viewID == "" {
+		http.Error(w, "Invalid view ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	view, err := s.store.GetSavedViewByID(viewID)
+	if err != nil {
+		http.Error(w, "Error retrieving view", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	if view == nil || view.UserID != userID {
+		http.Error(w, "View not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return view, true
+}
+
+func (s *ViewService) validateViewPayload(view *models.SavedView) error {
+	view.Name = validate.Normalize(view.Name)
+	view.Query = validate.Normalize(view.Query)
+
+	v := validate.New()
+	v.Check("name", view.Name != "", ErrViewNameRequired)
+	v.MaxLength("name", view.Name, maxViewNameLength)
+
+	if view.Query == "" {
+		v.Add("query", ErrViewQueryRequired)
+	} else if _, err := query.Parse(view.Query, s.workflow, time.Now().UTC()); err != nil {
+		v.Add("query", err)
+	}
+	v.MaxLength("query", view.Query, maxViewQueryLength)
+
+	return v.Err()
+}
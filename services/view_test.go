@@ -0,0 +1,49 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestViewRejectsServerFields(t *testing.T) {
+	token := testToken(t)
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewViewService(st))
+
+	do := func(method, path, body string) (int, ViewResponse) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var resp ViewResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	code, created := do("POST", "/views", `{"name": "Stale", "query": "status:open"}`)
+	if code != http.StatusCreated || created.UserID != 1 {
+		t.Fatalf("create: got %d %+v", code, created)
+	}
+
+	// The ID, owner and creation time are set by the server.
+	for _, field := range []string{`"id": 99`, `"user_id": 2`, `"created_at": "2020-01-01T00:00:00Z"`} {
+		body := `{"name": "Open", "query": "status:open", ` + field + `}`
+		if code, _ := do("POST", "/views", body); code != http.StatusBadRequest {
+			t.Errorf("POST %s: got %d", field, code)
+		}
+		if code, _ := do("PUT", "/views/1", body); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: got %d", field, code)
+		}
+	}
+
+	if code, view := do("GET", "/views/1", ""); code != http.StatusOK || view.Name != "Stale" || view.UserID != 1 {
+		t.Errorf("view changed: got %d %+v", code, view)
+	}
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/auth"
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/router"
+	"github.com/hsrvms/todoapp/store"
+	"github.com/hsrvms/todoapp/utils"
+	"github.com/hsrvms/todoapp/validate"
+	"github.com/hsrvms/todoapp/webhooks"
+)
+
+var ErrWebhookURLRequired = errors.New("url is required")
+var ErrInvalidWebhookURL = errors.New("url must be an absolute http or https URL")
+var ErrInvalidWebhookEvent = errors.New("unknown event type")
+var ErrWebhookURLNotPublic = errors.New("url must not point to a loopback, private or link-local address")
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+
+	maxWebhookURLBytes = 2048
+)
+
+// Webhooks receive the events as GET /events streams them.
+func init() {
+	store.EncodeWebhookPayload = encodeTaskEvent
+}
+
+type WebhookService struct {
+	store store.Store
+}
+
+func NewWebhookService(store store.Store) *WebhookService {
+	return &WebhookService{store: store}
+}
+
+// WebhookRequest is the payload of POST /webhooks and PUT /webhooks/{id}.
+// The ID, owner and creation time of a webhook are set by the server.
+type WebhookRequest struct {
+	URL    string                 `json:"url"`
+	Events []models.TaskEventType `json:"events"`
+	Secret string                 `json:"secret"`
+}
+
+func (req *WebhookRequest) webhook() *models.Webhook {
+	return &models.Webhook{URL: req.URL, Events: req.Events, Secret: req.Secret}
+}
+
+// WebhookResponse is a webhook as served by the API. Secret is only set
+// when the webhook is created or its secret rotated.
+type WebhookResponse struct {
+	ID        int64                  `json:"id"`
+	UserID    int64                  `json:"user_id"`
+	URL       string                 `json:"url"`
+	Events    []models.TaskEventType `json:"events"`
+	Secret    string                 `json:"secret,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+func newWebhookResponse(hook *models.Webhook) *WebhookResponse {
+	return &WebhookResponse{
+		ID:        hook.ID,
+		UserID:    hook.UserID,
+		URL:       hook.URL,
+		Events:    hook.Events,
+		Secret:    hook.Secret,
+		CreatedAt: hook.CreatedAt,
+	}
+}
+
+// Webhooks receive the caller's task events as signed POST requests; see
+// package webhooks for the request format and signature. "events" lists
+// the event types to send and defaults to all of them. The secret is
+// generated unless given, and is only returned when it is set.
+//
+// # POST /webhooks:
+//
+// Payload:
+//
+//	{"url": "https://ci.example.com/hooks/todo", "events": ["task.created", "task.updated"]}
+//
+// Response:
+//
+//	{
+//	 "id": 1,
+//	 "user_id": 1,
+//	 "url": "https://ci.example.com/hooks/todo",
+//	 "events": ["task.created", "task.updated"],
+//	 "secret": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+//	 "created_at": "2024-04-12T18:02:27.924693Z",
+//	}
+//
+// # GET /webhooks:
+//
+// Response: the caller's webhooks without their secrets.
+//
+// # GET /webhooks/{id}:
+//
+// Response: the webhook without its secret.
+//
+// # PUT /webhooks/{id}:
+//
+// Payload: as for POST /webhooks. Giving a secret rotates it.
+//
+// Response: the updated webhook.
+//
+// # DELETE /webhooks/{id}:
+//
+// Response: the deleted webhook. Its queued deliveries are dropped.
+//
+// # GET /webhooks/{id}/deliveries?status=dead&limit=50:
+//
+// Failed deliveries are retried with exponential backoff and marked "dead"
+// after 8 attempts. status filters on "pending", "delivered" or "dead".
+//
+// Response: the latest deliveries, newest first.
+//
+//	[
+//	 {
+//		"id": 42,
+//		"webhook_id": 1,
+//		"event_type": "task.updated",
+//		"payload": {"id": 17, "type": "task.updated", "task": {...}, ...},
+//		"status": "pending",
+//		"attempts": 2,
+//		"next_attempt_at": "2024-04-12T18:06:27Z",
+//		"last_status_code": 503,
+//		"last_error": "unexpected status 503 Service Unavailable",
+//		"created_at": "2024-04-12T18:02:27Z",
+//	 },
+//	]
+//
+// # POST /webhooks/{id}/deliveries/{deliveryID}/retry:
+//
+// Queues the delivery again, typically a dead one, with a fresh set of
+// attempts.
+//
+// Response: the delivery.
+func (s *WebhookService) RegisterRoutes(api *router.Group) {
+	api.HandleFunc("POST /webhooks", s.handleWebhookCreate)
+	api.HandleFunc("GET /webhooks", s.handleWebhookGetAll)
+	api.HandleFunc("GET /webhooks/{id}", s.handleWebhookGetByID)
+	api.HandleFunc("PUT /webhooks/{id}", s.handleWebhookUpdate)
+	api.HandleFunc("DELETE /webhooks/{id}", s.handleWebhookDelete)
+	api.HandleFunc("GET /webhooks/{id}/deliveries", s.handleWebhookDeliveries)
+	api.HandleFunc("POST /webhooks/{id}/deliveries/{deliveryID}/retry", s.handleWebhookRetry)
+}
+
+func (s *WebhookService) handleWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	hook := req.webhook()
+	if err := validateWebhookPayload(hook); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+	hook.UserID, _ = auth.GetUserIDFromContext(r.Context())
+
+	if hook.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+			return
+		}
+		hook.Secret = secret
+	}
+
+	createdHook, err := s.store.CreateWebhook(hook)
+	if err != nil {
+		http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, newWebhookResponse(createdHook))
+}
+
+func (s *WebhookService) handleWebhookGetAll(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+
+	hooks, err := s.store.GetWebhooks(strconv.FormatInt(userID, 10))
+	if err != nil {
+		http.Error(w, "Error retrieving webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]*WebhookResponse, len(hooks))
+	for i, hook := range hooks {
+		hook.Secret = ""
+		resp[i] = newWebhookResponse(hook)
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *WebhookService) handleWebhookGetByID(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.getOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	hook.Secret = ""
+	utils.WriteJSON(w, http.StatusOK, newWebhookResponse(hook))
+}
+
+func (s *WebhookService) handleWebhookUpdate(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	hook := req.webhook()
+	if err := validateWebhookPayload(hook); err != nil {
+		writeInvalidPayload(w, err)
+		return
+	}
+
+	existingHook, ok := s.getOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	updatedHook, err := s.store.UpdateWebhook(strconv.FormatInt(existingHook.ID, 10), hook)
+	if err != nil {
+		http.Error(w, "Error updating webhook", http.StatusInternalServerError)
+		return
+	}
+
+	if updatedHook == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if hook.Secret == "" {
+		updatedHook.Secret = ""
+	}
+	utils.WriteJSON(w, http.StatusOK, newWebhookResponse(updatedHook))
+}
+
+func (s *WebhookService) handleWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	existingHook, ok := s.getOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deletedHook, err := s.store.DeleteWebhook(strconv.FormatInt(existingHook.ID, 10))
+	if err != nil {
+		http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+		return
+	}
+
+	if deletedHook == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	deletedHook.Secret = ""
+	utils.WriteJSON(w, http.StatusOK, newWebhookResponse(deletedHook))
+}
+
+func (s *WebhookService) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.getOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	status := models.WebhookDeliveryStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		http.Error(w, "Invalid status parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		value, err := strconv.Atoi(l)
+		if err != nil || value < 1 || value > maxDeliveryLimit {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	deliveries, err := s.store.GetWebhookDeliveries(strconv.FormatInt(hook.ID, 10), status, limit)
+	if err != nil {
+		http.Error(w, "Error retrieving deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, deliveries)
+}
+
+func (s *WebhookService) handleWebhookRetry(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.getOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID := r.PathValue("deliveryID")
+	if deliveryID == "" {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := s.store.RetryWebhookDelivery(strconv.FormatInt(hook.ID, 10), deliveryID)
+	if err != nil {
+		http.Error(w, "Error retrying delivery", http.StatusInternalServerError)
+		return
+	}
+
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, delivery)
+}
+
+// getOwnWebhook loads the webhook named by the request path and checks
+// that it belongs to the caller. It writes the error response and reports
+// false when it does not.
+func (s *WebhookService) getOwnWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	hookID := r.PathValue("id")
+
+	if hookID == "" {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	hook, err := s.store.GetWebhookByID(hookID)
+	if err != nil {
+		http.Error(w, "Error retrieving webhook", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	if hook == nil || hook.UserID != userID {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return hook, true
+}
+
+func validateWebhookPayload(hook *models.Webhook) error {
+	hook.URL = validate.Normalize(hook.URL)
+
+	v := validate.New()
+	if hook.URL == "" {
+		v.Add("url", ErrWebhookURLRequired)
+	} else if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.Add("url", ErrInvalidWebhookURL)
+	} else if !publicHost(u.Hostname()) {
+		v.Add("url", ErrWebhookURLNotPublic)
+	}
+	v.MaxBytes("url", hook.URL, maxWebhookURLBytes)
+
+	for _, event := range hook.Events {
+		switch event {
+		case models.TaskCreated, models.TaskUpdated, models.TaskDeleted:
+		default:
+			v.Add("events", ErrInvalidWebhookEvent)
+			return v.Err()
+		}
+	}
+
+	return v.Err()
+}
+
+// publicHost reports whether the host may be that of a webhook. Host names
+// are checked by the dispatcher once resolved, as they may resolve to
+// another address by the time of the delivery.
+func publicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return webhooks.PublicAddr(addr)
+	}
+	return true
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		want error
+	}{
+		{"https://example.com/hooks/todo", nil},
+		{"http://93.184.216.34:8080/hook", nil},
+		{"", ErrWebhookURLRequired},
+		{"ftp://example.com/hook", ErrInvalidWebhookURL},
+		{"/hook", ErrInvalidWebhookURL},
+		{"http://localhost:8080/hook", ErrWebhookURLNotPublic},
+		{"http://api.LOCALHOST./hook", ErrWebhookURLNotPublic},
+		{"http://127.0.0.1/hook", ErrWebhookURLNotPublic},
+		{"http://[::1]/hook", ErrWebhookURLNotPublic},
+		{"http://10.0.0.5/hook", ErrWebhookURLNotPublic},
+		{"http://192.168.1.1/hook", ErrWebhookURLNotPublic},
+		{"http://169.254.169.254/latest/meta-data/", ErrWebhookURLNotPublic},
+		{"http://[fd00:ec2::254]/hook", ErrWebhookURLNotPublic},
+		{"http://[::ffff:10.0.0.5]/hook", ErrWebhookURLNotPublic},
+	} {
+		err := validateWebhookPayload(&models.Webhook{URL: tc.url})
+		if (tc.want == nil) != (err == nil) || tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.url, err, tc.want)
+		}
+	}
+}
+
+func TestWebhookCreateRejectsPrivateURL(t *testing.T) {
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewWebhookService(st))
+
+	req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url": "http://169.254.169.254/latest/meta-data/"}`))
+	req.Header.Set("Authorization", testToken(t))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), ErrWebhookURLNotPublic.Error()) {
+		t.Errorf("got %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestWebhookRejectsServerFields(t *testing.T) {
+	st := store.NewMockStore()
+	mux := newTestRouter(st, NewWebhookService(st))
+
+	// The ID, owner and creation time are set by the server.
+	for _, field := range []string{`"id": 99`, `"user_id": 2`, `"created_at": "2020-01-01T00:00:00Z"`} {
+		req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url": "https://example.com/hook", `+field+`}`))
+		req.Header.Set("Authorization", testToken(t))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "unknown field") {
+			t.Errorf("%s: got %d %s", field, rr.Code, rr.Body)
+		}
+	}
+
+	hooks, _ := st.GetWebhooks("1")
+	other, _ := st.GetWebhooks("2")
+	if len(hooks) != 0 || len(other) != 0 {
+		t.Errorf("created %d webhooks", len(hooks)+len(other))
+	}
+}
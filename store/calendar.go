@@ -0,0 +1,64 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+// SetCalendarToken stores the hash of the user's calendar feed token. An
+// empty hash revokes the feed.
+func (r *Repository) SetCalendarToken(userID int64, tokenHash string) error {
+	_, err := r.db.Exec("UPDATE users SET calendar_token = NULLIF($1, '') WHERE id = $2", tokenHash, userID)
+	return err
+}
+
+// GetUserByCalendarToken returns the user whose calendar feed token has
+// the hash, or nil.
+func (r *Repository) GetUserByCalendarToken(tokenHash string) (*models.User, error) {
+	user := &models.User{}
+	err := r.db.QueryRow("SELECT id, username, created_at FROM users WHERE calendar_token = $1", tokenHash).Scan(
+		&user.ID,
+		&user.Username,
+		&user.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *Repository) GetCalDAVResources(userID int64) ([]*models.CalDAVResource, error) {
+	rows, err := r.db.Query("SELECT task_id, user_id, name, uid FROM caldav_resources WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resources []*models.CalDAVResource
+	for rows.Next() {
+		res := &models.CalDAVResource{}
+		if err := rows.Scan(&res.TaskID, &res.UserID, &res.Name, &res.UID); err != nil {
+			return nil, err
+		}
+		resources = append(resources, res)
+	}
+
+	return resources, rows.Err()
+}
+
+// SaveCalDAVResource creates or replaces the resource of its task.
+func (r *Repository) SaveCalDAVResource(res *models.CalDAVResource) error {
+	query := `
+		INSERT INTO caldav_resources (task_id, user_id, name, uid)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (task_id) DO UPDATE SET
+		name = EXCLUDED.name,
+		uid = EXCLUDED.uid
+	`
+	_, err := r.db.Exec(query, res.TaskID, res.UserID, res.Name, res.UID)
+	return err
+}
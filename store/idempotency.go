@@ -0,0 +1,90 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+// ClaimIdempotencyKey inserts the key, or takes over an expired or stale
+// one, in a single statement so that concurrent requests cannot both claim
+// it.
+func (r *Repository) ClaimIdempotencyKey(k *models.IdempotencyKey, staleBefore time.Time) (*models.IdempotencyKey, error) {
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, key) DO UPDATE SET
+		fingerprint = EXCLUDED.fingerprint,
+		status_code = 0,
+		content_type = '',
+		body = NULL,
+		created_at = EXCLUDED.created_at,
+		expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+		OR (idempotency_keys.status_code = 0 AND idempotency_keys.created_at < $6)
+		RETURNING user_id
+	`
+	var userID int64
+	err := r.db.QueryRow(query, k.UserID, k.Key, k.Fingerprint, k.CreatedAt, k.ExpiresAt, staleBefore).Scan(&userID)
+	if err == nil {
+		return nil, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	query = `
+		SELECT user_id, key, fingerprint, status_code, content_type, body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+	existing := &models.IdempotencyKey{}
+	err = r.db.QueryRow(query, k.UserID, k.Key).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.Fingerprint,
+		&existing.StatusCode,
+		&existing.ContentType,
+		&existing.Body,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("idempotency key was removed while being claimed")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request that claimed
+// the key.
+func (r *Repository) CompleteIdempotencyKey(k *models.IdempotencyKey) error {
+	query := `
+		UPDATE idempotency_keys SET
+		status_code = $1,
+		content_type = $2,
+		body = $3
+		WHERE user_id = $4 AND key = $5 AND fingerprint = $6
+	`
+	_, err := r.db.Exec(query, k.StatusCode, k.ContentType, k.Body, k.UserID, k.Key, k.Fingerprint)
+	return err
+}
+
+// DeleteIdempotencyKey removes a key, letting the request be run again.
+func (r *Repository) DeleteIdempotencyKey(userID int64, key string) error {
+	_, err := r.db.Exec("DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2", userID, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes the keys expired at now and returns
+// how many there were.
+func (r *Repository) DeleteExpiredIdempotencyKeys(now time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
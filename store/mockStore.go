@@ -1,15 +1,59 @@
 package store
 
-import "github.com/hsrvms/todoapp/models"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/hsrvms/todoapp/models"
+)
+
+// MockStore is an in-memory Store. The zero value is ready to use.
+//
+// Transactions run one at a time: WithTx holds the store for the duration
+// of fn, which works on a copy of the data that replaces the original when
+// fn succeeds. fn must only use the Store it is given.
 type MockStore struct {
-	store []*models.User
+	mu       sync.Mutex
+	users    []*models.User
+	settings map[int64]models.UserSettings
+	tasks    []*models.Task
+	views    []*models.SavedView
+	webhooks []*models.Webhook
+
+	deliveries []*models.WebhookDelivery
+
+	// Sync state, maintained like the tasks_sync trigger of the database.
+	syncSeqs   map[int64]int64
+	taskSyncs  map[int64]taskSync
+	tombstones []tombstone
+
+	idempotencyKeys map[idempotencyKeyID]models.IdempotencyKey
+	rateLimits      map[string]time.Time
+
+	calendarTokens  map[int64]string
+	caldavResources []*models.CalDAVResource
+
+	// inTx is set on the copies handed out by WithTx, which hold the
+	// events notified in the transaction in pending until it commits.
+	inTx    bool
+	pending []*models.TaskEvent
+
+	lastEventID    int64
+	listeners      map[int]func(*models.TaskEvent)
+	nextListenerID int
 }
 
 func NewMockStore() *MockStore {
 	mockStore := &MockStore{}
-	mockStore.store = append(
-		mockStore.store,
+	mockStore.users = append(
+		mockStore.users,
 		&models.User{
 			ID:       1,
 			Username: "testUserLogin",
@@ -19,31 +63,1056 @@ func NewMockStore() *MockStore {
 	return mockStore
 }
 
+func (ms *MockStore) WithTx(ctx context.Context, fn func(Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var tx *MockStore
+	var listeners []func(*models.TaskEvent)
+	err := func() error {
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+
+		tx = ms.clone()
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		ms.users = tx.users
+		ms.settings = tx.settings
+		ms.tasks = tx.tasks
+		ms.views = tx.views
+		ms.webhooks = tx.webhooks
+		ms.deliveries = tx.deliveries
+		ms.syncSeqs = tx.syncSeqs
+		ms.taskSyncs = tx.taskSyncs
+		ms.tombstones = tx.tombstones
+		ms.idempotencyKeys = tx.idempotencyKeys
+		ms.rateLimits = tx.rateLimits
+		ms.calendarTokens = tx.calendarTokens
+		ms.caldavResources = tx.caldavResources
+		if ms.inTx {
+			ms.pending = append(ms.pending, tx.pending...)
+		} else {
+			listeners = ms.commitEvents(tx.pending)
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	deliver(listeners, tx.pending)
+	return nil
+}
+
+// clone copies the data of ms into a new store. The caller must hold ms.mu.
+func (ms *MockStore) clone() *MockStore {
+	c := &MockStore{inTx: true, settings: make(map[int64]models.UserSettings, len(ms.settings))}
+	for _, u := range ms.users {
+		user := *u
+		c.users = append(c.users, &user)
+	}
+	for id, s := range ms.settings {
+		c.settings[id] = s
+	}
+	for _, t := range ms.tasks {
+		task := *t
+		c.tasks = append(c.tasks, &task)
+	}
+	for _, v := range ms.views {
+		view := *v
+		c.views = append(c.views, &view)
+	}
+	for _, h := range ms.webhooks {
+		hook := *h
+		hook.Events = slices.Clone(h.Events)
+		c.webhooks = append(c.webhooks, &hook)
+	}
+	for _, d := range ms.deliveries {
+		delivery := *d
+		c.deliveries = append(c.deliveries, &delivery)
+	}
+	c.syncSeqs = maps.Clone(ms.syncSeqs)
+	c.taskSyncs = maps.Clone(ms.taskSyncs)
+	c.tombstones = slices.Clone(ms.tombstones)
+	c.idempotencyKeys = maps.Clone(ms.idempotencyKeys)
+	c.rateLimits = maps.Clone(ms.rateLimits)
+	c.calendarTokens = maps.Clone(ms.calendarTokens)
+	for _, r := range ms.caldavResources {
+		res := *r
+		c.caldavResources = append(c.caldavResources, &res)
+	}
+	return c
+}
+
+func parseID(id string) (int64, error) {
+	if id == "" {
+		return 0, errors.New("id is empty")
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", id)
+	}
+	return n, nil
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
 // User
 func (ms *MockStore) CreateUser(u *models.User) (*models.User, error) {
-	return &models.User{}, nil
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user := *u
+	user.ID = 1
+	for _, existing := range ms.users {
+		if existing.Username == u.Username {
+			return nil, fmt.Errorf("username %q is taken", u.Username)
+		}
+		if existing.ID >= user.ID {
+			user.ID = existing.ID + 1
+		}
+	}
+	user.CreatedAt = now()
+	ms.users = append(ms.users, &user)
+
+	created := user
+	return &created, nil
 }
 func (ms *MockStore) GetUserByID(id string) (*models.User, error) {
-	return &models.User{}, nil
+	userID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, u := range ms.users {
+		if u.ID == userID {
+			return &models.User{ID: u.ID, Username: u.Username, CreatedAt: u.CreatedAt}, nil
+		}
+	}
+	return nil, nil
 }
 func (ms *MockStore) GetUserByUsername(username string) (*models.User, error) {
-	return &models.User{}, nil
+	if username == "" {
+		return nil, errors.New("username is empty")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, u := range ms.users {
+		if u.Username == username {
+			return &models.User{ID: u.ID, Username: u.Username, Password: u.Password}, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) GetUserSettings(id string) (*models.UserSettings, error) {
+	userID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.findUser(userID) == nil {
+		return nil, nil
+	}
+	settings := ms.settings[userID]
+	return &settings, nil
+}
+func (ms *MockStore) UpdateUserSettings(id string, settings *models.UserSettings) (*models.UserSettings, error) {
+	userID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.findUser(userID) == nil {
+		return nil, nil
+	}
+	if ms.settings == nil {
+		ms.settings = make(map[int64]models.UserSettings)
+	}
+	ms.settings[userID] = *settings
+
+	updated := *settings
+	return &updated, nil
+}
+
+func (ms *MockStore) findUser(id int64) *models.User {
+	for _, u := range ms.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
 }
 
 // Task
 func (ms *MockStore) CreateTask(t *models.Task) (*models.Task, error) {
-	return &models.Task{}, nil
+	if t == nil {
+		return nil, errors.New("task is nil")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	task := *t
+	task.ID = 1
+	for _, existing := range ms.tasks {
+		if existing.ID >= task.ID {
+			task.ID = existing.ID + 1
+		}
+	}
+	task.CreatedAt = now()
+	task.ArchivedAt = nil
+	ms.tasks = append(ms.tasks, &task)
+	ms.recordChange(nil, &task, nil)
+
+	created := task
+	return &created, nil
+}
+
+func (ms *MockStore) GetAllTasks(filter TaskFilter) ([]*models.Task, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tasks := []*models.Task{}
+	for _, t := range ms.tasks {
+		if !filter.match(t) {
+			continue
+		}
+		task := *t
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+func (ms *MockStore) EachTask(filter TaskFilter, fn func(*models.Task) error) error {
+	tasks, err := ms.GetAllTasks(filter)
+	if err != nil {
+		return err
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+
+	for _, task := range tasks {
+		if err := fn(task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (ms *MockStore) GetTaskByID(id string, userID int64) (*models.Task, error) {
+	return ms.modifyTask(id, userID, func(*models.Task) {})
+}
+func (ms *MockStore) UpdateTask(id string, userID int64, t *models.Task) (*models.Task, error) {
+	return ms.changeTask(id, userID, nil, func(task *models.Task) {
+		task.Title = t.Title
+		task.Description = t.Description
+		task.Status = t.Status
+		task.CompletedAt = t.CompletedAt
+		task.DueAt = t.DueAt
+		task.Priority = t.Priority
+	})
+}
+func (ms *MockStore) DeleteTask(id string, userID int64) (*models.Task, error) {
+	taskID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i, t := range ms.tasks {
+		if t.ID == taskID && t.UserID == userID {
+			ms.tasks = append(ms.tasks[:i:i], ms.tasks[i+1:]...)
+			delete(ms.taskSyncs, t.ID)
+			ms.tombstones = append(ms.tombstones, tombstone{taskID: t.ID, userID: t.UserID, seq: ms.nextSyncSeq(t.UserID)})
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) ArchiveTask(id string, userID int64) (*models.Task, error) {
+	return ms.changeTask(id, userID, nil, func(task *models.Task) {
+		if task.ArchivedAt == nil {
+			archivedAt := now()
+			task.ArchivedAt = &archivedAt
+		}
+	})
+}
+func (ms *MockStore) UnarchiveTask(id string, userID int64) (*models.Task, error) {
+	return ms.changeTask(id, userID, nil, func(task *models.Task) {
+		task.ArchivedAt = nil
+	})
+}
+func (ms *MockStore) ArchiveCompletedTasks() ([]*models.Task, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	archivedAt := now()
+	tasks := []*models.Task{}
+	for _, t := range ms.tasks {
+		days := ms.settings[t.UserID].AutoArchiveDays
+		if days <= 0 || t.ArchivedAt != nil || t.CompletedAt == nil {
+			continue
+		}
+		if !t.CompletedAt.Before(archivedAt.AddDate(0, 0, -days)) {
+			continue
+		}
+		before := *t
+		t.ArchivedAt = &archivedAt
+		ms.recordChange(&before, t, nil)
+		task := *t
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+// modifyTask applies fn to the user's task with the given ID and returns a
+// copy of the result, or nil when the user has no such task.
+func (ms *MockStore) modifyTask(id string, userID int64, fn func(*models.Task)) (*models.Task, error) {
+	taskID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, t := range ms.tasks {
+		if t.ID == taskID && t.UserID == userID {
+			fn(t)
+			task := *t
+			return &task, nil
+		}
+	}
+	return nil, nil
+}
+
+// SearchTasks matches every word of the query as a substring of the title
+// or description.
+func (ms *MockStore) SearchTasks(filter TaskFilter, query string, limit int) ([]*models.TaskSearchResult, error) {
+	terms := searchTerms(query)
+	results := []*models.TaskSearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	tasks, err := ms.GetAllTasks(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range tasks {
+		if result, ok := matchTask(task, terms); ok {
+			results = append(results, result)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// Sync
+type taskSync struct {
+	seq   int64
+	times map[string]time.Time
+}
+
+type tombstone struct {
+	taskID, userID, seq int64
+}
+
+func (ms *MockStore) GetTaskChanges(userID, since int64, limit int) (*TaskChanges, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var tasks, tombstones []syncedTask
+	for _, t := range ms.tasks {
+		if seq := ms.taskSyncs[t.ID].seq; t.UserID == userID && seq > since {
+			task := *t
+			tasks = append(tasks, syncedTask{task: &task, seq: seq})
+		}
+	}
+	for _, t := range ms.tombstones {
+		if t.userID == userID && t.seq > since {
+			tombstones = append(tombstones, syncedTask{deletedID: t.taskID, seq: t.seq})
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].seq < tasks[j].seq })
+
+	changes := mergeChanges(tasks, tombstones, limit)
+	if changes.Seq == 0 {
+		changes.Seq = max(ms.syncSeqs[userID], since)
+	}
+	return changes, nil
+}
+func (ms *MockStore) GetTaskFieldTimes(id string) (map[string]time.Time, error) {
+	taskID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, t := range ms.tasks {
+		if t.ID == taskID {
+			times := maps.Clone(ms.taskSyncs[taskID].times)
+			if times == nil {
+				times = map[string]time.Time{}
+			}
+			return times, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) UpdateTaskFields(id string, userID int64, t *models.Task, times map[string]time.Time) (*models.Task, error) {
+	return ms.changeTask(id, userID, times, func(task *models.Task) {
+		task.Title = t.Title
+		task.Description = t.Description
+		task.Status = t.Status
+		task.CompletedAt = t.CompletedAt
+		task.DueAt = t.DueAt
+		task.Priority = t.Priority
+	})
+}
+func (ms *MockStore) IsTaskDeleted(id string, userID int64) (bool, error) {
+	taskID, err := parseID(id)
+	if err != nil {
+		return false, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, t := range ms.tombstones {
+		if t.taskID == taskID && t.userID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// changeTask modifies the task like modifyTask and records the change.
+// times replaces the write times of the synced fields when it is not nil.
+func (ms *MockStore) changeTask(id string, userID int64, times map[string]time.Time, fn func(*models.Task)) (*models.Task, error) {
+	return ms.modifyTask(id, userID, func(task *models.Task) {
+		before := *task
+		fn(task)
+		ms.recordChange(&before, task, times)
+	})
+}
+
+// recordChange gives the task the next sequence number of its owner and
+// stamps the write times of the synced fields that changed. before is nil
+// for new tasks. The caller must hold ms.mu.
+func (ms *MockStore) recordChange(before, task *models.Task, times map[string]time.Time) {
+	if ms.taskSyncs == nil {
+		ms.taskSyncs = make(map[int64]taskSync)
+	}
+
+	state := ms.taskSyncs[task.ID]
+	state.seq = ms.nextSyncSeq(task.UserID)
+	if times != nil {
+		state.times = maps.Clone(times)
+	} else {
+		state.times = maps.Clone(state.times)
+		if state.times == nil {
+			state.times = map[string]time.Time{}
+		}
+		stamp := now()
+		if before == nil || before.Title != task.Title {
+			state.times["title"] = stamp
+		}
+		if before == nil || before.Description != task.Description {
+			state.times["description"] = stamp
+		}
+		if before == nil || before.Status != task.Status {
+			state.times["status"] = stamp
+		}
+	}
+	ms.taskSyncs[task.ID] = state
+}
+
+// nextSyncSeq returns the next sequence number of the user. The caller
+// must hold ms.mu.
+func (ms *MockStore) nextSyncSeq(userID int64) int64 {
+	if ms.syncSeqs == nil {
+		ms.syncSeqs = make(map[int64]int64)
+	}
+	ms.syncSeqs[userID]++
+	return ms.syncSeqs[userID]
+}
+
+// Saved views
+func (ms *MockStore) CreateSavedView(v *models.SavedView) (*models.SavedView, error) {
+	if v == nil {
+		return nil, errors.New("view is nil")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	view := *v
+	view.ID = 1
+	for _, existing := range ms.views {
+		if existing.UserID == v.UserID && existing.Name == v.Name {
+			return nil, fmt.Errorf("view %q already exists", v.Name)
+		}
+		if existing.ID >= view.ID {
+			view.ID = existing.ID + 1
+		}
+	}
+	view.CreatedAt = now()
+	ms.views = append(ms.views, &view)
+
+	created := view
+	return &created, nil
+}
+func (ms *MockStore) GetSavedViews(userID string) ([]*models.SavedView, error) {
+	id, err := parseID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	views := []*models.SavedView{}
+	for _, v := range ms.views {
+		if v.UserID == id {
+			view := *v
+			views = append(views, &view)
+		}
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name < views[j].Name
+	})
+	return views, nil
+}
+func (ms *MockStore) GetSavedViewByID(id string) (*models.SavedView, error) {
+	viewID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, v := range ms.views {
+		if v.ID == viewID {
+			view := *v
+			return &view, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) UpdateSavedView(id string, v *models.SavedView) (*models.SavedView, error) {
+	viewID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var found *models.SavedView
+	for _, existing := range ms.views {
+		if existing.ID == viewID {
+			found = existing
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	for _, existing := range ms.views {
+		if existing.ID != viewID && existing.UserID == found.UserID && existing.Name == v.Name {
+			return nil, fmt.Errorf("view %q already exists", v.Name)
+		}
+	}
+	found.Name = v.Name
+	found.Query = v.Query
+
+	view := *found
+	return &view, nil
 }
+func (ms *MockStore) DeleteSavedView(id string) (*models.SavedView, error) {
+	viewID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 
-func (ms *MockStore) GetAllTasks() ([]*models.Task, error) {
-	return []*models.Task{}, nil
+	for i, v := range ms.views {
+		if v.ID == viewID {
+			ms.views = append(ms.views[:i:i], ms.views[i+1:]...)
+			return v, nil
+		}
+	}
+	return nil, nil
 }
-func (ms *MockStore) GetTaskByID(id string) (*models.Task, error) {
-	return &models.Task{}, nil
+
+// Webhooks
+func (ms *MockStore) CreateWebhook(h *models.Webhook) (*models.Webhook, error) {
+	if h == nil {
+		return nil, errors.New("webhook is nil")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	hook := *h
+	hook.ID = 1
+	for _, existing := range ms.webhooks {
+		if existing.ID >= hook.ID {
+			hook.ID = existing.ID + 1
+		}
+	}
+	hook.Events = slices.Clone(h.Events)
+	hook.CreatedAt = now()
+	ms.webhooks = append(ms.webhooks, &hook)
+
+	created := hook
+	return &created, nil
 }
-func (ms *MockStore) UpdateTask(id string, t *models.Task) (*models.Task, error) {
-	return &models.Task{}, nil
+func (ms *MockStore) GetWebhooks(userID string) ([]*models.Webhook, error) {
+	id, err := parseID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	hooks := []*models.Webhook{}
+	for _, h := range ms.webhooks {
+		if h.UserID == id {
+			hook := *h
+			hooks = append(hooks, &hook)
+		}
+	}
+	return hooks, nil
 }
-func (ms *MockStore) DeleteTask(id string) (*models.Task, error) {
-	return &models.Task{}, nil
+func (ms *MockStore) GetWebhookByID(id string) (*models.Webhook, error) {
+	hookID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, h := range ms.webhooks {
+		if h.ID == hookID {
+			hook := *h
+			return &hook, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) UpdateWebhook(id string, h *models.Webhook) (*models.Webhook, error) {
+	hookID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, existing := range ms.webhooks {
+		if existing.ID == hookID {
+			existing.URL = h.URL
+			existing.Events = slices.Clone(h.Events)
+			if h.Secret != "" {
+				existing.Secret = h.Secret
+			}
+			hook := *existing
+			return &hook, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) DeleteWebhook(id string) (*models.Webhook, error) {
+	hookID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i, h := range ms.webhooks {
+		if h.ID == hookID {
+			ms.webhooks = append(ms.webhooks[:i:i], ms.webhooks[i+1:]...)
+			ms.deliveries = slices.DeleteFunc(ms.deliveries, func(d *models.WebhookDelivery) bool {
+				return d.WebhookID == hookID
+			})
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) GetWebhookDeliveries(webhookID string, status models.WebhookDeliveryStatus, limit int) ([]*models.WebhookDelivery, error) {
+	hookID, err := parseID(webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	deliveries := []*models.WebhookDelivery{}
+	for i := len(ms.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := ms.deliveries[i]
+		if d.WebhookID == hookID && (status == "" || d.Status == status) {
+			delivery := *d
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, nil
+}
+func (ms *MockStore) RetryWebhookDelivery(webhookID, id string) (*models.WebhookDelivery, error) {
+	hookID, err := parseID(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	deliveryID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, d := range ms.deliveries {
+		if d.WebhookID == hookID && d.ID == deliveryID {
+			d.Status = models.DeliveryPending
+			d.Attempts = 0
+			d.NextAttemptAt = now()
+			delivery := *d
+			return &delivery, nil
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) ClaimWebhookDeliveries(at time.Time, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	deliveries := []*models.WebhookDelivery{}
+	for _, d := range ms.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status != models.DeliveryPending || d.NextAttemptAt.After(at) {
+			continue
+		}
+		d.NextAttemptAt = at.Add(lease)
+
+		delivery := *d
+		for _, h := range ms.webhooks {
+			if h.ID == d.WebhookID {
+				delivery.URL, delivery.Secret = h.URL, h.Secret
+			}
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
+func (ms *MockStore) UpdateWebhookDelivery(d *models.WebhookDelivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, existing := range ms.deliveries {
+		if existing.ID == d.ID {
+			existing.Status = d.Status
+			existing.Attempts = d.Attempts
+			existing.NextAttemptAt = d.NextAttemptAt
+			existing.LastStatusCode = d.LastStatusCode
+			existing.LastError = d.LastError
+			existing.DeliveredAt = d.DeliveredAt
+			return nil
+		}
+	}
+	return nil
+}
+
+// enqueueWebhookDeliveries queues the event for every webhook of its user
+// that wants it. The caller must hold ms.mu.
+func (ms *MockStore) enqueueWebhookDeliveries(e *models.TaskEvent) {
+	payload, err := EncodeWebhookPayload(e)
+	if err != nil {
+		return
+	}
+
+	for _, h := range ms.webhooks {
+		if h.UserID != e.UserID || !h.Wants(e.Type) {
+			continue
+		}
+		var id int64 = 1
+		if n := len(ms.deliveries); n > 0 {
+			id = ms.deliveries[n-1].ID + 1
+		}
+		ms.deliveries = append(ms.deliveries, &models.WebhookDelivery{
+			ID:            id,
+			WebhookID:     h.ID,
+			EventType:     e.Type,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: e.CreatedAt,
+			CreatedAt:     e.CreatedAt,
+		})
+	}
+}
+
+// Calendars
+func (ms *MockStore) SetCalendarToken(userID int64, tokenHash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if tokenHash == "" {
+		delete(ms.calendarTokens, userID)
+		return nil
+	}
+	if ms.calendarTokens == nil {
+		ms.calendarTokens = make(map[int64]string)
+	}
+	ms.calendarTokens[userID] = tokenHash
+	return nil
+}
+func (ms *MockStore) GetUserByCalendarToken(tokenHash string) (*models.User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for userID, hash := range ms.calendarTokens {
+		if hash != tokenHash {
+			continue
+		}
+		for _, u := range ms.users {
+			if u.ID == userID {
+				return &models.User{ID: u.ID, Username: u.Username, CreatedAt: u.CreatedAt}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+func (ms *MockStore) GetCalDAVResources(userID int64) ([]*models.CalDAVResource, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var resources []*models.CalDAVResource
+	for _, r := range ms.caldavResources {
+		if r.UserID != userID || !slices.ContainsFunc(ms.tasks, func(t *models.Task) bool { return t.ID == r.TaskID }) {
+			continue
+		}
+		res := *r
+		resources = append(resources, &res)
+	}
+	return resources, nil
+}
+func (ms *MockStore) SaveCalDAVResource(res *models.CalDAVResource) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, r := range ms.caldavResources {
+		if r.UserID == res.UserID && r.Name == res.Name && r.TaskID != res.TaskID {
+			return fmt.Errorf("resource %q exists", res.Name)
+		}
+	}
+
+	saved := *res
+	for i, r := range ms.caldavResources {
+		if r.TaskID == res.TaskID {
+			ms.caldavResources[i] = &saved
+			return nil
+		}
+	}
+	ms.caldavResources = append(ms.caldavResources, &saved)
+	return nil
+}
+
+// Idempotency keys
+type idempotencyKeyID struct {
+	userID int64
+	key    string
+}
+
+func (ms *MockStore) ClaimIdempotencyKey(k *models.IdempotencyKey, staleBefore time.Time) (*models.IdempotencyKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	id := idempotencyKeyID{k.UserID, k.Key}
+	if existing, ok := ms.idempotencyKeys[id]; ok {
+		expired := !existing.ExpiresAt.After(k.CreatedAt)
+		stale := existing.StatusCode == 0 && existing.CreatedAt.Before(staleBefore)
+		if !expired && !stale {
+			return &existing, nil
+		}
+	}
+
+	if ms.idempotencyKeys == nil {
+		ms.idempotencyKeys = make(map[idempotencyKeyID]models.IdempotencyKey)
+	}
+	ms.idempotencyKeys[id] = models.IdempotencyKey{
+		UserID:      k.UserID,
+		Key:         k.Key,
+		Fingerprint: k.Fingerprint,
+		CreatedAt:   k.CreatedAt,
+		ExpiresAt:   k.ExpiresAt,
+	}
+	return nil, nil
+}
+func (ms *MockStore) CompleteIdempotencyKey(k *models.IdempotencyKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	id := idempotencyKeyID{k.UserID, k.Key}
+	if existing, ok := ms.idempotencyKeys[id]; ok && existing.Fingerprint == k.Fingerprint {
+		existing.StatusCode = k.StatusCode
+		existing.ContentType = k.ContentType
+		existing.Body = slices.Clone(k.Body)
+		ms.idempotencyKeys[id] = existing
+	}
+	return nil
+}
+func (ms *MockStore) DeleteIdempotencyKey(userID int64, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.idempotencyKeys, idempotencyKeyID{userID, key})
+	return nil
+}
+func (ms *MockStore) DeleteExpiredIdempotencyKeys(at time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deleted int64
+	for id, k := range ms.idempotencyKeys {
+		if !k.ExpiresAt.After(at) {
+			delete(ms.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Rate limits
+func (ms *MockStore) TakeRateLimit(key string, interval, burst time.Duration, now time.Time) (time.Time, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tat := ms.rateLimits[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	if next.After(now.Add(burst)) {
+		return tat, false, nil
+	}
+
+	if ms.rateLimits == nil {
+		ms.rateLimits = make(map[string]time.Time)
+	}
+	ms.rateLimits[key] = next
+	return next, true, nil
+}
+func (ms *MockStore) DeleteExpiredRateLimits(now time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deleted int64
+	for key, tat := range ms.rateLimits {
+		if !tat.After(now) {
+			delete(ms.rateLimits, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// NotifyTaskEvent delivers the event to the functions registered with
+// Listen, once the transaction commits when called inside WithTx.
+func (ms *MockStore) NotifyTaskEvent(e *models.TaskEvent) error {
+	ms.mu.Lock()
+
+	event := *e
+	if ms.inTx {
+		ms.pending = append(ms.pending, &event)
+		ms.mu.Unlock()
+		return nil
+	}
+
+	events := []*models.TaskEvent{&event}
+	listeners := ms.commitEvents(events)
+	ms.mu.Unlock()
+
+	deliver(listeners, events)
+	return nil
+}
+
+// Listen calls publish with every event committed through NotifyTaskEvent
+// until the context is cancelled.
+func (ms *MockStore) Listen(ctx context.Context, publish func(*models.TaskEvent)) error {
+	ms.mu.Lock()
+	if ms.listeners == nil {
+		ms.listeners = make(map[int]func(*models.TaskEvent))
+	}
+	id := ms.nextListenerID
+	ms.nextListenerID++
+	ms.listeners[id] = publish
+	ms.mu.Unlock()
+
+	<-ctx.Done()
+
+	ms.mu.Lock()
+	delete(ms.listeners, id)
+	ms.mu.Unlock()
+	return nil
+}
+
+// commitEvents numbers the events, queues them for the webhooks and
+// returns the listeners to deliver them to. The caller must hold ms.mu.
+func (ms *MockStore) commitEvents(events []*models.TaskEvent) []func(*models.TaskEvent) {
+	for _, e := range events {
+		ms.lastEventID++
+		e.ID = ms.lastEventID
+		e.CreatedAt = now()
+		ms.enqueueWebhookDeliveries(e)
+	}
+
+	listeners := make([]func(*models.TaskEvent), 0, len(ms.listeners))
+	for _, listener := range ms.listeners {
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
+
+func deliver(listeners []func(*models.TaskEvent), events []*models.TaskEvent) {
+	for _, e := range events {
+		for _, listener := range listeners {
+			event := *e
+			listener(&event)
+		}
+	}
 }
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+func TestMockStoreWithTx(t *testing.T) {
+	errFail := errors.New("fail")
+
+	testCases := []struct {
+		name     string
+		fn       func(tx Store) error
+		expErr   error
+		expTasks int
+	}{
+		{
+			name: "commit",
+			fn: func(tx Store) error {
+				_, err := tx.CreateTask(&models.Task{UserID: 1, Title: "committed"})
+				return err
+			},
+			expTasks: 1,
+		},
+		{
+			name: "rollback",
+			fn: func(tx Store) error {
+				if _, err := tx.CreateTask(&models.Task{UserID: 1, Title: "rolled back"}); err != nil {
+					return err
+				}
+				return errFail
+			},
+			expErr:   errFail,
+			expTasks: 0,
+		},
+		{
+			name: "nested rollback",
+			fn: func(tx Store) error {
+				if _, err := tx.CreateTask(&models.Task{UserID: 1, Title: "outer"}); err != nil {
+					return err
+				}
+				err := tx.WithTx(context.Background(), func(tx Store) error {
+					if _, err := tx.CreateTask(&models.Task{UserID: 1, Title: "inner"}); err != nil {
+						return err
+					}
+					return errFail
+				})
+				if !errors.Is(err, errFail) {
+					t.Errorf("nested WithTx: got %v want %v", err, errFail)
+				}
+				return nil
+			},
+			expTasks: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := NewMockStore()
+
+			err := ms.WithTx(context.Background(), tc.fn)
+			if !errors.Is(err, tc.expErr) {
+				t.Errorf("got error %v want %v", err, tc.expErr)
+			}
+
+			tasks, err := ms.GetAllTasks(TaskFilter{UserID: 1})
+			if err != nil {
+				t.Fatalf("failed to get tasks: %v", err)
+			}
+			if len(tasks) != tc.expTasks {
+				t.Errorf("got %d tasks want %d", len(tasks), tc.expTasks)
+			}
+		})
+	}
+}
+
+func TestMockStoreNotifyTaskEvent(t *testing.T) {
+	ms := &MockStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan *models.TaskEvent, 10)
+	go ms.Listen(ctx, func(e *models.TaskEvent) { received <- e })
+	for {
+		ms.mu.Lock()
+		listening := len(ms.listeners) > 0
+		ms.mu.Unlock()
+		if listening {
+			break
+		}
+	}
+
+	notify := func(tx Store) error {
+		return tx.NotifyTaskEvent(&models.TaskEvent{Type: models.TaskCreated, UserID: 1})
+	}
+	ms.WithTx(ctx, func(tx Store) error {
+		notify(tx)
+		return errors.New("fail")
+	})
+	if err := ms.WithTx(ctx, notify); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-received
+	if e.ID != 1 || e.Type != models.TaskCreated {
+		t.Errorf("got event %d %q want 1 %q", e.ID, e.Type, models.TaskCreated)
+	}
+	select {
+	case e := <-received:
+		t.Errorf("got unexpected event %d", e.ID)
+	default:
+	}
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+// TaskEventsChannel is the PostgreSQL notification channel carrying task
+// events between instances.
+const TaskEventsChannel = "task_events"
+
+// maxNotifyPayload keeps notifications under the 8000 byte payload limit
+// of pg_notify.
+const maxNotifyPayload = 7900
+
+// NotifyTaskEvent sends the event to the listeners of every instance and
+// queues it for the user's webhooks. Inside a transaction PostgreSQL
+// delivers it only once the transaction commits.
+// The event is given its ID from the task_events_id_seq sequence so that it
+// is the same on every instance.
+func (r *Repository) NotifyTaskEvent(e *models.TaskEvent) error {
+	if err := r.db.QueryRow("SELECT nextval('task_events_id_seq')").Scan(&e.ID); err != nil {
+		return err
+	}
+	e.CreatedAt = time.Now().UTC()
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload && e.Task != nil {
+		partial := *e
+		partial.Task = &models.Task{ID: e.Task.ID, UserID: e.Task.UserID}
+		partial.Partial = true
+		if payload, err = json.Marshal(&partial); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.db.Exec("SELECT pg_notify($1, $2)", TaskEventsChannel, string(payload)); err != nil {
+		return err
+	}
+
+	return r.enqueueWebhookDeliveries(e)
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TakeRateLimit advances the theoretical arrival time of the key in a
+// single statement, so that the requests of every server instance count
+// against the same bucket.
+func (r *Repository) TakeRateLimit(key string, interval, burst time.Duration, now time.Time) (time.Time, bool, error) {
+	query := `
+		INSERT INTO rate_limits (key, tat)
+		VALUES ($1, $2::timestamptz + $3 * INTERVAL '1 microsecond')
+		ON CONFLICT (key) DO UPDATE SET
+		tat = GREATEST(rate_limits.tat, $2) + $3 * INTERVAL '1 microsecond'
+		WHERE GREATEST(rate_limits.tat, $2) + $3 * INTERVAL '1 microsecond'
+			<= $2::timestamptz + $4 * INTERVAL '1 microsecond'
+		RETURNING tat
+	`
+	var tat time.Time
+	err := r.db.QueryRow(query, key, now, interval.Microseconds(), burst.Microseconds()).Scan(&tat)
+	if err == nil {
+		return tat, true, nil
+	} else if err != sql.ErrNoRows {
+		return time.Time{}, false, err
+	}
+
+	err = r.db.QueryRow("SELECT tat FROM rate_limits WHERE key = $1", key).Scan(&tat)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, errors.New("rate limit was removed while being taken")
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	return tat, false, nil
+}
+
+// DeleteExpiredRateLimits removes the keys whose bucket refilled by now and
+// returns how many there were.
+func (r *Repository) DeleteExpiredRateLimits(now time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM rate_limits WHERE tat <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+// SearchTasks matches the query against the title and description of the
+// tasks using the search_vector column. Every word of the query must match,
+// either fully or as the prefix of a word in the task. Results are ordered
+// by rank.
+func (r *Repository) SearchTasks(filter TaskFilter, query string, limit int) ([]*models.TaskSearchResult, error) {
+	tsQuery := prefixTSQuery(query)
+	if tsQuery == "" {
+		return []*models.TaskSearchResult{}, nil
+	}
+
+	where, args := filter.where()
+	args = append(args, tsQuery, limit)
+	sqlQuery := fmt.Sprintf(`
+		SELECT `+taskColumns+`,
+		ts_rank(search_vector, q) AS rank,
+		ts_headline('english', title || ' ' || description, q,
+			'StartSel=`+highlightStart+`, StopSel=`+highlightStop+`, MaxWords=30, MinWords=10')
+		FROM tasks, to_tsquery('english', $%d) q
+		WHERE %s AND search_vector @@ q
+		ORDER BY rank DESC, id
+		LIMIT $%d
+	`, len(args)-1, where, len(args))
+	rows, err := r.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*models.TaskSearchResult{}
+	for rows.Next() {
+		result := &models.TaskSearchResult{}
+		task, err := scanTask(rows, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		result.Task = task
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchTerms splits a search query into lower-cased words, dropping
+// punctuation and operators.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixTSQuery turns a search query into a tsquery matching documents that
+// contain every word as a prefix, e.g. "learn go" becomes "learn:* & go:*".
+func prefixTSQuery(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// matchTask is the search used by stores without full-text support. Every
+// term must occur in the title or description; matches in the title weigh
+// more than matches in the description.
+func matchTask(task *models.Task, terms []string) (*models.TaskSearchResult, bool) {
+	title := strings.ToLower(task.Title)
+	description := strings.ToLower(task.Description)
+
+	rank := 0.0
+	for _, term := range terms {
+		inTitle := strings.Contains(title, term)
+		inDescription := strings.Contains(description, term)
+		if !inTitle && !inDescription {
+			return nil, false
+		}
+		if inTitle {
+			rank += 1
+		}
+		if inDescription {
+			rank += 0.4
+		}
+	}
+
+	return &models.TaskSearchResult{
+		Task:    task,
+		Rank:    rank / float64(len(terms)),
+		Snippet: highlight(task.Title+" "+task.Description, terms),
+	}, true
+}
+
+// highlight wraps every occurrence of the terms in text with the highlight
+// markers. The terms must be lower case.
+func highlight(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Lower-casing changed byte offsets; leave the text unmarked
+		// rather than splitting a character.
+		return text
+	}
+
+	marked := make([]bool, len(text))
+	for _, term := range terms {
+		for start := 0; ; {
+			i := strings.Index(lower[start:], term)
+			if i < 0 {
+				break
+			}
+			for j := start + i; j < start+i+len(term); j++ {
+				marked[j] = true
+			}
+			start += i + len(term)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(highlightStart)
+		}
+		b.WriteByte(text[i])
+		if marked[i] && (i == len(text)-1 || !marked[i+1]) {
+			b.WriteString(highlightStop)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,27 @@
+package store
+
+import "testing"
+
+func TestPrefixTSQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		exp   string
+	}{
+		{query: "", exp: ""},
+		{query: "golang", exp: "golang:*"},
+		{query: "Learn  Go!", exp: "learn:* & go:*"},
+		{query: "a & b | !c:*", exp: "a:* & b:* & c:*"},
+	} {
+		if got := prefixTSQuery(tc.query); got != tc.exp {
+			t.Errorf("prefixTSQuery(%q): got %q want %q", tc.query, got, tc.exp)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("Learn Golang, go!", []string{"go"})
+	exp := "Learn <mark>Go</mark>lang, <mark>go</mark>!"
+	if got != exp {
+		t.Errorf("got %q want %q", got, exp)
+	}
+}
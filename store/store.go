@@ -1,11 +1,16 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/hsrvms/todoapp/models"
+	"github.com/lib/pq"
 )
 
 type Store interface {
@@ -13,21 +18,236 @@ type Store interface {
 	CreateUser(u *models.User) (*models.User, error)
 	GetUserByID(id string) (*models.User, error)
 	GetUserByUsername(username string) (*models.User, error)
+	GetUserSettings(id string) (*models.UserSettings, error)
+	UpdateUserSettings(id string, settings *models.UserSettings) (*models.UserSettings, error)
 
 	// Task
 	CreateTask(t *models.Task) (*models.Task, error)
-	GetAllTasks() ([]*models.Task, error)
-	GetTaskByID(id string) (*models.Task, error)
-	UpdateTask(id string, t *models.Task) (*models.Task, error)
-	DeleteTask(id string) (*models.Task, error)
+	GetAllTasks(filter TaskFilter) ([]*models.Task, error)
+	// EachTask calls fn with the tasks matched by the filter in ID order as
+	// they are read, without loading them all, and stops at the first
+	// error.
+	EachTask(filter TaskFilter, fn func(*models.Task) error) error
+	// GetTaskByID, UpdateTask, DeleteTask, ArchiveTask and UnarchiveTask
+	// only find the tasks of the user, and return nil for those of others.
+	GetTaskByID(id string, userID int64) (*models.Task, error)
+	UpdateTask(id string, userID int64, t *models.Task) (*models.Task, error)
+	DeleteTask(id string, userID int64) (*models.Task, error)
+	ArchiveTask(id string, userID int64) (*models.Task, error)
+	UnarchiveTask(id string, userID int64) (*models.Task, error)
+	ArchiveCompletedTasks() ([]*models.Task, error)
+	// NotifyTaskEvent sends the event to real-time clients and queues it
+	// for the user's webhooks.
+	NotifyTaskEvent(e *models.TaskEvent) error
+	SearchTasks(filter TaskFilter, query string, limit int) ([]*models.TaskSearchResult, error)
+
+	// Sync
+	GetTaskChanges(userID, since int64, limit int) (*TaskChanges, error)
+	GetTaskFieldTimes(id string) (map[string]time.Time, error)
+	UpdateTaskFields(id string, userID int64, t *models.Task, times map[string]time.Time) (*models.Task, error)
+	IsTaskDeleted(id string, userID int64) (bool, error)
+
+	// Saved views
+	CreateSavedView(v *models.SavedView) (*models.SavedView, error)
+	GetSavedViews(userID string) ([]*models.SavedView, error)
+	GetSavedViewByID(id string) (*models.SavedView, error)
+	UpdateSavedView(id string, v *models.SavedView) (*models.SavedView, error)
+	DeleteSavedView(id string) (*models.SavedView, error)
+
+	// Webhooks
+	CreateWebhook(h *models.Webhook) (*models.Webhook, error)
+	GetWebhooks(userID string) ([]*models.Webhook, error)
+	GetWebhookByID(id string) (*models.Webhook, error)
+	UpdateWebhook(id string, h *models.Webhook) (*models.Webhook, error)
+	DeleteWebhook(id string) (*models.Webhook, error)
+	GetWebhookDeliveries(webhookID string, status models.WebhookDeliveryStatus, limit int) ([]*models.WebhookDelivery, error)
+	RetryWebhookDelivery(webhookID, id string) (*models.WebhookDelivery, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries due at
+	// now and postpones them by lease, so that other instances skip them
+	// while they are being sent.
+	ClaimWebhookDeliveries(now time.Time, limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	// UpdateWebhookDelivery records the outcome of an attempt.
+	UpdateWebhookDelivery(d *models.WebhookDelivery) error
+
+	// Calendars
+	SetCalendarToken(userID int64, tokenHash string) error
+	GetUserByCalendarToken(tokenHash string) (*models.User, error)
+	GetCalDAVResources(userID int64) ([]*models.CalDAVResource, error)
+	SaveCalDAVResource(res *models.CalDAVResource) error
+
+	// Idempotency keys
+	//
+	// ClaimIdempotencyKey stores k as in progress and returns nil, unless
+	// the user already has an unexpired key of that name that is complete
+	// or was claimed after staleBefore, which is returned instead.
+	ClaimIdempotencyKey(k *models.IdempotencyKey, staleBefore time.Time) (*models.IdempotencyKey, error)
+	CompleteIdempotencyKey(k *models.IdempotencyKey) error
+	DeleteIdempotencyKey(userID int64, key string) error
+	DeleteExpiredIdempotencyKeys(now time.Time) (int64, error)
+
+	// Rate limits
+	//
+	// TakeRateLimit admits a request of the key at now if its theoretical
+	// arrival time, advanced by interval, is at most burst past now. It
+	// returns the time after the request, and whether it was admitted.
+	TakeRateLimit(key string, interval, burst time.Duration, now time.Time) (tat time.Time, allowed bool, err error)
+	// DeleteExpiredRateLimits removes the keys whose bucket refilled by now.
+	DeleteExpiredRateLimits(now time.Time) (int64, error)
+
+	// WithTx runs fn with a Store whose calls all belong to one transaction.
+	// The transaction is committed when fn returns nil and rolled back
+	// otherwise. Calling WithTx on that Store nests a savepoint, so a
+	// failing nested fn only discards its own changes. fn may be run again
+	// when the transaction has to be retried and must not have side effects
+	// outside the Store.
+	WithTx(ctx context.Context, fn func(Store) error) error
 }
+
+// TaskFilter narrows the tasks returned by GetAllTasks and SearchTasks.
+// The zero value of every field other than UserID matches all tasks.
+type TaskFilter struct {
+	// UserID restricts the result to the tasks owned by the user.
+	UserID int64
+	// Archived selects archived tasks instead of the active ones.
+	Archived bool
+	// IncludeArchived selects archived and active tasks alike, ignoring
+	// Archived.
+	IncludeArchived bool
+	// Statuses restricts the result to tasks in one of the statuses.
+	Statuses []models.TaskStatus
+	// CreatedAfter and CreatedBefore bound the creation time. The lower
+	// bound is inclusive and the upper bound exclusive.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// CompletedAfter and CompletedBefore bound the completion time in the
+	// same way. Setting either leaves out tasks that are not completed.
+	CompletedAfter  *time.Time
+	CompletedBefore *time.Time
+}
+
+// where returns the SQL condition selecting the tasks matched by the filter
+// and its arguments, numbered from $1.
+func (f TaskFilter) where() (string, []any) {
+	conditions := []string{"user_id = $1", "($2 OR (archived_at IS NOT NULL) = $3)"}
+	args := []any{f.UserID, f.IncludeArchived, f.Archived}
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if len(f.Statuses) > 0 {
+		statuses := make([]string, len(f.Statuses))
+		for i, status := range f.Statuses {
+			statuses[i] = string(status)
+		}
+		add("status = ANY($%d)", pq.Array(statuses))
+	}
+	if f.CreatedAfter != nil {
+		add("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		add("created_at < $%d", *f.CreatedBefore)
+	}
+	if f.CompletedAfter != nil {
+		add("completed_at >= $%d", *f.CompletedAfter)
+	}
+	if f.CompletedBefore != nil {
+		add("completed_at < $%d", *f.CompletedBefore)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// match reports whether the task is selected by the filter.
+func (f TaskFilter) match(t *models.Task) bool {
+	if t.UserID != f.UserID || (!f.IncludeArchived && (t.ArchivedAt != nil) != f.Archived) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, t.Status) {
+		return false
+	}
+	if f.CreatedAfter != nil || f.CreatedBefore != nil {
+		if !inRange(t.CreatedAt, f.CreatedAfter, f.CreatedBefore) {
+			return false
+		}
+	}
+	if f.CompletedAfter != nil || f.CompletedBefore != nil {
+		if t.CompletedAt == nil || !inRange(*t.CompletedAt, f.CompletedAfter, f.CompletedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+func inRange(t time.Time, after, before *time.Time) bool {
+	return (after == nil || !t.Before(*after)) && (before == nil || t.Before(*before))
+}
+
+const taskColumns = "id, user_id, title, description, status, created_at, completed_at, archived_at, due_at, priority"
+
+const (
+	createTaskQuery = `
+		INSERT INTO tasks (user_id, title, description, status, completed_at, due_at, priority)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + taskColumns
+	updateTaskQuery = `
+		UPDATE tasks SET
+		title = $1,
+		description = $2,
+		status = $3,
+		completed_at = $4,
+		due_at = $5,
+		priority = $6
+		WHERE id = $7 AND user_id = $8
+		RETURNING ` + taskColumns
+	deleteTaskQuery = `
+		DELETE FROM tasks
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + taskColumns
+)
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTask scans a row selected with taskColumns. extra receives the
+// columns selected after them.
+func scanTask(row rowScanner, extra ...any) (*models.Task, error) {
+	task := &models.Task{}
+	var userID sql.NullInt64
+	dest := []any{
+		&task.ID,
+		&userID,
+		&task.Title,
+		&task.Description,
+		&task.Status,
+		&task.CreatedAt,
+		&task.CompletedAt,
+		&task.ArchivedAt,
+		&task.DueAt,
+		&task.Priority,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	task.UserID = userID.Int64
+
+	return task, nil
+}
+
 type Repository struct {
-	db *sql.DB
+	// db runs the queries. It is the pool itself, or the transaction for
+	// a Repository handed out by WithTx.
+	db   querier
+	pool *sql.DB
+	tx   *sql.Tx
+	// depth is the savepoint nesting level within tx.
+	depth int
 }
 
 // NewRepository creates a new Repository instance.
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: db, pool: db}
 }
 
 // CreateUser creates a new user in the repository.
@@ -96,35 +316,74 @@ func (r *Repository) GetUserByUsername(username string) (*models.User, error) {
 	return user, nil
 }
 
-func (r *Repository) CreateTask(t *models.Task) (*models.Task, error) {
-	if t == nil {
-		return nil, fmt.Errorf("task is nil")
+// GetUserSettings retrieves the settings of the user with the given ID.
+func (r *Repository) GetUserSettings(id string) (*models.UserSettings, error) {
+	if id == "" {
+		return nil, fmt.Errorf("id is empty")
 	}
 
+	settings := &models.UserSettings{}
 	query := `
-		INSERT INTO tasks (title, description, status)
-		VALUES ($1, $2, $3)
-		RETURNING id
+		SELECT auto_archive_days
+		FROM users
+		WHERE id = $1
 	`
-	stmt, err := r.db.Prepare(query)
-	if err != nil {
+	err := r.db.QueryRow(query, id).Scan(&settings.AutoArchiveDays)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(t.Title, t.Description, t.Status).Scan(&t.ID)
+	return settings, nil
+}
+
+// UpdateUserSettings replaces the settings of the user with the given ID.
+func (r *Repository) UpdateUserSettings(id string, settings *models.UserSettings) (*models.UserSettings, error) {
+	if id == "" {
+		return nil, fmt.Errorf("id is empty")
+	}
+
+	updated := &models.UserSettings{}
+	query := `
+		UPDATE users SET
+		auto_archive_days = $1
+		WHERE id = $2
+		RETURNING auto_archive_days
+	`
+	err := r.db.QueryRow(query, settings.AutoArchiveDays, id).Scan(&updated.AutoArchiveDays)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (r *Repository) CreateTask(t *models.Task) (*models.Task, error) {
+	if t == nil {
+		return nil, fmt.Errorf("task is nil")
+	}
+
+	stmt, err := r.db.Prepare(createTaskQuery)
 	if err != nil {
 		return nil, err
 	}
+	defer stmt.Close()
 
-	return t, nil
+	return scanTask(stmt.QueryRow(t.UserID, t.Title, t.Description, t.Status, t.CompletedAt, t.DueAt, t.Priority))
 }
 
-func (r *Repository) GetAllTasks() ([]*models.Task, error) {
+func (r *Repository) GetAllTasks(filter TaskFilter) ([]*models.Task, error) {
+	where, args := filter.where()
 	query := `
-		SELECT * FROM tasks
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE ` + where + `
+		ORDER BY id
 	`
-	rows, err := r.db.Query(query)
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -132,14 +391,8 @@ func (r *Repository) GetAllTasks() ([]*models.Task, error) {
 
 	var tasks []*models.Task
 	for rows.Next() {
-		task := &models.Task{}
-		if err := rows.Scan(
-			&task.ID,
-			&task.Title,
-			&task.Description,
-			&task.Status,
-			&task.CreatedAt,
-		); err != nil {
+		task, err := scanTask(rows)
+		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, task)
@@ -151,27 +404,46 @@ func (r *Repository) GetAllTasks() ([]*models.Task, error) {
 	return tasks, nil
 }
 
-func (r *Repository) GetTaskByID(id string) (*models.Task, error) {
+func (r *Repository) EachTask(filter TaskFilter, fn func(*models.Task) error) error {
+	where, args := filter.where()
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE ` + where + `
+		ORDER BY id
+	`
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(task); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *Repository) GetTaskByID(id string, userID int64) (*models.Task, error) {
 	if id == "" {
 		return nil, errors.New("task ID cannot be empty")
 	}
 
-	query := "SELECT * FROM tasks WHERE id = $1"
+	query := "SELECT " + taskColumns + " FROM tasks WHERE id = $1 AND user_id = $2"
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	task := &models.Task{}
-	err = stmt.QueryRow(id).Scan(
-		&task.ID,
-		&task.Title,
-		&task.Description,
-		&task.Status,
-		&task.CreatedAt,
-	)
-
+	task, err := scanTask(stmt.QueryRow(id, userID))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
@@ -180,30 +452,14 @@ func (r *Repository) GetTaskByID(id string) (*models.Task, error) {
 
 	return task, nil
 }
-func (r *Repository) UpdateTask(id string, t *models.Task) (*models.Task, error) {
-	query := `
-		UPDATE tasks SET
-		title = $1,
-		description = $2,
-		status = $3
-		WHERE id = $4
-		RETURNING id, title, description, status, created_at
-	`
-	stmt, err := r.db.Prepare(query)
+func (r *Repository) UpdateTask(id string, userID int64, t *models.Task) (*models.Task, error) {
+	stmt, err := r.db.Prepare(updateTaskQuery)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	task := &models.Task{}
-	err = stmt.QueryRow(t.Title, t.Description, t.Status, id).Scan(
-		&task.ID,
-		&task.Title,
-		&task.Description,
-		&task.Status,
-		&task.CreatedAt,
-	)
-
+	task, err := scanTask(stmt.QueryRow(t.Title, t.Description, t.Status, t.CompletedAt, t.DueAt, t.Priority, id, userID))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
@@ -212,27 +468,88 @@ func (r *Repository) UpdateTask(id string, t *models.Task) (*models.Task, error)
 
 	return task, nil
 }
-func (r *Repository) DeleteTask(taskID string) (*models.Task, error) {
-	query := `
-		DELETE FROM tasks
-		WHERE id = $1
-		RETURNING id, title
-	`
-	stmt, err := r.db.Prepare(query)
+func (r *Repository) DeleteTask(taskID string, userID int64) (*models.Task, error) {
+	stmt, err := r.db.Prepare(deleteTaskQuery)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	task := &models.Task{}
-	err = stmt.QueryRow(taskID).Scan(
-		&task.ID,
-		&task.Title,
-	)
-
+	task, err := scanTask(stmt.QueryRow(taskID, userID))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 
 	return task, err
 }
+
+// ArchiveTask marks the task as archived. Archiving an archived task keeps
+// its original archival time.
+func (r *Repository) ArchiveTask(id string, userID int64) (*models.Task, error) {
+	query := `
+		UPDATE tasks SET
+		archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + taskColumns
+
+	task, err := scanTask(r.db.QueryRow(query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// UnarchiveTask moves an archived task back to the active list.
+func (r *Repository) UnarchiveTask(id string, userID int64) (*models.Task, error) {
+	query := `
+		UPDATE tasks SET
+		archived_at = NULL
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + taskColumns
+
+	task, err := scanTask(r.db.QueryRow(query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// ArchiveCompletedTasks archives every task that was completed longer ago
+// than its owner's auto-archive setting and returns the archived tasks.
+func (r *Repository) ArchiveCompletedTasks() ([]*models.Task, error) {
+	query := `
+		UPDATE tasks t SET
+		archived_at = CURRENT_TIMESTAMP
+		FROM users u
+		WHERE t.user_id = u.id
+		AND u.auto_archive_days > 0
+		AND t.archived_at IS NULL
+		AND t.completed_at < CURRENT_TIMESTAMP - make_interval(days => u.auto_archive_days)
+		RETURNING t.id, t.user_id, t.title, t.description, t.status, t.created_at, t.completed_at, t.archived_at, t.due_at, t.priority
+	`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*models.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+// TaskChanges lists the changes to the tasks of a user in the order they
+// were made.
+type TaskChanges struct {
+	// Tasks holds the changed tasks as they are now.
+	Tasks []*models.Task
+	// Deleted holds the IDs of the deleted tasks.
+	Deleted []int64
+	// Seq is the sequence number of the last change listed, or of the
+	// user's last change when nothing is listed.
+	Seq int64
+	// HasMore is set when the changes were cut at the limit.
+	HasMore bool
+}
+
+// GetTaskChanges returns up to limit changes made to the user's tasks after
+// the sequence number since. A negative since lists every task.
+func (r *Repository) GetTaskChanges(userID, since int64, limit int) (*TaskChanges, error) {
+	query := `
+		SELECT ` + taskColumns + `, sync_seq
+		FROM tasks
+		WHERE user_id = $1 AND sync_seq > $2
+		ORDER BY sync_seq
+		LIMIT $3
+	`
+	rows, err := r.db.Query(query, userID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []syncedTask
+	for rows.Next() {
+		var seq int64
+		task, err := scanTask(rows, &seq)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, syncedTask{task: task, seq: seq})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT task_id, sync_seq
+		FROM task_tombstones
+		WHERE user_id = $1 AND sync_seq > $2
+		ORDER BY sync_seq
+		LIMIT $3
+	`
+	rows, err = r.db.Query(query, userID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tombstones []syncedTask
+	for rows.Next() {
+		var t syncedTask
+		if err := rows.Scan(&t.deletedID, &t.seq); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	changes := mergeChanges(tasks, tombstones, limit)
+	if changes.Seq == 0 {
+		if err := r.db.QueryRow("SELECT sync_seq FROM users WHERE id = $1", userID).Scan(&changes.Seq); err != nil {
+			return nil, err
+		}
+		changes.Seq = max(changes.Seq, since)
+	}
+
+	return changes, nil
+}
+
+// syncedTask is a changed task, or a deleted one when deletedID is set.
+type syncedTask struct {
+	task      *models.Task
+	deletedID int64
+	seq       int64
+}
+
+// mergeChanges lists the first limit changes of tasks and tombstones, both
+// ordered by sequence number.
+func mergeChanges(tasks, tombstones []syncedTask, limit int) *TaskChanges {
+	changes := &TaskChanges{Tasks: []*models.Task{}, Deleted: []int64{}}
+	for len(tasks) > 0 || len(tombstones) > 0 {
+		if len(changes.Tasks)+len(changes.Deleted) == limit {
+			changes.HasMore = true
+			break
+		}
+
+		var next syncedTask
+		if len(tombstones) == 0 || (len(tasks) > 0 && tasks[0].seq < tombstones[0].seq) {
+			next, tasks = tasks[0], tasks[1:]
+			changes.Tasks = append(changes.Tasks, next.task)
+		} else {
+			next, tombstones = tombstones[0], tombstones[1:]
+			changes.Deleted = append(changes.Deleted, next.deletedID)
+		}
+		changes.Seq = next.seq
+	}
+
+	return changes
+}
+
+// GetTaskFieldTimes returns when each synced field of the task was last
+// written, or nil when there is no such task.
+func (r *Repository) GetTaskFieldTimes(id string) (map[string]time.Time, error) {
+	var data []byte
+	err := r.db.QueryRow("SELECT field_times FROM tasks WHERE id = $1", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	times := map[string]time.Time{}
+	if err := json.Unmarshal(data, &times); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// UpdateTaskFields updates the task like UpdateTask and records the given
+// write times of its synced fields instead of the current time.
+func (r *Repository) UpdateTaskFields(id string, userID int64, t *models.Task, times map[string]time.Time) (*models.Task, error) {
+	data, err := json.Marshal(times)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE tasks SET
+		title = $1,
+		description = $2,
+		status = $3,
+		completed_at = $4,
+		due_at = $5,
+		priority = $6,
+		field_times = $7
+		WHERE id = $8 AND user_id = $9
+		RETURNING ` + taskColumns
+
+	task, err := scanTask(r.db.QueryRow(query, t.Title, t.Description, t.Status, t.CompletedAt, t.DueAt, t.Priority, data, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// IsTaskDeleted reports whether the user's task has been deleted.
+func (r *Repository) IsTaskDeleted(id string, userID int64) (bool, error) {
+	if id == "" {
+		return false, errors.New("task ID cannot be empty")
+	}
+
+	var deleted bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM task_tombstones WHERE task_id = $1 AND user_id = $2)", id, userID).Scan(&deleted)
+	return deleted, err
+}
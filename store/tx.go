@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSerializationFailure is returned by a nested WithTx when the
+// transaction hit a serialization failure. The enclosing fn must return
+// it so that the whole transaction is retried.
+var ErrSerializationFailure = errors.New("serialization failure")
+
+// maxTxRetries is how many times a transaction that failed to serialize is
+// retried before the error is returned.
+const maxTxRetries = 3
+
+// txRetryBackoff is the delay before the first retry. It doubles on every
+// following attempt.
+const txRetryBackoff = 10 * time.Millisecond
+
+// querier is the part of *sql.DB and *sql.Tx used by the Repository.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn in a serializable transaction and retries it when
+// PostgreSQL reports a serialization failure (SQLSTATE 40001).
+func (r *Repository) WithTx(ctx context.Context, fn func(Store) error) error {
+	if r.tx != nil {
+		return r.withSavepoint(fn)
+	}
+
+	backoff := txRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := r.runTx(ctx, fn)
+		if err == nil || !isSerializationFailure(err) || attempt == maxTxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (r *Repository) runTx(ctx context.Context, fn func(Store) error) error {
+	tx, err := r.pool.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Repository{db: tx, pool: r.pool, tx: tx}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) withSavepoint(fn func(Store) error) error {
+	nested := &Repository{db: r.tx, pool: r.pool, tx: r.tx, depth: r.depth + 1}
+	savepoint := fmt.Sprintf("sp_%d", nested.depth)
+
+	if _, err := r.tx.Exec("SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+
+	if err := fn(nested); err != nil {
+		if isSerializationFailure(err) {
+			return fmt.Errorf("%w: %v", ErrSerializationFailure, err)
+		}
+		if _, rbErr := r.tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+
+	_, err := r.tx.Exec("RELEASE SAVEPOINT " + savepoint)
+	return err
+}
+
+func isSerializationFailure(err error) bool {
+	if errors.Is(err, ErrSerializationFailure) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+const savedViewColumns = "id, user_id, name, query, created_at"
+
+func scanSavedView(row rowScanner) (*models.SavedView, error) {
+	view := &models.SavedView{}
+	if err := row.Scan(
+		&view.ID,
+		&view.UserID,
+		&view.Name,
+		&view.Query,
+		&view.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return view, nil
+}
+
+// CreateSavedView stores a new saved view.
+func (r *Repository) CreateSavedView(v *models.SavedView) (*models.SavedView, error) {
+	if v == nil {
+		return nil, errors.New("view is nil")
+	}
+
+	query := `
+		INSERT INTO saved_views (user_id, name, query)
+		VALUES ($1, $2, $3)
+		RETURNING ` + savedViewColumns
+
+	return scanSavedView(r.db.QueryRow(query, v.UserID, v.Name, v.Query))
+}
+
+// GetSavedViews retrieves the saved views of a user ordered by name.
+func (r *Repository) GetSavedViews(userID string) ([]*models.SavedView, error) {
+	query := `
+		SELECT ` + savedViewColumns + `
+		FROM saved_views
+		WHERE user_id = $1
+		ORDER BY name
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []*models.SavedView{}
+	for rows.Next() {
+		view, err := scanSavedView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return views, nil
+}
+
+// GetSavedViewByID retrieves a saved view by its ID.
+func (r *Repository) GetSavedViewByID(id string) (*models.SavedView, error) {
+	if id == "" {
+		return nil, errors.New("view ID cannot be empty")
+	}
+
+	query := "SELECT " + savedViewColumns + " FROM saved_views WHERE id = $1"
+	view, err := scanSavedView(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return view, nil
+}
+
+// UpdateSavedView renames a saved view and replaces its query.
+func (r *Repository) UpdateSavedView(id string, v *models.SavedView) (*models.SavedView, error) {
+	query := `
+		UPDATE saved_views SET
+		name = $1,
+		query = $2
+		WHERE id = $3
+		RETURNING ` + savedViewColumns
+
+	view, err := scanSavedView(r.db.QueryRow(query, v.Name, v.Query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return view, nil
+}
+
+// DeleteSavedView removes a saved view.
+func (r *Repository) DeleteSavedView(id string) (*models.SavedView, error) {
+	query := `
+		DELETE FROM saved_views
+		WHERE id = $1
+		RETURNING ` + savedViewColumns
+
+	view, err := scanSavedView(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return view, err
+}
@@ -0,0 +1,303 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/lib/pq"
+)
+
+const webhookColumns = "id, user_id, url, events, secret, created_at"
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+	var events []string
+	if err := row.Scan(
+		&hook.ID,
+		&hook.UserID,
+		&hook.URL,
+		pq.Array(&events),
+		&hook.Secret,
+		&hook.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	hook.Events = make([]models.TaskEventType, len(events))
+	for i, event := range events {
+		hook.Events[i] = models.TaskEventType(event)
+	}
+
+	return hook, nil
+}
+
+func webhookEvents(h *models.Webhook) []string {
+	events := make([]string, len(h.Events))
+	for i, event := range h.Events {
+		events[i] = string(event)
+	}
+	return events
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_type, payload, status, attempts,
+	next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(row rowScanner, extra ...any) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var payload []byte
+	dest := []any{
+		&d.ID,
+		&d.WebhookID,
+		&d.EventType,
+		&payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.LastStatusCode,
+		&d.LastError,
+		&d.CreatedAt,
+		&d.DeliveredAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+
+	return d, nil
+}
+
+// CreateWebhook stores a new webhook.
+func (r *Repository) CreateWebhook(h *models.Webhook) (*models.Webhook, error) {
+	if h == nil {
+		return nil, errors.New("webhook is nil")
+	}
+
+	query := `
+		INSERT INTO webhooks (user_id, url, events, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + webhookColumns
+
+	return scanWebhook(r.db.QueryRow(query, h.UserID, h.URL, pq.Array(webhookEvents(h)), h.Secret))
+}
+
+// GetWebhooks retrieves the webhooks of a user in creation order.
+func (r *Repository) GetWebhooks(userID string) ([]*models.Webhook, error) {
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []*models.Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// GetWebhookByID retrieves a webhook by its ID.
+func (r *Repository) GetWebhookByID(id string) (*models.Webhook, error) {
+	if id == "" {
+		return nil, errors.New("webhook ID cannot be empty")
+	}
+
+	query := "SELECT " + webhookColumns + " FROM webhooks WHERE id = $1"
+	hook, err := scanWebhook(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+// UpdateWebhook replaces the URL and events of a webhook, and its secret
+// when a new one is given.
+func (r *Repository) UpdateWebhook(id string, h *models.Webhook) (*models.Webhook, error) {
+	query := `
+		UPDATE webhooks SET
+		url = $1,
+		events = $2,
+		secret = COALESCE(NULLIF($3, ''), secret)
+		WHERE id = $4
+		RETURNING ` + webhookColumns
+
+	hook, err := scanWebhook(r.db.QueryRow(query, h.URL, pq.Array(webhookEvents(h)), h.Secret, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+// DeleteWebhook removes a webhook along with its deliveries.
+func (r *Repository) DeleteWebhook(id string) (*models.Webhook, error) {
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1
+		RETURNING ` + webhookColumns
+
+	hook, err := scanWebhook(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return hook, err
+}
+
+// EncodeWebhookPayload encodes the events queued for webhooks. Package
+// services sets it to encode them as the API serves them.
+var EncodeWebhookPayload = func(e *models.TaskEvent) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// enqueueWebhookDeliveries queues the event for every webhook of its user
+// that wants it.
+func (r *Repository) enqueueWebhookDeliveries(e *models.TaskEvent) error {
+	payload, err := EncodeWebhookPayload(e)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+		SELECT id, $2, $3
+		FROM webhooks
+		WHERE user_id = $1 AND (cardinality(events) = 0 OR $2 = ANY(events))
+	`
+	_, err = r.db.Exec(query, e.UserID, string(e.Type), payload)
+	return err
+}
+
+// GetWebhookDeliveries retrieves the latest deliveries of a webhook, newest
+// first. An empty status matches every delivery.
+func (r *Repository) GetWebhookDeliveries(webhookID string, status models.WebhookDeliveryStatus, limit int) ([]*models.WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+	rows, err := r.db.Query(query, webhookID, string(status), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery queues a delivery of the webhook again with a fresh
+// set of attempts.
+func (r *Repository) RetryWebhookDelivery(webhookID, id string) (*models.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET
+		status = 'pending',
+		attempts = 0,
+		next_attempt_at = now()
+		WHERE webhook_id = $1 AND id = $2
+		RETURNING ` + webhookDeliveryColumns
+
+	d, err := scanWebhookDelivery(r.db.QueryRow(query, webhookID, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// ClaimWebhookDeliveries locks the due deliveries with SKIP LOCKED, so that
+// concurrent instances claim different ones, and pushes their next attempt
+// back by lease. A delivery whose sender dies is retried once the lease
+// expires.
+func (r *Repository) ClaimWebhookDeliveries(now time.Time, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d SET
+		next_attempt_at = $3
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts,
+			d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at,
+			w.url, w.secret
+	`
+	rows, err := r.db.Query(query, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		var url, secret string
+		d, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+		d.URL, d.Secret = url, secret
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (r *Repository) UpdateWebhookDelivery(d *models.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries SET
+		status = $1,
+		attempts = $2,
+		next_attempt_at = $3,
+		last_status_code = $4,
+		last_error = $5,
+		delivered_at = $6
+		WHERE id = $7
+	`
+	_, err := r.db.Exec(query, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, d.DeliveredAt, d.ID)
+	return err
+}
@@ -0,0 +1,113 @@
+// Package todotxt reads and writes tasks in the todo.txt format
+// (https://github.com/todotxt/todo.txt).
+//
+// A line such as
+//
+//	x (A) 2024-04-14 2024-04-12 Call the bank +Finances @phone due:2024-04-15
+//
+// is a task that is done, of priority A, completed on 2024-04-14 and due on
+// 2024-04-15. Projects (+Finances) and contexts (@phone) stay in the title.
+// Completed tasks may keep their priority in a pri:A key. Descriptions
+// have no place in the format and are not written.
+package todotxt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+const dateLayout = "2006-01-02"
+
+var ErrEmptyTask = errors.New("task text is empty")
+
+// Parse parses a line into a task. Done tasks get models.StatusDone and
+// their completion date, if given.
+func Parse(line string) (*models.Task, error) {
+	fields := strings.Fields(line)
+	task := &models.Task{Status: models.StatusTodo}
+
+	if len(fields) > 0 && fields[0] == "x" {
+		task.Status = models.StatusDone
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && isPriority(fields[0]) {
+		task.Priority = fields[0][1:2]
+		fields = fields[1:]
+	}
+	if task.Status == models.StatusDone && len(fields) > 0 {
+		if completed, err := time.Parse(dateLayout, fields[0]); err == nil {
+			task.CompletedAt = &completed
+			fields = fields[1:]
+		}
+	}
+	// The creation date cannot be kept, the server sets it.
+	if len(fields) > 0 {
+		if _, err := time.Parse(dateLayout, fields[0]); err == nil {
+			fields = fields[1:]
+		}
+	}
+
+	words := fields[:0]
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, ":")
+		switch {
+		case ok && key == "due":
+			due, err := time.Parse(dateLayout, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid due date %q", value)
+			}
+			task.DueAt = &due
+		case ok && key == "pri" && len(value) == 1 && isPriority("("+value+")"):
+			task.Priority = value
+		default:
+			words = append(words, field)
+		}
+	}
+
+	task.Title = strings.Join(words, " ")
+	if task.Title == "" {
+		return nil, ErrEmptyTask
+	}
+
+	return task, nil
+}
+
+// Format formats the task as a line. Tasks in a done status of
+// models.DefaultWorkflow are written as done.
+func Format(t *models.Task) string {
+	var parts []string
+	done := models.DefaultWorkflow.IsDone(t.Status)
+	if done {
+		parts = append(parts, "x")
+		if t.CompletedAt != nil {
+			parts = append(parts, t.CompletedAt.UTC().Format(dateLayout))
+		}
+	} else if t.Priority != "" {
+		parts = append(parts, "("+t.Priority+")")
+	}
+	// A completion date must be followed by the creation date.
+	if !t.CreatedAt.IsZero() {
+		parts = append(parts, t.CreatedAt.UTC().Format(dateLayout))
+	} else if done && t.CompletedAt != nil {
+		parts = append(parts, t.CompletedAt.UTC().Format(dateLayout))
+	}
+
+	parts = append(parts, strings.Fields(t.Title)...)
+	if t.DueAt != nil {
+		parts = append(parts, "due:"+t.DueAt.UTC().Format(dateLayout))
+	}
+	if done && t.Priority != "" {
+		parts = append(parts, "pri:"+t.Priority)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// isPriority reports whether s is a priority such as "(A)".
+func isPriority(s string) bool {
+	return len(s) == 3 && s[0] == '(' && s[1] >= 'A' && s[1] <= 'Z' && s[2] == ')'
+}
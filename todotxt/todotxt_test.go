@@ -0,0 +1,95 @@
+package todotxt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+)
+
+func TestParse(t *testing.T) {
+	date := func(s string) *time.Time {
+		d, _ := time.Parse(dateLayout, s)
+		return &d
+	}
+
+	testCases := []struct {
+		name string
+		line string
+		exp  models.Task
+	}{
+		{
+			name: "plain",
+			line: "Learn Golang",
+			exp:  models.Task{Title: "Learn Golang", Status: models.StatusTodo},
+		},
+		{
+			name: "priority, creation date and keys",
+			line: "(B) 2024-04-12 Call the bank +Finances @phone due:2024-04-15",
+			exp:  models.Task{Title: "Call the bank +Finances @phone", Status: models.StatusTodo, Priority: "B", DueAt: date("2024-04-15")},
+		},
+		{
+			name: "done",
+			line: "x 2024-04-14 2024-04-12 Call the bank pri:A",
+			exp:  models.Task{Title: "Call the bank", Status: models.StatusDone, Priority: "A", CompletedAt: date("2024-04-14")},
+		},
+		{
+			name: "not a priority",
+			line: "(b) Learn Go url:https://go.dev",
+			exp:  models.Task{Title: "(b) Learn Go url:https://go.dev", Status: models.StatusTodo},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			task, err := Parse(tc.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if task.Title != tc.exp.Title || task.Status != tc.exp.Status || task.Priority != tc.exp.Priority {
+				t.Errorf("got %+v want %+v", task, tc.exp)
+			}
+			if !equalTime(task.DueAt, tc.exp.DueAt) || !equalTime(task.CompletedAt, tc.exp.CompletedAt) {
+				t.Errorf("got due %v completed %v want %v %v", task.DueAt, task.CompletedAt, tc.exp.DueAt, tc.exp.CompletedAt)
+			}
+		})
+	}
+
+	for _, line := range []string{"", "x 2024-04-14", "Learn Go due:tomorrow"} {
+		if _, err := Parse(line); err == nil {
+			t.Errorf("Parse(%q): expected an error", line)
+		}
+	}
+}
+
+func equalTime(a, b *time.Time) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+}
+
+func TestFormat(t *testing.T) {
+	completed := time.Date(2024, 4, 14, 9, 0, 0, 0, time.UTC)
+	due := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		task models.Task
+		exp  string
+	}{
+		{
+			task: models.Task{Title: "Call the bank +Finances", Status: models.StatusTodo, Priority: "B", CreatedAt: time.Date(2024, 4, 12, 18, 2, 27, 924693000, time.UTC), DueAt: &due},
+			exp:  "(B) 2024-04-12 Call the bank +Finances due:2024-04-15",
+		},
+		{
+			task: models.Task{Title: "Learn\nGolang", Status: models.StatusDone, Priority: "A", CompletedAt: &completed},
+			exp:  "x 2024-04-14 2024-04-14 Learn Golang pri:A",
+		},
+	}
+
+	for _, tc := range testCases {
+		line := Format(&tc.task)
+		if line != tc.exp {
+			t.Errorf("got %q want %q", line, tc.exp)
+		}
+		if _, err := Parse(line); err != nil {
+			t.Errorf("Parse(%q): %v", line, err)
+		}
+	}
+}
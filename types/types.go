@@ -1,5 +1,9 @@
 package types
 
+import "github.com/hsrvms/todoapp/validate"
+
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Fields lists the failures of the fields of an invalid payload.
+	Fields validate.Errors `json:"fields,omitempty"`
 }
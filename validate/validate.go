@@ -0,0 +1,143 @@
+// Package validate checks the fields of request payloads, collecting every
+// failure so that clients can fix them all at once.
+//
+//	v := validate.New()
+//	task.Title = validate.Normalize(task.Title)
+//	v.Check("title", task.Title != "", ErrTitleRequired)
+//	v.MaxLength("title", task.Title, 255)
+//	if err := v.Err(); err != nil {
+//		...
+//	}
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrTooShort       = errors.New("too short")
+	ErrTooLong        = errors.New("too long")
+	ErrInvalidChars   = errors.New("invalid characters")
+	ErrUnknownField   = errors.New("unknown field")
+	ErrInvalidPayload = errors.New("invalid request payload")
+)
+
+// FieldError is the failure of a field. It wraps the error it was added
+// with, so that errors.Is matches it.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	err error
+}
+
+func (e *FieldError) Error() string { return e.Message }
+
+func (e *FieldError) Unwrap() error { return e.err }
+
+// Errors lists the failures of a payload in the order the fields were
+// checked.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, fe := range e {
+		errs[i] = fe
+	}
+	return errs
+}
+
+// Validator collects the failures of the fields of a payload.
+type Validator struct {
+	errs Errors
+}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+// Add records the failure of the field. The error's message is shown to
+// clients, so it should name the field.
+func (v *Validator) Add(field string, err error) {
+	v.errs = append(v.errs, &FieldError{Field: field, Message: err.Error(), err: err})
+}
+
+// Check records the failure of the field unless ok.
+func (v *Validator) Check(field string, ok bool, err error) {
+	if !ok {
+		v.Add(field, err)
+	}
+}
+
+// MinLength records ErrTooShort if s has fewer than min characters.
+func (v *Validator) MinLength(field, s string, min int) {
+	if utf8.RuneCountInString(s) < min {
+		v.errs = append(v.errs, &FieldError{
+			Field:   field,
+			Message: fmt.Sprintf("%s must be at least %d characters", field, min),
+			err:     ErrTooShort,
+		})
+	}
+}
+
+// MaxLength records ErrTooLong if s has more than max characters.
+func (v *Validator) MaxLength(field, s string, max int) {
+	if utf8.RuneCountInString(s) > max {
+		v.errs = append(v.errs, &FieldError{
+			Field:   field,
+			Message: fmt.Sprintf("%s must be at most %d characters", field, max),
+			err:     ErrTooLong,
+		})
+	}
+}
+
+// MaxBytes records ErrTooLong if s is longer than max bytes in UTF-8.
+func (v *Validator) MaxBytes(field, s string, max int) {
+	if len(s) > max {
+		v.errs = append(v.errs, &FieldError{
+			Field:   field,
+			Message: fmt.Sprintf("%s must be at most %d bytes", field, max),
+			err:     ErrTooLong,
+		})
+	}
+}
+
+// Chars records ErrInvalidChars if s has a character not allowed by ok.
+// allowed describes the allowed characters in the message.
+func (v *Validator) Chars(field, s string, ok func(r rune) bool, allowed string) {
+	if strings.IndexFunc(s, func(r rune) bool { return !ok(r) }) >= 0 {
+		v.errs = append(v.errs, &FieldError{
+			Field:   field,
+			Message: fmt.Sprintf("%s may only contain %s", field, allowed),
+			err:     ErrInvalidChars,
+		})
+	}
+}
+
+// Err returns the failures as Errors, or nil if there were none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Normalize trims the white space around s and puts it in Unicode
+// Normalization Form C, so that equal texts are stored and compared the
+// same way whichever way clients composed them.
+func Normalize(s string) string {
+	return strings.TrimSpace(norm.NFC.String(s))
+}
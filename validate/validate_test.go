@@ -0,0 +1,38 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+)
+
+var errNameRequired = errors.New("name is required")
+
+func TestValidator(t *testing.T) {
+	v := New()
+	v.Check("name", false, errNameRequired)
+	v.MaxLength("title", "héllo", 4)
+	v.MinLength("password", "abc", 8)
+	v.MaxBytes("password", "héllo", 5)
+	v.Chars("username", "a b", func(r rune) bool { return r != ' ' }, "no spaces")
+	v.MaxLength("ok", "héllo", 5)
+
+	err := v.Err()
+	want := "name is required; title must be at most 4 characters; password must be at least 8 characters; " +
+		"password must be at most 5 bytes; username may only contain no spaces"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %s", err, want)
+	}
+	if !errors.Is(err, errNameRequired) || !errors.Is(err, ErrTooLong) || !errors.Is(err, ErrInvalidChars) {
+		t.Error("errors.Is does not match the field errors")
+	}
+
+	if err := New().Err(); err != nil {
+		t.Errorf("no failures: got %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize(" Cafe\u0301\n"); got != "Caf\u00e9" {
+		t.Errorf("Normalize = %q", got)
+	}
+}
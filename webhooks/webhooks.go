@@ -0,0 +1,301 @@
+// Package webhooks delivers task events to the URLs subscribed by users.
+//
+// Events are queued in the store by Store.NotifyTaskEvent, in the same
+// transaction as the change they describe, and sent by a Dispatcher.
+// Failed deliveries are retried with exponential backoff until they are
+// dead-lettered.
+//
+// Each delivery is a POST of the event as JSON with the headers:
+//
+//	X-Webhook-Event: task.updated
+//	X-Webhook-Delivery: 42
+//	X-Webhook-Signature: t=1712944947,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// v1 is the hex encoded HMAC-SHA256 of the timestamp t, a dot and the body,
+// keyed with the webhook's secret. Receivers check it with Verify.
+//
+// Deliveries are only sent to public addresses, see PublicAddr, so that
+// webhooks cannot reach the services of the server's network. Redirects
+// are not followed.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// MaxAttempts is the number of attempts after which a delivery is
+	// dead-lettered.
+	MaxAttempts = 8
+	// baseBackoff is the delay before the second attempt. It doubles with
+	// every further attempt up to maxBackoff.
+	baseBackoff = time.Minute
+	maxBackoff  = 6 * time.Hour
+
+	// batchSize is the number of deliveries claimed and sent at once.
+	batchSize = 20
+	// claimLease must exceed the time taken to send a batch, after which
+	// the deliveries of a crashed instance are claimed again.
+	claimLease = 2 * time.Minute
+	// requestTimeout bounds a single attempt.
+	requestTimeout = 10 * time.Second
+	// maxErrorLength caps the error recorded for a failed attempt.
+	maxErrorLength = 500
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// nonPublicPrefixes are the special-purpose ranges PublicAddr rejects on top
+// of those recognised by netip.Addr.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// Shared address space of carrier-grade NAT, where some clouds also
+	// serve their metadata.
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// NAT64 translates these to IPv4 addresses, private ones included.
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// PublicAddr reports whether deliveries may be sent to the address. It
+// rejects the loopback, private, link-local, multicast and unspecified
+// addresses, which include the metadata services of clouds such as
+// 169.254.169.254 and fd00:ec2::254, and other special-purpose ranges.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dispatcher sends the queued deliveries.
+type Dispatcher struct {
+	store  store.Store
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewDispatcher creates a Dispatcher sending the deliveries queued in store
+// to public addresses.
+func NewDispatcher(store store.Store) *Dispatcher {
+	return newDispatcher(store, PublicAddr)
+}
+
+// newDispatcher creates a Dispatcher only connecting to the addresses
+// allowed.
+func newDispatcher(store store.Store, allowed func(netip.Addr) bool) *Dispatcher {
+	// The address is checked once resolved, right before connecting, so
+	// that host names resolving to a public address when the webhook is
+	// created and to a private one later are caught too.
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allowed(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	return &Dispatcher{
+		store: store,
+		client: &http.Client{
+			// No proxy is used, as the proxy rather than the dialer would
+			// connect to the address.
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   requestTimeout,
+				ResponseHeaderTimeout: requestTimeout,
+				MaxIdleConnsPerHost:   2,
+				IdleConnTimeout:       90 * time.Second,
+			},
+			// A redirect could lead to an address of the server's network,
+			// so a redirect response fails the delivery like other 3xx.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Timeout: requestTimeout,
+		},
+		now: func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// DeliverPending sends the deliveries that are due, a batch at a time,
+// until none are left. It returns the number of attempts made.
+func (d *Dispatcher) DeliverPending(ctx context.Context) (int, error) {
+	attempts := 0
+	for ctx.Err() == nil {
+		deliveries, err := d.store.ClaimWebhookDeliveries(d.now(), batchSize, claimLease)
+		if err != nil {
+			return attempts, err
+		}
+		if len(deliveries) == 0 {
+			return attempts, nil
+		}
+
+		errs := make([]error, len(deliveries))
+		var wg sync.WaitGroup
+		for i, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.attempt(ctx, delivery)
+				errs[i] = d.store.UpdateWebhookDelivery(delivery)
+			}()
+		}
+		wg.Wait()
+
+		attempts += len(deliveries)
+		if err := errors.Join(errs...); err != nil {
+			return attempts, err
+		}
+	}
+
+	return attempts, ctx.Err()
+}
+
+// attempt sends the delivery once and records the outcome on it.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	delivery.Attempts++
+	delivery.LastStatusCode = 0
+	delivery.LastError = ""
+
+	statusCode, err := d.send(ctx, delivery)
+	delivery.LastStatusCode = statusCode
+	now := d.now()
+
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= MaxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.LastError = truncate(err.Error(), maxErrorLength)
+	default:
+		delivery.Status = models.DeliveryPending
+		delivery.NextAttemptAt = now.Add(Backoff(delivery.Attempts))
+		delivery.LastError = truncate(err.Error(), maxErrorLength)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todoapp-webhooks/1")
+	req.Header.Set(EventHeader, string(delivery.EventType))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, d.now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Backoff returns the delay before the attempt following the given number
+// of failed ones.
+func Backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// Sign returns the signature header of a body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// Verify checks the signature header of a body against the secret. The
+// signature must have been made within tolerance of now, which guards
+// against replays.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(t, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
@@ -0,0 +1,221 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsrvms/todoapp/models"
+	"github.com/hsrvms/todoapp/store"
+)
+
+// allowAll lets the dispatchers of the tests deliver to the local receivers.
+func allowAll(netip.Addr) bool { return true }
+
+// notify commits a task event for user 1 through st.
+func notify(t *testing.T, st store.Store, eventType models.TaskEventType) {
+	t.Helper()
+	err := st.WithTx(context.Background(), func(tx store.Store) error {
+		return tx.NotifyTaskEvent(&models.TaskEvent{Type: eventType, UserID: 1, Task: &models.Task{ID: 7, UserID: 1, Title: "Learn Golang"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	const secret = "testSecret"
+	received := make(chan *models.TaskEvent, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header.Get(SignatureHeader), body, time.Now(), 5*time.Minute); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+		if r.Header.Get(EventHeader) != string(models.TaskCreated) {
+			t.Errorf("got event header %q", r.Header.Get(EventHeader))
+		}
+
+		e := &models.TaskEvent{}
+		if err := json.Unmarshal(body, e); err != nil {
+			t.Error(err)
+		}
+		received <- e
+	}))
+	defer receiver.Close()
+
+	st := &store.MockStore{}
+	st.CreateWebhook(&models.Webhook{UserID: 1, URL: receiver.URL, Secret: secret})
+	st.CreateWebhook(&models.Webhook{UserID: 1, URL: receiver.URL, Secret: secret, Events: []models.TaskEventType{models.TaskDeleted}})
+	st.CreateWebhook(&models.Webhook{UserID: 2, URL: receiver.URL, Secret: secret})
+	notify(t, st, models.TaskCreated)
+
+	attempts, err := newDispatcher(st, allowAll).DeliverPending(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("got %d attempts want 1", attempts)
+	}
+
+	e := <-received
+	if e.Type != models.TaskCreated || e.Task.ID != 7 {
+		t.Errorf("got event %q for task %d", e.Type, e.Task.ID)
+	}
+
+	deliveries, _ := st.GetWebhookDeliveries("1", "", 10)
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliveryDelivered || deliveries[0].DeliveredAt == nil {
+		t.Errorf("got deliveries %+v", deliveries)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	st := &store.MockStore{}
+	st.CreateWebhook(&models.Webhook{UserID: 1, URL: receiver.URL, Secret: "testSecret"})
+	notify(t, st, models.TaskUpdated)
+
+	clock := time.Now().UTC()
+	d := newDispatcher(st, allowAll)
+	d.now = func() time.Time { return clock }
+
+	for i := 1; i <= MaxAttempts; i++ {
+		if _, err := d.DeliverPending(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// Nothing is due before the backoff has elapsed.
+		if attempts, _ := d.DeliverPending(context.Background()); attempts != 0 {
+			t.Fatalf("attempt %d: retried before the backoff", i)
+		}
+
+		delivery, _ := st.GetWebhookDeliveries("1", "", 1)
+		if delivery[0].Attempts != i || delivery[0].LastStatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d: got %d attempts, status %d", i, delivery[0].Attempts, delivery[0].LastStatusCode)
+		}
+		if i < MaxAttempts {
+			if delivery[0].Status != models.DeliveryPending || !delivery[0].NextAttemptAt.Equal(clock.Add(Backoff(i))) {
+				t.Fatalf("attempt %d: got %s next at %v", i, delivery[0].Status, delivery[0].NextAttemptAt)
+			}
+			clock = clock.Add(Backoff(i))
+		} else if delivery[0].Status != models.DeliveryDead {
+			t.Fatalf("attempt %d: got %s want %s", i, delivery[0].Status, models.DeliveryDead)
+		}
+	}
+
+	if calls != MaxAttempts {
+		t.Errorf("got %d calls want %d", calls, MaxAttempts)
+	}
+
+	if _, err := st.RetryWebhookDelivery("1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	dead, _ := st.GetWebhookDeliveries("1", models.DeliveryDead, 10)
+	if len(dead) != 0 {
+		t.Errorf("got %d dead deliveries after retry want 0", len(dead))
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.100.100.200", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+	} {
+		if got := PublicAddr(netip.MustParseAddr(tc.addr)); got != tc.want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestDispatcherRejectsPrivateAddresses(t *testing.T) {
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer receiver.Close()
+
+	st := &store.MockStore{}
+	// A host name resolving to a loopback address is caught once resolved.
+	url := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	st.CreateWebhook(&models.Webhook{UserID: 1, URL: url, Secret: "testSecret"})
+	notify(t, st, models.TaskCreated)
+
+	if _, err := NewDispatcher(st).DeliverPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, _ := st.GetWebhookDeliveries("1", "", 1)
+	if calls != 0 || delivery[0].Status != models.DeliveryPending || !strings.Contains(delivery[0].LastError, ErrForbiddenAddress.Error()) {
+		t.Errorf("got %d calls, delivery %s with error %q", calls, delivery[0].Status, delivery[0].LastError)
+	}
+}
+
+func TestDispatcherDoesNotFollowRedirects(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	receiver := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer receiver.Close()
+
+	st := &store.MockStore{}
+	st.CreateWebhook(&models.Webhook{UserID: 1, URL: receiver.URL, Secret: "testSecret"})
+	notify(t, st, models.TaskCreated)
+
+	if _, err := newDispatcher(st, allowAll).DeliverPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, _ := st.GetWebhookDeliveries("1", "", 1)
+	if redirected || delivery[0].Status != models.DeliveryPending || delivery[0].LastStatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("redirect followed: %v, delivery %s with status %d", redirected, delivery[0].Status, delivery[0].LastStatusCode)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		expDelay time.Duration
+	}{
+		{attempts: 1, expDelay: time.Minute},
+		{attempts: 2, expDelay: 2 * time.Minute},
+		{attempts: 5, expDelay: 16 * time.Minute},
+		{attempts: 20, expDelay: maxBackoff},
+	}
+
+	for _, tc := range testCases {
+		if delay := Backoff(tc.attempts); delay != tc.expDelay {
+			t.Errorf("Backoff(%d): got %v want %v", tc.attempts, delay, tc.expDelay)
+		}
+	}
+}